@@ -0,0 +1,260 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+// ProductMeta describes which parts of a Product could not be fetched, and
+// how old any cached parts are. It is left out when there is nothing to say.
+type ProductMeta struct {
+	Errors          []SourceError  `json:"errors,omitempty"`
+	CacheAgeSeconds map[string]int `json:"cache_age_seconds,omitempty"`
+}
+
+// Built in product sources, as named in ProductMeta
+const (
+	SourcePrice     = "price"
+	SourceName      = "name"
+	SourcePromotion = "promotion"
+)
+
+// Kinds of SourceError
+const (
+	SourceErrorNotFound      = "not_found"
+	SourceErrorTimeout       = "timeout"
+	SourceErrorUpstreamError = "upstream_error"
+)
+
+// SourceError reports that a source failed to contribute to a Product
+type SourceError struct {
+	Source string `json:"source"`
+	Kind   string `json:"kind"`
+}
+
+func newSourceError(source string, err error) SourceError {
+	var netErr net.Error
+
+	kind := SourceErrorUpstreamError
+	switch {
+	case isNotFound(err):
+		kind = SourceErrorNotFound
+
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		kind = SourceErrorTimeout
+	}
+
+	return SourceError{
+		Source: source,
+		Kind:   kind,
+	}
+}
+
+// ProductQuery is what a client asked for about a product
+type ProductQuery struct {
+	ProductID int
+
+	// CurrencyCode is the currency prices should be given in. Sources use
+	// the product's base currency when it is empty.
+	CurrencyCode string
+
+	// At is the time the product should be described at, for previewing
+	// scheduled changes. It is zero for now.
+	At time.Time
+}
+
+// ProductSource contributes named fields to a Product. Sources are fetched
+// concurrently, so Fetch must be safe for concurrent use. A source which
+// does not know the product should return ErrProductNotFound.
+type ProductSource interface {
+	Fetch(ctx context.Context, query ProductQuery) (SourceResult, error)
+}
+
+// ProductSourceFunc adapts a function to a ProductSource
+type ProductSourceFunc func(ctx context.Context, query ProductQuery) (SourceResult, error)
+
+// Fetch calls f(ctx, query)
+func (f ProductSourceFunc) Fetch(ctx context.Context, query ProductQuery) (SourceResult, error) {
+	return f(ctx, query)
+}
+
+// SourceResult is what a ProductSource contributes to a Product
+type SourceResult struct {
+	// Fields are added to the product under their keys
+	Fields map[string]interface{}
+
+	// CacheAge is how old the data is, when it was served from a cache
+	CacheAge time.Duration
+}
+
+// SourceOptions controls how a registered ProductSource is fetched
+type SourceOptions struct {
+	// Timeout is the source's deadline, which is not enforced when zero. A
+	// source which misses it is left out of the product.
+	Timeout time.Duration
+
+	// Required sources must succeed for the product to be returned at all
+	Required bool
+
+	// Auxiliary sources only add to what the other sources found, so they
+	// are not counted when deciding whether the product exists
+	Auxiliary bool
+}
+
+type registeredSource struct {
+	name    string
+	source  ProductSource
+	options SourceOptions
+}
+
+// RegisterSource adds a source to those fetched for every product. The name
+// identifies the source in the product's metadata, and must be unique;
+// RegisterSource panics if it is not.
+func (rh *RequestHandler) RegisterSource(name string, source ProductSource, options SourceOptions) {
+	for _, registered := range rh.sources {
+		if registered.name == name {
+			panic("productaggregate: source " + name + " registered twice")
+		}
+	}
+
+	rh.sources = append(rh.sources, registeredSource{
+		name:    name,
+		source:  source,
+		options: options,
+	})
+}
+
+// sourceOutcome is what a single registered source produced for a request
+type sourceOutcome struct {
+	name      string
+	required  bool
+	auxiliary bool
+	result    SourceResult
+	err       error
+}
+
+// fetchSources runs every source concurrently and gathers their outcomes,
+// in the same order as sources. A source which misses its deadline is
+// reported as timed out, even if it does not honor its context.
+func fetchSources(ctx context.Context, query ProductQuery, sources []registeredSource) []sourceOutcome {
+	type indexedOutcome struct {
+		index   int
+		outcome sourceOutcome
+	}
+
+	// Buffered, so that abandoned sources can always finish
+	outcomesCh := make(chan indexedOutcome, len(sources))
+	for i, source := range sources {
+		go func(i int, source registeredSource) {
+			outcomesCh <- indexedOutcome{
+				index:   i,
+				outcome: fetchSource(ctx, query, source),
+			}
+		}(i, source)
+	}
+
+	outcomes := make([]sourceOutcome, len(sources))
+	for range sources {
+		o := <-outcomesCh
+		outcomes[o.index] = o.outcome
+	}
+
+	return outcomes
+}
+
+func fetchSource(parent context.Context, query ProductQuery, source registeredSource) sourceOutcome {
+	ctx, cancel := sourceContext(parent, source.options.Timeout)
+	defer cancel()
+
+	outcome := sourceOutcome{
+		name:      source.name,
+		required:  source.options.Required,
+		auxiliary: source.options.Auxiliary,
+	}
+
+	type fetched struct {
+		result SourceResult
+		err    error
+	}
+
+	fetchedCh := make(chan fetched, 1)
+	go func() {
+		result, err := source.source.Fetch(ctx, query)
+		fetchedCh <- fetched{result: result, err: err}
+	}()
+
+	select {
+	case f := <-fetchedCh:
+		outcome.result = f.result
+		outcome.err = f.err
+
+	case <-ctx.Done():
+		outcome.err = ctx.Err()
+	}
+
+	switch {
+	case isNotFound(outcome.err):
+		log.Printf("Product %d not found in %s source", query.ProductID, source.name)
+
+	case outcome.err != nil:
+		log.Printf("Failed fetching from %s source: %s", source.name, outcome.err)
+	}
+
+	return outcome
+}
+
+// isNotFound reports whether a source failed only because it does not know
+// the product
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrProductNotFound) || errors.Is(err, ErrPriceNotFound)
+}
+
+// addOutcome copies a source's fields into the product, or records why
+// there are none
+func (p *Product) addOutcome(outcome sourceOutcome) {
+	if outcome.err != nil {
+		p.addSourceError(outcome.name, outcome.err)
+	} else {
+		for key, value := range outcome.result.Fields {
+			p.setField(key, value)
+		}
+	}
+
+	if outcome.result.CacheAge > 0 {
+		p.addCacheAge(outcome.name, outcome.result.CacheAge)
+	}
+}
+
+func (p *Product) addSourceError(source string, err error) {
+	if p.Meta == nil {
+		p.Meta = &ProductMeta{}
+	}
+
+	p.Meta.Errors = append(p.Meta.Errors, newSourceError(source, err))
+}
+
+func (p *Product) addCacheAge(source string, age time.Duration) {
+	if p.Meta == nil {
+		p.Meta = &ProductMeta{}
+	}
+
+	if p.Meta.CacheAgeSeconds == nil {
+		p.Meta.CacheAgeSeconds = make(map[string]int)
+	}
+
+	p.Meta.CacheAgeSeconds[source] = int(age / time.Second)
+}
+
+// sourceContext derives the context for a single source from the request's
+// context, applying the source's deadline if it has one
+func sourceContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+
+	return context.WithTimeout(parent, timeout)
+}
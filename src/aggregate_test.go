@@ -0,0 +1,266 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// latencySource builds a source which answers after delay. When ignoreCtx is
+// set it keeps working past its deadline, like a misbehaving client library.
+func latencySource(name string, delay time.Duration, timeout time.Duration, ignoreCtx bool) registeredSource {
+	return registeredSource{
+		name:    name,
+		options: SourceOptions{Timeout: timeout},
+		source: ProductSourceFunc(func(ctx context.Context, query ProductQuery) (SourceResult, error) {
+			if ignoreCtx {
+				time.Sleep(delay)
+			} else {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return SourceResult{}, ctx.Err()
+				}
+			}
+
+			return SourceResult{
+				Fields: map[string]interface{}{
+					name: query.ProductID,
+				},
+			}, nil
+		}),
+	}
+}
+
+func TestFetchSourcesManyConcurrent(t *testing.T) {
+	const sourceCount = 50
+	rng := rand.New(rand.NewSource(1))
+
+	var sources []registeredSource
+	wantTimeout := make(map[string]bool)
+	for i := 0; i < sourceCount; i++ {
+		name := fmt.Sprintf("source%d", i)
+		delay := time.Duration(rng.Intn(20)) * time.Millisecond
+
+		// Every fifth source is far too slow, and half of those ignore
+		// their context entirely
+		if i%5 == 0 {
+			delay = time.Second
+			wantTimeout[name] = true
+		}
+
+		sources = append(sources, latencySource(name, delay, 200*time.Millisecond, i%10 == 0))
+	}
+
+	var wg sync.WaitGroup
+	for productID := 0; productID < 20; productID++ {
+		wg.Add(1)
+		go func(productID int) {
+			defer wg.Done()
+
+			start := time.Now()
+			outcomes := fetchSources(context.Background(), ProductQuery{ProductID: productID}, sources)
+			if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+				t.Errorf("product %d: slow sources held up the aggregate for %s", productID, elapsed)
+			}
+
+			product := Product{ProductID: productID}
+			for i, outcome := range outcomes {
+				if outcome.name != sources[i].name {
+					t.Errorf("product %d: outcome %d is from %s, want %s", productID, i, outcome.name, sources[i].name)
+				}
+
+				if wantTimeout[outcome.name] != errors.Is(outcome.err, context.DeadlineExceeded) {
+					t.Errorf("product %d: %s: got error %+v, want timeout: %t", productID, outcome.name, outcome.err, wantTimeout[outcome.name])
+				}
+
+				product.addOutcome(outcome)
+			}
+
+			if len(product.Meta.Errors) != len(wantTimeout) {
+				t.Errorf("product %d: got %d errors, want %d", productID, len(product.Meta.Errors), len(wantTimeout))
+			}
+
+			if len(product.Fields) != sourceCount-len(wantTimeout) {
+				t.Errorf("product %d: got %d fields, want %d", productID, len(product.Fields), sourceCount-len(wantTimeout))
+			}
+		}(productID)
+	}
+	wg.Wait()
+}
+
+func TestFetchSourcesParentCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	outcomes := fetchSources(ctx, ProductQuery{ProductID: 1}, []registeredSource{
+		latencySource("slow", time.Second, 0, true),
+	})
+
+	if !errors.Is(outcomes[0].err, context.Canceled) {
+		t.Errorf("got error %+v, want %+v", outcomes[0].err, context.Canceled)
+	}
+}
+
+// latencyPriceRepository answers Gets after a random delay
+type latencyPriceRepository struct {
+	*InMemoryProductPriceRepository
+	maxDelay time.Duration
+}
+
+func (l latencyPriceRepository) Get(ctx context.Context, productID int, query PriceQuery) (*ProductPrice, error) {
+	time.Sleep(time.Duration(rand.Int63n(int64(l.maxDelay))))
+	return l.InMemoryProductPriceRepository.Get(ctx, productID, query)
+}
+
+// latencyNameRepository answers with a name derived from the product after a
+// random delay
+type latencyNameRepository struct {
+	maxDelay time.Duration
+}
+
+func (l latencyNameRepository) Get(ctx context.Context, productID int) (string, error) {
+	time.Sleep(time.Duration(rand.Int63n(int64(l.maxDelay))))
+	return fmt.Sprintf("Product %d", productID), nil
+}
+
+func TestRequestHandlerConcurrentRequests(t *testing.T) {
+	var prices []ProductPrice
+	for productID := 1; productID <= 100; productID++ {
+		prices = append(prices, ProductPrice{ProductID: productID, Price: NewMoney(int64(productID), 0), CurrencyCode: "USD"})
+	}
+
+	rh := newRequestHandler(
+		latencyPriceRepository{InMemoryProductPriceRepository: helperPriceRepository(t, nil, prices...), maxDelay: 5 * time.Millisecond},
+		latencyNameRepository{maxDelay: 5 * time.Millisecond},
+		SourceOptions{},
+		SourceOptions{},
+	)
+
+	var wg sync.WaitGroup
+	for productID := 1; productID <= 100; productID++ {
+		wg.Add(1)
+		go func(productID int) {
+			defer wg.Done()
+
+			url := fmt.Sprintf("http://example.com/%d", productID)
+			w := httptest.NewRecorder()
+			rh.HandleRequest(w, httptest.NewRequest("GET", url, nil))
+
+			want := fmt.Sprintf(`{"product_id":%d,"name":"Product %d","current_price":{"value":%d,"currency_code":"USD"}}`, productID, productID, productID)
+			if w.Code != http.StatusOK || w.Body.String() != want {
+				t.Errorf("got %d %s, want 200 %s", w.Code, w.Body.String(), want)
+			}
+		}(productID)
+	}
+	wg.Wait()
+}
+
+type inventory struct {
+	Available int `json:"available"`
+}
+
+func staticSource(fields map[string]interface{}, err error) ProductSource {
+	return ProductSourceFunc(func(ctx context.Context, query ProductQuery) (SourceResult, error) {
+		return SourceResult{Fields: fields}, err
+	})
+}
+
+var registeredSourceTests = []struct {
+	name    string
+	source  ProductSource
+	options SourceOptions
+	want    httpWant
+}{
+	{
+		name: "Extra fields are added to the product",
+		source: staticSource(map[string]interface{}{
+			"inventory": inventory{Available: 3},
+			"rating":    4.5,
+		}, nil),
+		want: httpWant{
+			code: http.StatusOK,
+			body: `{"product_id":123,"name":"Picard","inventory":{"available":3},"rating":4.5}`,
+		},
+	},
+	{
+		name: "Reserved fields are ignored",
+		source: staticSource(map[string]interface{}{
+			"product_id": 456,
+		}, nil),
+		want: httpWant{
+			code: http.StatusOK,
+			body: `{"product_id":123,"name":"Picard"}`,
+		},
+	},
+	{
+		name:   "Optional source failures are reported in metadata",
+		source: staticSource(nil, errors.New("inventory is down")),
+		want: httpWant{
+			code: http.StatusOK,
+			body: `{"product_id":123,"name":"Picard","_meta":{"errors":[{"source":"inventory","kind":"upstream_error"}]}}`,
+		},
+	},
+	{
+		name:    "Required source failures fail the request",
+		source:  staticSource(nil, &UpstreamStatusError{StatusCode: http.StatusServiceUnavailable}),
+		options: SourceOptions{Required: true},
+		want: httpWant{
+			code: http.StatusServiceUnavailable,
+			body: "Product data is temporarily unavailable\n",
+		},
+	},
+	{
+		name:    "Required source not knowing the product is a 404",
+		source:  staticSource(nil, ErrProductNotFound),
+		options: SourceOptions{Required: true},
+		want: httpWant{
+			code: http.StatusNotFound,
+			body: "Product not found\n",
+		},
+	},
+}
+
+func TestRequestHandlerRegisteredSources(t *testing.T) {
+	for _, tt := range registeredSourceTests {
+		t.Run(tt.name, func(t *testing.T) {
+			rh := newRequestHandler(
+				StubPriceRepository{},
+				StubNameRepository{nr: nameResult{name: "Picard"}},
+				SourceOptions{},
+				SourceOptions{},
+			)
+			rh.RegisterSource("inventory", tt.source, tt.options)
+
+			w := httptest.NewRecorder()
+			rh.HandleRequest(w, httptest.NewRequest("GET", "http://example.com/123", nil))
+
+			if w.Body.String() != tt.want.body {
+				t.Errorf("got %s, want %s", w.Body.String(), tt.want.body)
+			}
+
+			if w.Code != tt.want.code {
+				t.Errorf("got %d, want %d", w.Code, tt.want.code)
+			}
+		})
+	}
+}
+
+func TestRegisterSourceTwicePanics(t *testing.T) {
+	rh := RequestHandler{}
+	rh.RegisterSource("inventory", staticSource(nil, nil), SourceOptions{})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic. none found")
+		}
+	}()
+
+	rh.RegisterSource("inventory", staticSource(nil, nil), SourceOptions{})
+}
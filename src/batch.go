@@ -0,0 +1,260 @@
+package productaggregate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/gddo/httputil/header"
+)
+
+// MaxBatchProducts is the most products one batch GET may ask for
+const MaxBatchProducts = 200
+
+// multiPriceRepository is implemented by price repositories that can fetch
+// many products' prices in one round trip. Results are in the same order as
+// productIDs, and each has its own error.
+type multiPriceRepository interface {
+	GetMulti(ctx context.Context, productIDs []int, query PriceQuery) ([]*ProductPrice, []error)
+}
+
+// getMultiPrices fetches many products' prices, in one round trip when the
+// repository supports it, and one at a time otherwise
+func getMultiPrices(ctx context.Context, repository ProductPriceRepository, productIDs []int, query PriceQuery) ([]*ProductPrice, []error) {
+	if multi, ok := repository.(multiPriceRepository); ok {
+		return multi.GetMulti(ctx, productIDs, query)
+	}
+
+	prices := make([]*ProductPrice, len(productIDs))
+	errs := make([]error, len(productIDs))
+	for i, productID := range productIDs {
+		prices[i], errs[i] = repository.Get(ctx, productID, query)
+	}
+
+	return prices, errs
+}
+
+// prefetchedPriceSource serves the prices fetched for a whole batch at once
+type prefetchedPriceSource struct {
+	prices map[int]*ProductPrice
+	errs   map[int]error
+}
+
+// Fetch returns the product's prefetched price
+func (s prefetchedPriceSource) Fetch(ctx context.Context, query ProductQuery) (SourceResult, error) {
+	if err := s.errs[query.ProductID]; err != nil {
+		return SourceResult{}, err
+	}
+
+	return SourceResult{
+		Fields: map[string]interface{}{
+			FieldCurrentPrice: s.prices[query.ProductID],
+		},
+	}, nil
+}
+
+// batchRequest is the body of a batch POST
+type batchRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// batchItem is the result for one product of a batch. Product is set when
+// Status is 200, and Error otherwise.
+type batchItem struct {
+	ProductID int      `json:"product_id"`
+	Status    int      `json:"status"`
+	Product   *Product `json:"product,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// batchResponse is the body of a batch response
+type batchResponse struct {
+	Products []batchItem `json:"products"`
+}
+
+// HandleBatchGet handles requests for many products at once, either as GET
+// with an ids parameter, or as POST with a JSON body for longer lists. Each
+// product has its own status in the response.
+func (rh RequestHandler) HandleBatchGet(w http.ResponseWriter, r *http.Request) {
+	var productIDs []int
+	var err error
+	if r.Method == "POST" {
+		productIDs, err = parseBatchBody(w, r)
+	} else {
+		productIDs, err = parseBatchIDs(r.URL.Query().Get("ids"))
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, productID := range productIDs {
+		if _, err := rh.productIDs.check(strconv.Itoa(productID)); err != nil {
+			http.Error(w, fmt.Sprintf("%s, got %d", err, productID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	query, err := parseProductQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sources := rh.prefetchPrices(r.Context(), productIDs, query)
+
+	items := make([]batchItem, len(productIDs))
+	semaphore := make(chan struct{}, rh.batchConcurrency)
+	var wg sync.WaitGroup
+
+	for i, productID := range productIDs {
+		wg.Add(1)
+		go func(i int, productID int) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			productQuery := query
+			productQuery.ProductID = productID
+			items[i] = batchProduct(r.Context(), productQuery, sources)
+		}(i, productID)
+	}
+
+	wg.Wait()
+
+	json, err := json.Marshal(batchResponse{Products: items})
+	if err != nil {
+		msg := "Could not process request"
+		http.Error(w, msg, http.StatusInternalServerError)
+		log.Printf("Error marshalling batch of %d products", len(productIDs))
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(json)
+}
+
+// batchProduct assembles one product of a batch
+func batchProduct(ctx context.Context, query ProductQuery, sources []registeredSource) batchItem {
+	product, _, failures := aggregateProduct(ctx, query, sources)
+	if failures != nil {
+		status, msg, _ := sourceErrorResponse(query.ProductID, failures...)
+		return batchItem{ProductID: query.ProductID, Status: status, Error: msg}
+	}
+
+	return batchItem{ProductID: query.ProductID, Status: http.StatusOK, Product: &product}
+}
+
+// prefetchPrices fetches every product's price in one round trip, when the
+// price repository supports it, and returns the sources with the price
+// source serving those prices instead
+func (rh RequestHandler) prefetchPrices(ctx context.Context, productIDs []int, query ProductQuery) []registeredSource {
+	if _, ok := rh.priceRepository.(multiPriceRepository); !ok {
+		return rh.sources
+	}
+
+	sources := make([]registeredSource, len(rh.sources))
+	copy(sources, rh.sources)
+
+	for i, source := range sources {
+		if source.name != SourcePrice {
+			continue
+		}
+
+		priceCtx, cancel := sourceContext(ctx, source.options.Timeout)
+		prices, errs := getMultiPrices(priceCtx, rh.priceRepository, productIDs, PriceQuery{
+			CurrencyCode: query.CurrencyCode,
+			At:           query.At,
+		})
+		cancel()
+
+		prefetched := prefetchedPriceSource{
+			prices: make(map[int]*ProductPrice, len(productIDs)),
+			errs:   make(map[int]error),
+		}
+
+		for j, productID := range productIDs {
+			prefetched.prices[productID] = prices[j]
+			if errs[j] != nil {
+				prefetched.errs[productID] = errs[j]
+			}
+		}
+
+		sources[i].source = prefetched
+	}
+
+	return sources
+}
+
+// parseBatchIDs reads a comma separated list of product IDs. Errors are
+// suitable for clients.
+func parseBatchIDs(raw string) ([]int, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, fmt.Errorf("No product IDs given")
+	}
+
+	var productIDs []int
+	for _, field := range strings.Split(raw, ",") {
+		productID, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid product ID %q", field)
+		}
+
+		productIDs = append(productIDs, productID)
+	}
+
+	return uniqueBatchIDs(productIDs)
+}
+
+// parseBatchBody reads the product IDs of a batch POST. Errors are suitable
+// for clients.
+func parseBatchBody(w http.ResponseWriter, r *http.Request) ([]int, error) {
+	if r.Header.Get("Content-Type") != "" {
+		value, _ := header.ParseValueAndParams(r.Header, "Content-Type")
+		if value != "application/json" {
+			return nil, fmt.Errorf("Content-Type header is not application/json")
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var body batchRequest
+	if err := dec.Decode(&body); err != nil {
+		return nil, fmt.Errorf("Request body must be a JSON object with an ids array of product IDs")
+	}
+
+	if len(body.IDs) == 0 {
+		return nil, fmt.Errorf("No product IDs given")
+	}
+
+	return uniqueBatchIDs(body.IDs)
+}
+
+// uniqueBatchIDs drops repeated product IDs, keeping the first of each, and
+// checks the batch is not too large
+func uniqueBatchIDs(productIDs []int) ([]int, error) {
+	seen := make(map[int]bool, len(productIDs))
+	unique := productIDs[:0]
+	for _, productID := range productIDs {
+		if !seen[productID] {
+			seen[productID] = true
+			unique = append(unique, productID)
+		}
+	}
+
+	if len(unique) > MaxBatchProducts {
+		return nil, fmt.Errorf("At most %d products may be fetched at once, got %d", MaxBatchProducts, len(unique))
+	}
+
+	return unique, nil
+}
@@ -0,0 +1,299 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+var parseBatchIDsTests = []struct {
+	raw  string
+	want string
+	err  string
+}{
+	{"1,2,3", "[1 2 3]", ""},
+	{" 1, 2 ,3 ", "[1 2 3]", ""},
+	{"3,1,3,2,1", "[3 1 2]", ""},
+	{"", "", "No product IDs given"},
+	{"1,,2", "", `Invalid product ID ""`},
+	{"1,two", "", `Invalid product ID "two"`},
+	{strings.Repeat("1,", MaxBatchProducts) + "2", "", ""},
+	{helperBatchIDs(MaxBatchProducts + 1), "", "At most 200 products may be fetched at once, got 201"},
+}
+
+func helperBatchIDs(n int) string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i + 1)
+	}
+
+	return strings.Join(ids, ",")
+}
+
+func TestParseBatchIDs(t *testing.T) {
+	for _, tt := range parseBatchIDsTests {
+		got, err := parseBatchIDs(tt.raw)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parseBatchIDs(%q): got error %+v, want %s", tt.raw, err, tt.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseBatchIDs(%q): unexpected error: %s", tt.raw, err)
+			continue
+		}
+
+		if tt.want != "" && fmt.Sprint(got) != tt.want {
+			t.Errorf("parseBatchIDs(%q) = %v, want %s", tt.raw, got, tt.want)
+		}
+	}
+}
+
+// countingPriceRepository counts how prices are fetched
+type countingPriceRepository struct {
+	*InMemoryProductPriceRepository
+
+	mu       sync.Mutex
+	gets     int
+	getMulti int
+}
+
+func (c *countingPriceRepository) Get(ctx context.Context, productID int, query PriceQuery) (*ProductPrice, error) {
+	c.mu.Lock()
+	c.gets++
+	c.mu.Unlock()
+
+	return c.InMemoryProductPriceRepository.Get(ctx, productID, query)
+}
+
+func (c *countingPriceRepository) GetMulti(ctx context.Context, productIDs []int, query PriceQuery) ([]*ProductPrice, []error) {
+	c.mu.Lock()
+	c.getMulti++
+	c.mu.Unlock()
+
+	return c.InMemoryProductPriceRepository.GetMulti(ctx, productIDs, query)
+}
+
+// helperBatchHandler prices products 1 and 2, and names products 1 and 3
+func helperBatchHandler(t *testing.T) (RequestHandler, *countingPriceRepository) {
+	prices := &countingPriceRepository{InMemoryProductPriceRepository: helperPriceRepository(t, nil,
+		ProductPrice{ProductID: 1, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+		ProductPrice{ProductID: 2, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+	)}
+	names := helperNameRepository(map[int]string{1: "Picard", 3: "Riker"})
+
+	return newRequestHandler(prices, names, SourceOptions{}, SourceOptions{}), prices
+}
+
+const wantBatchBody = `{"products":[` +
+	`{"product_id":1,"status":200,"product":{"product_id":1,"name":"Picard","current_price":{"value":13.49,"currency_code":"USD"}}},` +
+	`{"product_id":2,"status":200,"product":{"product_id":2,"current_price":{"value":13.49,"currency_code":"USD"},"_meta":{"errors":[{"source":"name","kind":"not_found"}]}}},` +
+	`{"product_id":3,"status":200,"product":{"product_id":3,"name":"Riker","_meta":{"errors":[{"source":"price","kind":"not_found"}]}}},` +
+	`{"product_id":4,"status":404,"error":"Product not found"}]}`
+
+var batchRequestTests = []struct {
+	name     string
+	request  func() *http.Request
+	wantCode int
+	wantBody string
+}{
+	{
+		name: "GET",
+		request: func() *http.Request {
+			return httptest.NewRequest("GET", "http://example.com/products?ids=1,2,3,4", nil)
+		},
+		wantCode: http.StatusOK,
+		wantBody: wantBatchBody,
+	},
+	{
+		name: "POST",
+		request: func() *http.Request {
+			r := httptest.NewRequest("POST", "http://example.com/products", strings.NewReader(`{"ids": [1, 2, 3, 4, 1]}`))
+			r.Header.Set("Content-Type", "application/json")
+			return r
+		},
+		wantCode: http.StatusOK,
+		wantBody: wantBatchBody,
+	},
+	{
+		name: "POST without IDs",
+		request: func() *http.Request {
+			return httptest.NewRequest("POST", "http://example.com/products", strings.NewReader(`{"ids": []}`))
+		},
+		wantCode: http.StatusBadRequest,
+		wantBody: "No product IDs given\n",
+	},
+	{
+		name: "POST malformed",
+		request: func() *http.Request {
+			return httptest.NewRequest("POST", "http://example.com/products", strings.NewReader(`{"ids": ["1"]}`))
+		},
+		wantCode: http.StatusBadRequest,
+		wantBody: "Request body must be a JSON object with an ids array of product IDs\n",
+	},
+	{
+		name: "POST not JSON",
+		request: func() *http.Request {
+			r := httptest.NewRequest("POST", "http://example.com/products", strings.NewReader(`ids=1`))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return r
+		},
+		wantCode: http.StatusBadRequest,
+		wantBody: "Content-Type header is not application/json\n",
+	},
+	{
+		name: "Invalid currency",
+		request: func() *http.Request {
+			return httptest.NewRequest("GET", "http://example.com/products?ids=1&currency=XYZ", nil)
+		},
+		wantCode: http.StatusBadRequest,
+		wantBody: "\"XYZ\" is not an ISO 4217 currency code\n",
+	},
+}
+
+func TestRequestHandlerBatch(t *testing.T) {
+	for _, tt := range batchRequestTests {
+		t.Run(tt.name, func(t *testing.T) {
+			rh, prices := helperBatchHandler(t)
+
+			w := httptest.NewRecorder()
+			rh.HandleRequest(w, tt.request())
+
+			if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+				t.Errorf("got %d %s, want %d %s", w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+			}
+
+			// Prices are fetched in one round trip for the whole batch
+			if w.Code == http.StatusOK && (prices.getMulti != 1 || prices.gets != 0) {
+				t.Errorf("got %d GetMulti and %d Get calls, want a single GetMulti", prices.getMulti, prices.gets)
+			}
+		})
+	}
+}
+
+// concurrencyNameRepository records the most names fetched at once
+type concurrencyNameRepository struct {
+	mu      sync.Mutex
+	current int
+	most    int
+}
+
+func (c *concurrencyNameRepository) Get(ctx context.Context, productID int) (string, error) {
+	c.mu.Lock()
+	c.current++
+	if c.current > c.most {
+		c.most = c.current
+	}
+	c.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	c.mu.Lock()
+	c.current--
+	c.mu.Unlock()
+
+	return "Picard", nil
+}
+
+func TestRequestHandlerBatchConcurrency(t *testing.T) {
+	names := &concurrencyNameRepository{}
+	rh := newRequestHandler(NewInMemoryProductPriceRepository(), names, SourceOptions{}, SourceOptions{})
+	rh.batchConcurrency = 3
+
+	w := httptest.NewRecorder()
+	rh.HandleRequest(w, httptest.NewRequest("GET", "http://example.com/products?ids="+helperBatchIDs(20), nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", w.Code)
+	}
+
+	if names.most > 3 {
+		t.Errorf("got %d products assembled at once, want at most 3", names.most)
+	}
+}
+
+func TestProductPriceRepositoryGetMulti(t *testing.T) {
+	client := newPropertyDatastoreClient()
+	repository := helperPropertyRepository(t, client)
+	ctx := context.Background()
+
+	for _, price := range []ProductPrice{
+		{ProductID: 1, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+		{ProductID: 2, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"},
+	} {
+		if err := repository.Put(ctx, price); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	prices, errs := repository.GetMulti(ctx, []int{2, 3, 1}, PriceQuery{})
+
+	if errs[0] != nil || prices[0].Price.String() != "12.50" || prices[0].CurrencyCode != "EUR" || prices[0].ProductID != 2 {
+		t.Errorf("got %+v %+v, want 12.50 EUR for product 2", prices[0], errs[0])
+	}
+
+	if !errors.Is(errs[1], ErrPriceNotFound) {
+		t.Errorf("got %+v, want %+v for product 3", errs[1], ErrPriceNotFound)
+	}
+
+	if errs[2] != nil || prices[2].Price.String() != "13.49" || prices[2].ProductID != 1 {
+		t.Errorf("got %+v %+v, want 13.49 USD for product 1", prices[2], errs[2])
+	}
+}
+
+func TestProductPriceRepositoryGetMultiError(t *testing.T) {
+	creator := newTestDatastoreClientCreator(nil, errors.New("Datastore get error"), nil)
+	repository, err := NewGCPProductPriceRepository(context.Background(), creator, "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, errs := repository.GetMulti(context.Background(), []int{1, 2}, PriceQuery{})
+	for i, err := range errs {
+		if err == nil || errors.Is(err, datastore.ErrNoSuchEntity) {
+			t.Errorf("got %+v for product %d, want the datastore error", err, i+1)
+		}
+	}
+}
+
+func TestConvertingProductPriceRepositoryGetMulti(t *testing.T) {
+	rates, err := LoadStaticExchangeRates("testdata/exchange_rates.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	prices := helperPriceRepository(t, nil,
+		ProductPrice{ProductID: 1, Price: MustParseMoney("10.00"), CurrencyCode: "USD"},
+		ProductPrice{ProductID: 2, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"},
+		ProductPrice{ProductID: 3, Price: MustParseMoney("9.00"), CurrencyCode: "CHF"},
+	)
+
+	repository := NewConvertingProductPriceRepository(prices, rates)
+	got, errs := repository.GetMulti(context.Background(), []int{1, 2, 3, 4}, PriceQuery{CurrencyCode: "EUR"})
+
+	if errs[0] != nil || got[0].Price.String() != "9.20" || got[0].Conversion == nil {
+		t.Errorf("got %+v %+v, want 9.20 EUR converted for product 1", got[0], errs[0])
+	}
+
+	if errs[1] != nil || got[1].Price.String() != "12.50" || got[1].Conversion != nil {
+		t.Errorf("got %+v %+v, want 12.50 EUR stored for product 2", got[1], errs[1])
+	}
+
+	if !errors.Is(errs[2], ErrCurrencyNotFound) {
+		t.Errorf("got %+v, want %+v without a rate for product 3", errs[2], ErrCurrencyNotFound)
+	}
+
+	if !errors.Is(errs[3], ErrPriceNotFound) || errors.Is(errs[3], ErrCurrencyNotFound) {
+		t.Errorf("got %+v, want %+v for product 4", errs[3], ErrPriceNotFound)
+	}
+}
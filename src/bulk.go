@@ -0,0 +1,424 @@
+package productaggregate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/gddo/httputil/header"
+)
+
+// Bulk price update limits
+const (
+	// MaxBulkPrices is the most prices one bulk update may set
+	MaxBulkPrices = 10000
+
+	// MaxAtomicBulkPrices is the most prices an atomic bulk update may set,
+	// as they are all written in one datastore transaction
+	MaxAtomicBulkPrices = 200
+
+	// putMultiChunkSize is the most prices written in one datastore
+	// transaction. Each writes its product and a history entity, which
+	// keeps a chunk well within the datastore's limit of 500 mutations.
+	putMultiChunkSize = 200
+
+	// maxBulkBodyBytes is the largest bulk update body accepted
+	maxBulkBodyBytes = 10 << 20
+)
+
+// ErrBulkAborted is reported for the prices of an atomic bulk update which
+// were not set because another price failed
+var ErrBulkAborted = errors.New("not updated, as another price in the batch failed")
+
+// ErrAtomicUnsupported is reported for every price of an atomic bulk update
+// made against a repository which cannot apply them atomically
+var ErrAtomicUnsupported = errors.New("atomic bulk updates are not supported")
+
+// PutMultiOptions controls how a bulk update is applied
+type PutMultiOptions struct {
+	// Atomic sets either every price or none. Otherwise the prices which
+	// could be set are, whatever happened to the others.
+	Atomic bool
+}
+
+// bulkPriceRepository is implemented by price repositories that can set
+// many prices in few round trips. The results are in the same order as
+// prices, each with its own error.
+type bulkPriceRepository interface {
+	PutMulti(ctx context.Context, prices []ProductPrice, options PutMultiOptions) []error
+}
+
+// putMultiPrices sets many prices, in bulk when the repository supports it,
+// and one at a time otherwise
+func putMultiPrices(ctx context.Context, repository ProductPriceRepository, prices []ProductPrice, options PutMultiOptions) []error {
+	if bulk, ok := repository.(bulkPriceRepository); ok {
+		return bulk.PutMulti(ctx, prices, options)
+	}
+
+	errs := make([]error, len(prices))
+	for i, price := range prices {
+		if options.Atomic {
+			errs[i] = ErrAtomicUnsupported
+			continue
+		}
+
+		errs[i] = repository.Put(ctx, price)
+	}
+
+	return errs
+}
+
+// applyPrices sets each price on its product's entity, in order, recording
+// why any could not be set. It returns the changes for the price history.
+// Changes are a nanosecond apart, so that those to the same product sort in
+// the order they were made.
+func applyPrices(ctx context.Context, entities map[int]*priceEntity, prices []ProductPrice, now time.Time) ([]PriceChange, []error) {
+	var changes []PriceChange
+	errs := make([]error, len(prices))
+
+	for i, price := range prices {
+		entity, ok := entities[price.ProductID]
+		if !ok {
+			entity = &priceEntity{ProductID: price.ProductID}
+			entities[price.ProductID] = entity
+		}
+
+		old, expired, err := entity.setPrice(price, now)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		changes = append(changes, newExpiryChanges(ctx, price.ProductID, expired, now.Add(time.Duration(len(changes))))...)
+		changes = append(changes, newPriceChange(ctx, old, price, now.Add(time.Duration(len(changes)))))
+	}
+
+	return changes, errs
+}
+
+// abortPrices marks every price without an error of its own as aborted,
+// and reports whether any had failed
+func abortPrices(errs []error) bool {
+	failed := false
+	for _, err := range errs {
+		if err != nil {
+			failed = true
+			break
+		}
+	}
+
+	if !failed {
+		return false
+	}
+
+	for i, err := range errs {
+		if err == nil {
+			errs[i] = ErrBulkAborted
+		}
+	}
+
+	return true
+}
+
+// bulkPriceUpdate is one price of a bulk update
+type bulkPriceUpdate struct {
+	ProductID *int `json:"product_id"`
+	priceUpdate
+}
+
+// bulkPriceResult reports what happened to one price of a bulk update.
+// Index counts from 0 in the order the prices were sent, and Line is set
+// for NDJSON bodies.
+type bulkPriceResult struct {
+	Index     int    `json:"index"`
+	Line      int    `json:"line,omitempty"`
+	ProductID int    `json:"product_id,omitempty"`
+	Status    int    `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// bulkPriceResponse is the body of a bulk update response
+type bulkPriceResponse struct {
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []bulkPriceResult `json:"results"`
+}
+
+// bulkItem is one price of a bulk update, as read from the body
+type bulkItem struct {
+	result bulkPriceResult
+	price  ProductPrice
+}
+
+// HandleBulkPut handles updates of many prices at once. The body is either
+// a JSON array of prices, or NDJSON with one price per line. Every price is
+// validated and set on its own, and reported on in the response, unless
+// the atomic parameter asks for all or none of them to be set.
+func (rh RequestHandler) HandleBulkPut(w http.ResponseWriter, r *http.Request) {
+	atomic := false
+	if raw := r.URL.Query().Get("atomic"); raw != "" {
+		var err error
+		atomic, err = strconv.ParseBool(raw)
+		if err != nil {
+			msg := fmt.Sprintf("Invalid atomic parameter %q, want true or false", raw)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	ndjson := false
+	if r.Header.Get("Content-Type") != "" {
+		value, _ := header.ParseValueAndParams(r.Header, "Content-Type")
+		switch value {
+		case "application/json":
+
+		case "application/x-ndjson":
+			ndjson = true
+
+		default:
+			msg := "Content-Type header is not application/json or application/x-ndjson"
+			http.Error(w, msg, http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxBulkBodyBytes)
+
+	var items []bulkItem
+	var err error
+	if ndjson {
+		items, err = readBulkNDJSON(body)
+	} else {
+		items, err = readBulkArray(body)
+	}
+
+	if errors.Is(err, errNotBulkArray) || errors.Is(err, errTrailingData) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		status, msg := decodeErrorResponse(err)
+		if status == http.StatusRequestEntityTooLarge {
+			msg = fmt.Sprintf("Request body must not be larger than %dMB", maxBulkBodyBytes>>20)
+		}
+
+		http.Error(w, msg, status)
+		return
+	}
+
+	switch {
+	case len(items) == 0:
+		msg := "Request body contains no prices"
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+
+	case len(items) > MaxBulkPrices:
+		msg := fmt.Sprintf("At most %d prices may be updated at once", MaxBulkPrices)
+		http.Error(w, msg, http.StatusRequestEntityTooLarge)
+		return
+
+	case atomic && len(items) > MaxAtomicBulkPrices:
+		msg := fmt.Sprintf("At most %d prices may be updated atomically", MaxAtomicBulkPrices)
+		http.Error(w, msg, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var valid []int
+	var prices []ProductPrice
+	for i, item := range items {
+		if item.result.Status != 0 {
+			continue
+		}
+
+		if _, err := rh.productIDs.check(strconv.Itoa(item.price.ProductID)); err != nil {
+			items[i].result.Status = http.StatusBadRequest
+			items[i].result.Error = fmt.Sprintf("%s, got %d", err, item.price.ProductID)
+			continue
+		}
+
+		valid = append(valid, i)
+		prices = append(prices, item.price)
+	}
+
+	// An atomic update with an invalid price sets nothing at all
+	if atomic && len(valid) < len(items) {
+		for _, i := range valid {
+			items[i].result.Status, items[i].result.Error = bulkErrorResponse(ErrBulkAborted)
+		}
+		prices = nil
+	}
+
+	if len(prices) > 0 {
+		ctx, cancel := sourceContext(r.Context(), rh.priceTimeout)
+		defer cancel()
+
+		ctx = WithActor(ctx, r.Header.Get(actorHeader))
+
+		errs := putMultiPrices(ctx, rh.priceRepository, prices, PutMultiOptions{Atomic: atomic})
+		for j, i := range valid {
+			items[i].result.Status, items[i].result.Error = bulkErrorResponse(errs[j])
+		}
+	}
+
+	response := bulkPriceResponse{Results: make([]bulkPriceResult, len(items))}
+	for i, item := range items {
+		response.Results[i] = item.result
+		if item.result.Status == http.StatusOK {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+
+	json, err := json.Marshal(response)
+	if err != nil {
+		msg := "Could not process request"
+		http.Error(w, msg, http.StatusInternalServerError)
+		log.Printf("Error marshalling bulk update of %d prices", len(items))
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(json)
+}
+
+// bulkErrorResponse decides the status and message for one price of a bulk
+// update, as HandlePut would for a single price
+func bulkErrorResponse(err error) (int, string) {
+	var conflict *PriceConflictError
+	switch {
+	case err == nil:
+		return http.StatusOK, ""
+
+	case errors.As(err, &conflict):
+		return http.StatusConflict, fmt.Sprintf("Price conflicts with the %s price %s", conflict.Existing.CurrencyCode, DescribeWindow(conflict.Existing))
+
+	case errors.Is(err, ErrBulkAborted):
+		return http.StatusFailedDependency, "Not updated, as another price in the batch failed"
+
+	case errors.Is(err, ErrAtomicUnsupported):
+		return http.StatusNotImplemented, "Atomic bulk updates are not supported by this price backend"
+
+	default:
+		log.Printf("Failed updating product price in bulk: %s", err)
+		return http.StatusInternalServerError, "Error updating product"
+	}
+}
+
+// readBulkArray reads a JSON array of prices. Each price is decoded on its
+// own, so that one bad price does not stop the others; only a malformed
+// array fails the whole body.
+func readBulkArray(body io.Reader) ([]bulkItem, error) {
+	dec := json.NewDecoder(body)
+
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, errNotBulkArray
+	}
+
+	var items []bulkItem
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+
+		items = append(items, decodeBulkItem(len(items), raw))
+		if len(items) > MaxBulkPrices {
+			return items, nil
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	if dec.More() {
+		return nil, errTrailingData
+	}
+
+	return items, nil
+}
+
+// readBulkNDJSON reads one price per line, skipping blank lines
+func readBulkNDJSON(body io.Reader) ([]bulkItem, error) {
+	reader := bufio.NewReader(body)
+
+	var items []bulkItem
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if len(bytes.TrimSpace(data)) > 0 {
+			item := decodeBulkItem(len(items), data)
+			item.result.Line = line
+			items = append(items, item)
+
+			if len(items) > MaxBulkPrices {
+				return items, nil
+			}
+		}
+
+		if err == io.EOF {
+			return items, nil
+		}
+	}
+}
+
+// decodeBulkItem decodes and validates one price, recording any problem
+// in its result with the same message a single PUT would get
+func decodeBulkItem(index int, raw []byte) bulkItem {
+	item := bulkItem{result: bulkPriceResult{Index: index}}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+
+	var update bulkPriceUpdate
+	if err := dec.Decode(&update); err != nil {
+		item.result.Status, item.result.Error = decodeErrorResponse(err)
+		return item
+	}
+
+	if dec.More() {
+		item.result.Status, item.result.Error = http.StatusBadRequest, "Request body must only contain a single JSON object"
+		return item
+	}
+
+	if update.ProductID == nil {
+		err := FieldError{Field: "product_id", Reason: "missing"}
+		item.result.Status, item.result.Error = http.StatusBadRequest, err.Error()
+		return item
+	}
+
+	item.result.ProductID = *update.ProductID
+
+	price, fieldErrs := update.toProductPrice(*update.ProductID)
+	if len(fieldErrs) > 0 {
+		item.result.Status, item.result.Error = http.StatusBadRequest, joinFieldErrors(fieldErrs)
+		return item
+	}
+
+	item.price = price
+	return item
+}
+
+// Errors reading JSON array bodies. The messages are suitable for clients.
+var (
+	errNotBulkArray = errors.New("Request body must be a JSON array of prices")
+	errTrailingData = errors.New("Request body must only contain a single JSON array")
+)
@@ -0,0 +1,308 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var bulkPutTests = []struct {
+	name        string
+	target      string
+	contentType string
+	body        string
+	wantCode    int
+	wantBody    string
+	wantPrices  map[int]string
+}{
+	{
+		name:   "JSON array",
+		target: "http://example.com/prices",
+		body: `[{"product_id":1,"value":5,"currency_code":"usd"},` +
+			`{"product_id":2,"value":"x","currency_code":"USD"},` +
+			`{"product_id":3,"value":7.5,"currency_code":"USD","colour":"red"},` +
+			`{"value":1,"currency_code":"USD"},` +
+			`{"product_id":4,"value":1.234,"currency_code":"USD"},` +
+			`{"product_id":5,"value":8.25,"currency_code":"EUR"}]`,
+		wantCode: http.StatusOK,
+		wantBody: `{"succeeded":2,"failed":4,"results":[` +
+			`{"index":0,"product_id":1,"status":200},` +
+			`{"index":1,"status":400,"error":"Request body contains an invalid value for the \"value\" field: not a decimal number"},` +
+			`{"index":2,"status":400,"error":"Request body contains unknown field \"colour\""},` +
+			`{"index":3,"status":400,"error":"Request body contains an invalid value for the \"product_id\" field: missing"},` +
+			`{"index":4,"product_id":4,"status":400,"error":"Request body contains an invalid value for the \"value\" field: USD allows at most 2 decimal places"},` +
+			`{"index":5,"product_id":5,"status":200}]}`,
+		wantPrices: map[int]string{1: "5.00 USD", 5: "8.25 EUR"},
+	},
+	{
+		name:        "NDJSON",
+		target:      "http://example.com/prices",
+		contentType: "application/x-ndjson",
+		body:        "{\"product_id\":1,\"value\":5,\"currency_code\":\"USD\"}\n\n{\"product_id\":2,\n{\"product_id\":1,\"value\":6,\"currency_code\":\"USD\"}\n",
+		wantCode:    http.StatusOK,
+		wantBody: `{"succeeded":2,"failed":1,"results":[` +
+			`{"index":0,"line":1,"product_id":1,"status":200},` +
+			`{"index":1,"line":3,"status":400,"error":"Request body contains badly-formed JSON"},` +
+			`{"index":2,"line":4,"product_id":1,"status":200}]}`,
+		wantPrices: map[int]string{1: "6.00 USD"},
+	},
+	{
+		name:   "Conflicting schedule",
+		target: "http://example.com/prices/",
+		body: `[{"product_id":1,"value":5,"currency_code":"USD","effective_from":"2030-01-01T00:00:00Z","effective_to":"2030-02-01T00:00:00Z"},` +
+			`{"product_id":1,"value":4,"currency_code":"USD","effective_from":"2030-01-15T00:00:00Z"},` +
+			`{"product_id":2,"value":3,"currency_code":"USD"}]`,
+		wantCode: http.StatusOK,
+		wantBody: `{"succeeded":2,"failed":1,"results":[` +
+			`{"index":0,"product_id":1,"status":200},` +
+			`{"index":1,"product_id":1,"status":409,"error":"Price conflicts with the USD price effective from 2030-01-01T00:00:00Z until 2030-02-01T00:00:00Z"},` +
+			`{"index":2,"product_id":2,"status":200}]}`,
+		wantPrices: map[int]string{2: "3.00 USD"},
+	},
+	{
+		name:     "Atomic with an invalid price",
+		target:   "http://example.com/prices?atomic=true",
+		body:     `[{"product_id":1,"value":5,"currency_code":"USD"},{"product_id":2,"value":5,"currency_code":"XYZ"}]`,
+		wantCode: http.StatusOK,
+		wantBody: `{"succeeded":0,"failed":2,"results":[` +
+			`{"index":0,"product_id":1,"status":424,"error":"Not updated, as another price in the batch failed"},` +
+			`{"index":1,"product_id":2,"status":400,"error":"Request body contains an invalid value for the \"currency_code\" field: \"XYZ\" is not an ISO 4217 currency code"}]}`,
+		wantPrices: map[int]string{},
+	},
+	{
+		name:   "Atomic with a conflict",
+		target: "http://example.com/prices?atomic=1",
+		body: `[{"product_id":2,"value":3,"currency_code":"USD"},` +
+			`{"product_id":1,"value":5,"currency_code":"USD","effective_from":"2030-01-01T00:00:00Z","effective_to":"2030-02-01T00:00:00Z"},` +
+			`{"product_id":1,"value":4,"currency_code":"USD","effective_from":"2030-01-15T00:00:00Z"}]`,
+		wantCode: http.StatusOK,
+		wantBody: `{"succeeded":0,"failed":3,"results":[` +
+			`{"index":0,"product_id":2,"status":424,"error":"Not updated, as another price in the batch failed"},` +
+			`{"index":1,"product_id":1,"status":424,"error":"Not updated, as another price in the batch failed"},` +
+			`{"index":2,"product_id":1,"status":409,"error":"Price conflicts with the USD price effective from 2030-01-01T00:00:00Z until 2030-02-01T00:00:00Z"}]}`,
+		wantPrices: map[int]string{},
+	},
+	{
+		name:     "Atomic success",
+		target:   "http://example.com/prices?atomic=true",
+		body:     `[{"product_id":1,"value":5,"currency_code":"USD"},{"product_id":2,"value":3,"currency_code":"USD"}]`,
+		wantCode: http.StatusOK,
+		wantBody: `{"succeeded":2,"failed":0,"results":[` +
+			`{"index":0,"product_id":1,"status":200},` +
+			`{"index":1,"product_id":2,"status":200}]}`,
+		wantPrices: map[int]string{1: "5.00 USD", 2: "3.00 USD"},
+	},
+	{
+		name:     "Invalid atomic",
+		target:   "http://example.com/prices?atomic=maybe",
+		body:     `[]`,
+		wantCode: http.StatusBadRequest,
+		wantBody: "Invalid atomic parameter \"maybe\", want true or false\n",
+	},
+	{
+		name:     "Object body",
+		target:   "http://example.com/prices",
+		body:     `{"product_id":1,"value":5,"currency_code":"USD"}`,
+		wantCode: http.StatusBadRequest,
+		wantBody: "Request body must be a JSON array of prices\n",
+	},
+	{
+		name:     "Malformed array",
+		target:   "http://example.com/prices",
+		body:     `[{"product_id":1,"value":5,"currency_code":"USD"}`,
+		wantCode: http.StatusBadRequest,
+		wantBody: "Request body contains badly-formed JSON (at position 49)\n",
+	},
+	{
+		name:     "Empty array",
+		target:   "http://example.com/prices",
+		body:     `[]`,
+		wantCode: http.StatusBadRequest,
+		wantBody: "Request body contains no prices\n",
+	},
+	{
+		name:        "Unsupported content type",
+		target:      "http://example.com/prices",
+		contentType: "text/csv",
+		body:        "1,5,USD",
+		wantCode:    http.StatusUnsupportedMediaType,
+		wantBody:    "Content-Type header is not application/json or application/x-ndjson\n",
+	},
+	{
+		name:     "Too many to update atomically",
+		target:   "http://example.com/prices?atomic=true",
+		body:     "[" + strings.TrimSuffix(strings.Repeat(`{"product_id":1,"value":5,"currency_code":"USD"},`, MaxAtomicBulkPrices+1), ",") + "]",
+		wantCode: http.StatusRequestEntityTooLarge,
+		wantBody: "At most 200 prices may be updated atomically\n",
+	},
+}
+
+func TestRequestHandlerBulkPut(t *testing.T) {
+	for _, tt := range bulkPutTests {
+		t.Run(tt.name, func(t *testing.T) {
+			prices := NewInMemoryProductPriceRepository()
+			prices.now = func() time.Time { return scheduleStart }
+			rh := newRequestHandler(prices, NewInMemoryProductNameRepository(), SourceOptions{}, SourceOptions{})
+
+			r := httptest.NewRequest("PUT", tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			w := httptest.NewRecorder()
+			rh.HandleRequest(w, r)
+
+			if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+				t.Errorf("got %d %s, want %d %s", w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+			}
+
+			if tt.wantPrices == nil {
+				return
+			}
+
+			for productID := 1; productID <= 5; productID++ {
+				price, err := prices.Get(context.Background(), productID, PriceQuery{})
+				got := ""
+				if err == nil {
+					got = price.Price.String() + " " + price.CurrencyCode
+				}
+
+				if got != tt.wantPrices[productID] {
+					t.Errorf("got %q for product %d, want %q", got, productID, tt.wantPrices[productID])
+				}
+			}
+		})
+	}
+}
+
+func TestRequestHandlerBulkPutRecordsActor(t *testing.T) {
+	prices := NewInMemoryProductPriceRepository()
+	rh := newRequestHandler(prices, NewInMemoryProductNameRepository(), SourceOptions{}, SourceOptions{})
+
+	r := httptest.NewRequest("POST", "http://example.com/prices", strings.NewReader(`[{"product_id":1,"value":5,"currency_code":"USD"}]`))
+	r.Header.Set(actorHeader, "picard")
+
+	w := httptest.NewRecorder()
+	rh.HandleRequest(w, r)
+
+	page, err := prices.History(context.Background(), 1, PriceHistoryQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(page.Changes) != 1 || page.Changes[0].Actor != "picard" {
+		t.Errorf("got %+v, want one change by picard", page.Changes)
+	}
+}
+
+func TestProductPriceRepositoryPutMulti(t *testing.T) {
+	for _, atomic := range []bool{false, true} {
+		client := newPropertyDatastoreClient()
+		repository := helperPropertyRepository(t, client)
+		repository.now = func() time.Time { return scheduleStart }
+		ctx := context.Background()
+
+		if err := repository.Put(ctx, ProductPrice{ProductID: 1, Price: MustParseMoney("9.00"), CurrencyCode: "EUR"}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		errs := repository.PutMulti(ctx, []ProductPrice{
+			{ProductID: 1, Price: MustParseMoney("10.00"), CurrencyCode: "USD"},
+			{ProductID: 2, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"},
+			{ProductID: 1, Price: MustParseMoney("11.00"), CurrencyCode: "USD"},
+		}, PutMultiOptions{Atomic: atomic})
+
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("got %+v for price %d, want nil", err, i)
+			}
+		}
+
+		got, errs := repository.GetMulti(ctx, []int{1, 1, 2}, PriceQuery{})
+		if errs[0] != nil || got[0].Price.String() != "9.00" || got[0].Version != 3 {
+			t.Errorf("got %+v %+v, want 9.00 EUR at version 3", got[0], errs[0])
+		}
+
+		usd, err := repository.Get(ctx, 1, PriceQuery{CurrencyCode: "USD"})
+		if err != nil || usd.Price.String() != "11.00" {
+			t.Errorf("got %+v %+v, want 11.00 USD", usd, err)
+		}
+
+		if errs[2] != nil || got[2].Price.String() != "12.50" || got[2].Version != 1 {
+			t.Errorf("got %+v %+v, want 12.50 EUR at version 1", got[2], errs[2])
+		}
+
+		page, err := repository.History(ctx, 1, PriceHistoryQuery{})
+		if err == nil && len(page.Changes) != 3 {
+			t.Errorf("got %d changes, want 3", len(page.Changes))
+		}
+	}
+}
+
+func TestProductPriceRepositoryPutMultiAtomic(t *testing.T) {
+	client := newPropertyDatastoreClient()
+	repository := helperPropertyRepository(t, client)
+	repository.now = func() time.Time { return scheduleStart }
+
+	errs := repository.PutMulti(context.Background(), []ProductPrice{
+		{ProductID: 1, Price: MustParseMoney("10.00"), CurrencyCode: "USD"},
+		{ProductID: 2, Price: MustParseMoney("12.50"), CurrencyCode: "EUR", Version: 4},
+	}, PutMultiOptions{Atomic: true})
+
+	if !errors.Is(errs[0], ErrBulkAborted) || !errors.Is(errs[1], ErrVersionMismatch) {
+		t.Errorf("got %+v, want the first aborted and the second a version mismatch", errs)
+	}
+
+	if client.puts != 0 {
+		t.Errorf("got %d puts, want none", client.puts)
+	}
+}
+
+func TestProductPriceRepositoryPutMultiChunks(t *testing.T) {
+	client := newPropertyDatastoreClient()
+	repository := helperPropertyRepository(t, client)
+
+	prices := make([]ProductPrice, putMultiChunkSize+1)
+	for i := range prices {
+		prices[i] = ProductPrice{ProductID: i, Price: MustParseMoney("1.00"), CurrencyCode: "USD"}
+	}
+
+	errs := repository.PutMulti(context.Background(), prices, PutMultiOptions{})
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("got %+v for price %d, want nil", err, i)
+		}
+	}
+
+	// Each price writes its product and a history entity
+	if client.puts != 2*len(prices) {
+		t.Errorf("got %d puts, want %d", client.puts, 2*len(prices))
+	}
+}
+
+func TestInMemoryProductPriceRepositoryPutMultiAtomic(t *testing.T) {
+	repository := NewInMemoryProductPriceRepository()
+	ctx := context.Background()
+
+	if err := repository.Put(ctx, ProductPrice{ProductID: 1, Price: MustParseMoney("9.00"), CurrencyCode: "USD"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	errs := repository.PutMulti(ctx, []ProductPrice{
+		{ProductID: 1, Price: MustParseMoney("10.00"), CurrencyCode: "USD"},
+		{ProductID: 2, Price: MustParseMoney("12.50"), CurrencyCode: "EUR", Version: AnyVersion},
+	}, PutMultiOptions{Atomic: true})
+
+	if !errors.Is(errs[0], ErrBulkAborted) || !errors.Is(errs[1], ErrVersionMismatch) {
+		t.Errorf("got %+v, want the first aborted and the second a version mismatch", errs)
+	}
+
+	price, err := repository.Get(ctx, 1, PriceQuery{})
+	if err != nil || price.Price.String() != "9.00" || price.Version != 1 {
+		t.Errorf("got %+v %+v, want 9.00 USD at version 1", price, err)
+	}
+}
@@ -0,0 +1,239 @@
+// Command prices imports product prices from CSV files, and exports them to
+// CSV or NDJSON, for working on prices in spreadsheets.
+//
+//	prices import [-dry-run] [-resume] prices.csv
+//	prices export [-format csv|ndjson] [-o prices.csv]
+//
+// The price backend is configured as for the server, through the
+// environment or the file named by CONFIG_FILE.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"leebradley.us/productaggregate"
+)
+
+const usage = `usage:
+  prices import [-dry-run] [-resume] [-actor name] prices.csv
+  prices export [-format csv|ndjson] [-o file]`
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+
+	config, err := productaggregate.LoadConfig(nil, os.Getenv)
+	if err != nil {
+		log.Fatalf("Configuration failure: %s", err)
+	}
+
+	repository, err := productaggregate.NewPriceRepository(config)
+	if err != nil {
+		log.Fatalf("Initialization failure: %s", err)
+	}
+
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "import":
+		err = runImport(ctx, repository, os.Args[2:])
+
+	case "export":
+		err = runExport(ctx, repository, os.Args[2:])
+
+	default:
+		err = fmt.Errorf("unknown command %q\n%s", os.Args[1], usage)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runImport imports a CSV file of prices. Every line is validated before
+// any is imported. The last line imported is kept in a progress file next to
+// the CSV file until the import completes, so that a failed import can be
+// resumed from where it stopped.
+func runImport(ctx context.Context, repository productaggregate.ProductPriceRepository, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "show what would change without importing")
+	resume := fs.Bool("resume", false, "continue a failed import after the last line imported")
+	actor := fs.String("actor", os.Getenv("USER"), "who is changing the prices, for the price history")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import takes one CSV file\n%s", usage)
+	}
+
+	path := fs.Arg(0)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The file is hashed as it is read, so that an import is only resumed
+	// for the file it was started with
+	hash := sha256.New()
+	input := io.TeeReader(file, hash)
+	rows, errs := productaggregate.ReadPriceCSV(input)
+	if _, err := io.Copy(ioutil.Discard, input); err != nil {
+		return err
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s has %d invalid lines, nothing was imported", path, len(errs))
+	}
+
+	diffs, err := productaggregate.DiffPrices(ctx, repository, rows)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		printDiff(os.Stdout, diffs)
+		return nil
+	}
+
+	progressPath := path + ".progress"
+	resumeAfter := 0
+	if *resume {
+		resumeAfter, err = readProgress(progressPath, checksum)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Resuming after line %d", resumeAfter)
+	}
+
+	ctx = productaggregate.WithActor(ctx, *actor)
+	err = productaggregate.ImportPrices(ctx, repository, diffs, resumeAfter, func(line int) error {
+		return ioutil.WriteFile(progressPath, []byte(fmt.Sprintf("%d %s\n", line, checksum)), 0644)
+	})
+
+	if err != nil {
+		return fmt.Errorf("%s: %s\nRun again with -resume to continue after the lines already imported, or without it once the file is fixed", path, err)
+	}
+
+	if err := os.Remove(progressPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	changed := 0
+	for _, diff := range diffs {
+		if diff.Row.Line > resumeAfter && diff.Action != productaggregate.ImportUnchanged {
+			changed++
+		}
+	}
+
+	log.Printf("Imported %d changed prices from %s", changed, path)
+	return nil
+}
+
+// readProgress reads the last line imported by a failed import. The
+// progress file also holds the checksum of the file imported, which must
+// match checksum, as the line means nothing for a file since changed.
+func readProgress(path string, checksum string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, fmt.Errorf("no import of this file to resume, as %s does not exist", path)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("progress file %s is corrupt, want a line number and a checksum", path)
+	}
+
+	line, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, fmt.Errorf("progress file %s is corrupt: %s", path, err)
+	}
+
+	if fields[1] != checksum {
+		return 0, fmt.Errorf("the file has changed since the import being resumed; run it again without -resume, which skips prices already imported")
+	}
+
+	return line, nil
+}
+
+// printDiff shows what an import would change, a line at a time, followed
+// by totals
+func printDiff(w io.Writer, diffs []productaggregate.PriceDiff) {
+	counts := make(map[string]int)
+	for _, diff := range diffs {
+		counts[diff.Action]++
+
+		price := diff.Row.Price
+		window := ""
+		if price.EffectiveFrom != nil || price.EffectiveTo != nil {
+			window = " " + productaggregate.DescribeWindow(price)
+		}
+
+		switch diff.Action {
+		case productaggregate.ImportAdd:
+			fmt.Fprintf(w, "line %d: + product %d %s %s%s\n", diff.Row.Line, price.ProductID, price.Price, price.CurrencyCode, window)
+
+		case productaggregate.ImportUpdate:
+			fmt.Fprintf(w, "line %d: ~ product %d %s -> %s %s%s\n", diff.Row.Line, price.ProductID, diff.Old.Price, price.Price, price.CurrencyCode, window)
+		}
+	}
+
+	fmt.Fprintf(w, "%d to add, %d to update, %d unchanged\n", counts[productaggregate.ImportAdd], counts[productaggregate.ImportUpdate], counts[productaggregate.ImportUnchanged])
+}
+
+// runExport writes every stored price to a file, or to stdout
+func runExport(ctx context.Context, repository productaggregate.ProductPriceRepository, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", productaggregate.ExportCSV, "csv or ndjson")
+	output := fs.String("o", "", "file to write, instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		w = file
+	}
+
+	count, err := productaggregate.ExportPrices(ctx, repository, w, *format)
+	if err != nil {
+		return err
+	}
+
+	if file, ok := w.(*os.File); ok && file != os.Stdout {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Exported %d prices", count)
+	return nil
+}
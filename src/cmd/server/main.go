@@ -0,0 +1,74 @@
+// Command server runs the product aggregate service as a standalone HTTP
+// server, outside of the Google Cloud Functions runtime.
+//
+// Configuration is read from a JSON config file, the environment, and the
+// command line; run with -h to list the available flags.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"leebradley.us/productaggregate"
+)
+
+func main() {
+	config, err := productaggregate.LoadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		log.Fatalf("Configuration failure: %s", err)
+	}
+
+	handler, err := productaggregate.NewRequestHandler(config)
+	if err != nil {
+		log.Fatalf("Initialization failure: %s", err)
+	}
+
+	server := &http.Server{
+		Addr:         config.ListenAddr,
+		Handler:      handler,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+	}
+
+	if err := run(server, config.ShutdownTimeout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves until the process receives SIGINT or SIGTERM, then drains
+// in-flight requests for up to shutdownTimeout
+func run(server *http.Server, shutdownTimeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening { ADDR: %s }", server.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-serveErr:
+		return err
+
+	case sig := <-stop:
+		log.Printf("Shutting down { SIGNAL: %s }", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	return server.Shutdown(ctx)
+}
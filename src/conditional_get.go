@@ -0,0 +1,128 @@
+package productaggregate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// productETag computes a strong entity tag for the product, from the
+// version of its prices and a hash of everything else. It is the only
+// format of ETag, used for If-None-Match on GETs and If-Match on changes
+// alike, and is always compared whole. Cache ages are left out of the hash,
+// as they change while the product does not.
+func productETag(product Product) (string, error) {
+	var version int64
+	if product.CurrentPrice != nil {
+		version = product.CurrentPrice.Version
+	}
+
+	if product.Meta != nil {
+		meta := *product.Meta
+		meta.CacheAgeSeconds = nil
+
+		product.Meta = nil
+		if len(meta.Errors) > 0 {
+			product.Meta = &meta
+		}
+	}
+
+	data, err := json.Marshal(product)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf(`"%d-%s"`, version, hex.EncodeToString(sum[:8])), nil
+}
+
+// etagMatches reports whether an If-None-Match header matches etag. Entity
+// tags are compared weakly, as RFC 7232 requires for If-None-Match.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified reports whether a GET's preconditions show the client already
+// has the product. If-Modified-Since is only used when there is no
+// If-None-Match.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag)
+	}
+
+	if lastModified.IsZero() {
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// lastModified is the later of when the product's prices were updated and
+// when its name was fetched, or zero when neither is known. A name served
+// from the cache was fetched its cache age ago; any other name was fetched
+// now.
+func lastModified(product Product, outcomes []sourceOutcome, now time.Time) time.Time {
+	var modified time.Time
+	if product.CurrentPrice != nil {
+		modified = product.CurrentPrice.UpdatedAt
+	}
+
+	for _, outcome := range outcomes {
+		if outcome.name != SourceName || outcome.err != nil {
+			continue
+		}
+
+		if fetched := now.Add(-outcome.result.CacheAge); fetched.After(modified) {
+			modified = fetched
+		}
+	}
+
+	if modified.After(now) {
+		return now
+	}
+
+	return modified
+}
+
+// cacheControl lets clients cache the product for up to maxAge, less the age
+// of its cached name. Products missing data from a failed source must
+// always be revalidated.
+func cacheControl(product Product, outcomes []sourceOutcome, maxAge time.Duration) string {
+	if product.Meta != nil && len(product.Meta.Errors) > 0 {
+		return "no-cache"
+	}
+
+	for _, outcome := range outcomes {
+		if outcome.name == SourceName {
+			maxAge -= outcome.result.CacheAge
+		}
+	}
+
+	seconds := int(maxAge / time.Second)
+	if seconds <= 0 {
+		return "no-cache"
+	}
+
+	return fmt.Sprintf("max-age=%d", seconds)
+}
@@ -0,0 +1,127 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// helperConditionalHandler serves product 123, priced an hour before the
+// clock starts, with its name cached
+func helperConditionalHandler(t *testing.T, maxAge time.Duration) (RequestHandler, *InMemoryProductPriceRepository, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)}
+
+	anHourAgo := func() time.Time { return clock.Now().Add(-time.Hour) }
+	prices := helperPriceRepository(t, anHourAgo, ProductPrice{ProductID: 123, Price: MustParseMoney("13.49"), CurrencyCode: "USD"})
+
+	cache := NewCachingProductNameRepository(helperNameRepository(map[int]string{123: "Picard"}), testNameCacheOptions)
+	cache.now = clock.Now
+
+	rh := newRequestHandler(prices, cache, SourceOptions{}, SourceOptions{})
+	rh.cacheMaxAge = maxAge
+	rh.now = clock.Now
+
+	return rh, prices, clock
+}
+
+func helperConditionalGet(rh RequestHandler, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "http://example.com/123", nil)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+
+	w := httptest.NewRecorder()
+	rh.HandleRequest(w, r)
+	return w
+}
+
+func TestRequestHandlerCacheHeaders(t *testing.T) {
+	rh, _, clock := helperConditionalHandler(t, 5*time.Minute)
+
+	first := helperConditionalGet(rh, nil)
+	if first.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", first.Code)
+	}
+
+	wantHeaders := map[string]string{
+		"Cache-Control": "max-age=300",
+		"Last-Modified": "Wed, 01 Apr 2020 00:00:00 GMT",
+	}
+	for key, want := range wantHeaders {
+		if got := first.Header().Get(key); got != want {
+			t.Errorf("got %s %q, want %q", key, got, want)
+		}
+	}
+
+	// The name is now served from the cache, so the product may be cached
+	// for less time, but it has not changed
+	clock.Advance(42 * time.Second)
+	second := helperConditionalGet(rh, nil)
+
+	if got := second.Header().Get("ETag"); got != first.Header().Get("ETag") {
+		t.Errorf("got ETag %s, want %s as the product has not changed", got, first.Header().Get("ETag"))
+	}
+
+	wantHeaders["Cache-Control"] = "max-age=258"
+	for key, want := range wantHeaders {
+		if got := second.Header().Get(key); got != want {
+			t.Errorf("got %s %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestRequestHandlerNotModified(t *testing.T) {
+	rh, prices, _ := helperConditionalHandler(t, 0)
+	etag := helperConditionalGet(rh, nil).Header().Get("ETag")
+
+	var tests = []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"Matching ETag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"Weak ETag", map[string]string{"If-None-Match": "W/" + etag}, http.StatusNotModified},
+		{"One of several ETags", map[string]string{"If-None-Match": `"0-0000000000000000", ` + etag}, http.StatusNotModified},
+		{"Any ETag", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"Other ETag", map[string]string{"If-None-Match": `"0-0000000000000000"`}, http.StatusOK},
+		{"Not modified since", map[string]string{"If-Modified-Since": "Wed, 01 Apr 2020 00:00:00 GMT"}, http.StatusNotModified},
+		{"Modified since", map[string]string{"If-Modified-Since": "Tue, 31 Mar 2020 23:59:59 GMT"}, http.StatusOK},
+		{"ETag wins over the date", map[string]string{"If-None-Match": `"0-0000000000000000"`, "If-Modified-Since": "Wed, 01 Apr 2020 00:00:00 GMT"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := helperConditionalGet(rh, tt.headers)
+			if w.Code != tt.want {
+				t.Errorf("got %d, want %d", w.Code, tt.want)
+			}
+
+			if w.Code == http.StatusNotModified && (w.Body.Len() != 0 || w.Header().Get("ETag") != etag || w.Header().Get("Cache-Control") != "no-cache") {
+				t.Errorf("got body %q and headers %v, want no body and the cache headers", w.Body.String(), w.Header())
+			}
+		})
+	}
+
+	// Changing the price changes the ETag
+	prices.Put(context.Background(), ProductPrice{ProductID: 123, Price: MustParseMoney("12.99"), CurrencyCode: "USD"})
+
+	w := helperConditionalGet(rh, map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("got %d with ETag %s, want 200 with a new ETag", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestRequestHandlerPartialProductNotCached(t *testing.T) {
+	prices := helperPriceRepository(t, nil, ProductPrice{ProductID: 123, Price: MustParseMoney("13.49"), CurrencyCode: "USD"})
+
+	rh := newRequestHandler(prices, StubNameRepository{nr: nameResult{err: errors.New("RedSky is down")}}, SourceOptions{}, SourceOptions{})
+	rh.cacheMaxAge = time.Hour
+
+	w := helperConditionalGet(rh, nil)
+	if got := w.Header().Get("Cache-Control"); w.Code != http.StatusOK || got != "no-cache" {
+		t.Errorf("got %d with Cache-Control %q, want 200 with no-cache", w.Code, got)
+	}
+}
@@ -0,0 +1,542 @@
+package productaggregate
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// PriceBackendDatastore stores prices in Google Cloud Datastore
+	PriceBackendDatastore = "datastore"
+
+	// PriceBackendMemory stores prices in process memory
+	PriceBackendMemory = "memory"
+
+	// NameBackendRedSky fetches product names from Target's RedSky API
+	NameBackendRedSky = "redsky"
+
+	// NameBackendMemory serves product names from process memory
+	NameBackendMemory = "memory"
+)
+
+// Config controls how NewRequestHandler assembles a RequestHandler, and
+// how the standalone server listens
+type Config struct {
+	ListenAddr      string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	ShutdownTimeout time.Duration
+
+	PriceBackend string
+	PriceTimeout time.Duration
+	ProjectID    string
+	DatastoreID  string
+
+	// ExchangeRatesFile is a static exchange rates file used to convert
+	// prices into currencies they are not stored in. Prices are not
+	// converted when it is empty.
+	ExchangeRatesFile string
+
+	// PromotionsFile is a JSON file of promotions applied to prices. There
+	// are no promotions when it is empty.
+	PromotionsFile string
+
+	// RequireIfMatch rejects price updates which are not conditional on
+	// the prices the client last read
+	RequireIfMatch bool
+
+	// CacheMaxAge is how long clients may cache a product before
+	// revalidating it. Products are always revalidated when it is zero.
+	CacheMaxAge time.Duration
+
+	// BatchConcurrency is the most products of a batch GET assembled at
+	// once
+	BatchConcurrency int
+
+	// ProductIDMin and ProductIDMax limit the product IDs accepted. There
+	// is no maximum when ProductIDMax is zero.
+	ProductIDMin int
+	ProductIDMax int
+
+	// ProductIDPattern is a regular expression product IDs must match, as
+	// they appear in paths and request bodies
+	ProductIDPattern string
+
+	NameBackend   string
+	NameTimeout   time.Duration
+	RedSkyBaseURL string
+	RedSkyTimeout time.Duration
+
+	NameCacheSize                 int
+	NameCacheTTL                  time.Duration
+	NameCacheNegativeTTL          time.Duration
+	NameCacheStaleWhileRevalidate time.Duration
+}
+
+// DefaultConfig returns the configuration used when nothing is overridden
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:      ":8080",
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    30 * time.Second,
+		ShutdownTimeout: 10 * time.Second,
+
+		PriceBackend: PriceBackendDatastore,
+		PriceTimeout: 5 * time.Second,
+
+		BatchConcurrency: 16,
+
+		ProductIDMin:     1,
+		ProductIDPattern: "^[0-9]+$",
+
+		NameBackend:   NameBackendRedSky,
+		NameTimeout:   5 * time.Second,
+		RedSkyBaseURL: "https://redsky.target.com",
+		RedSkyTimeout: 10 * time.Second,
+
+		NameCacheSize:                 10000,
+		NameCacheTTL:                  time.Hour,
+		NameCacheNegativeTTL:          5 * time.Minute,
+		NameCacheStaleWhileRevalidate: 24 * time.Hour,
+	}
+}
+
+// setting describes one configuration value. Every setting can be given as
+// an environment variable, a command line flag, or a key in the config file
+// (using the flag name as the key).
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+func stringSetting(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func durationSetting(field func(c *Config) *time.Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+
+		*field(c) = d
+		return nil
+	}
+}
+
+func intSetting(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+
+		*field(c) = i
+		return nil
+	}
+}
+
+func boolSetting(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+
+		*field(c) = b
+		return nil
+	}
+}
+
+var settings = []setting{
+	{
+		env:   "PORT",
+		usage: "port to listen on, used when no listen address is given",
+		set: func(c *Config, value string) error {
+			c.ListenAddr = ":" + value
+			return nil
+		},
+	},
+	{
+		env:   "LISTEN_ADDR",
+		flag:  "addr",
+		usage: "address to listen on",
+		set:   stringSetting(func(c *Config) *string { return &c.ListenAddr }),
+	},
+	{
+		env:   "READ_TIMEOUT",
+		flag:  "read-timeout",
+		usage: "maximum time to read a request",
+		set:   durationSetting(func(c *Config) *time.Duration { return &c.ReadTimeout }),
+	},
+	{
+		env:   "WRITE_TIMEOUT",
+		flag:  "write-timeout",
+		usage: "maximum time to write a response",
+		set:   durationSetting(func(c *Config) *time.Duration { return &c.WriteTimeout }),
+	},
+	{
+		env:   "SHUTDOWN_TIMEOUT",
+		flag:  "shutdown-timeout",
+		usage: "time allowed for in-flight requests to finish on shutdown",
+		set:   durationSetting(func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	},
+	{
+		env:   "PRICE_BACKEND",
+		flag:  "price-backend",
+		usage: "price repository: datastore or memory",
+		set:   stringSetting(func(c *Config) *string { return &c.PriceBackend }),
+	},
+	{
+		env:   "PRICE_TIMEOUT",
+		flag:  "price-timeout",
+		usage: "deadline for fetching or storing a price",
+		set:   durationSetting(func(c *Config) *time.Duration { return &c.PriceTimeout }),
+	},
+	{
+		env:   "PROJECT_ID",
+		flag:  "project-id",
+		usage: "Google Cloud project holding the datastore",
+		set:   stringSetting(func(c *Config) *string { return &c.ProjectID }),
+	},
+	{
+		env:   "DATASTORE_ID",
+		flag:  "datastore-id",
+		usage: "datastore kind holding product prices",
+		set:   stringSetting(func(c *Config) *string { return &c.DatastoreID }),
+	},
+	{
+		env:   "EXCHANGE_RATES_FILE",
+		flag:  "exchange-rates-file",
+		usage: "JSON file of exchange rates for converting prices; prices are not converted when empty",
+		set:   stringSetting(func(c *Config) *string { return &c.ExchangeRatesFile }),
+	},
+	{
+		env:   "PROMOTIONS_FILE",
+		flag:  "promotions-file",
+		usage: "JSON file of promotions applied to prices; there are no promotions when empty",
+		set:   stringSetting(func(c *Config) *string { return &c.PromotionsFile }),
+	},
+	{
+		env:   "REQUIRE_IF_MATCH",
+		flag:  "require-if-match",
+		usage: "reject price updates without an If-Match header",
+		set:   boolSetting(func(c *Config) *bool { return &c.RequireIfMatch }),
+	},
+	{
+		env:   "CACHE_MAX_AGE",
+		flag:  "cache-max-age",
+		usage: "how long clients may cache a product before revalidating it",
+		set:   durationSetting(func(c *Config) *time.Duration { return &c.CacheMaxAge }),
+	},
+	{
+		env:   "BATCH_CONCURRENCY",
+		flag:  "batch-concurrency",
+		usage: "most products of a batch GET assembled at once",
+		set:   intSetting(func(c *Config) *int { return &c.BatchConcurrency }),
+	},
+	{
+		env:   "PRODUCT_ID_MIN",
+		flag:  "product-id-min",
+		usage: "smallest product ID accepted",
+		set:   intSetting(func(c *Config) *int { return &c.ProductIDMin }),
+	},
+	{
+		env:   "PRODUCT_ID_MAX",
+		flag:  "product-id-max",
+		usage: "largest product ID accepted, 0 for no maximum",
+		set:   intSetting(func(c *Config) *int { return &c.ProductIDMax }),
+	},
+	{
+		env:   "PRODUCT_ID_PATTERN",
+		flag:  "product-id-pattern",
+		usage: "regular expression product IDs must match",
+		set:   stringSetting(func(c *Config) *string { return &c.ProductIDPattern }),
+	},
+	{
+		env:   "NAME_BACKEND",
+		flag:  "name-backend",
+		usage: "name repository: redsky or memory",
+		set:   stringSetting(func(c *Config) *string { return &c.NameBackend }),
+	},
+	{
+		env:   "NAME_TIMEOUT",
+		flag:  "name-timeout",
+		usage: "deadline for fetching a name; slower names are left out of the product",
+		set:   durationSetting(func(c *Config) *time.Duration { return &c.NameTimeout }),
+	},
+	{
+		env:   "REDSKY_BASE_URL",
+		flag:  "redsky-base-url",
+		usage: "base URL of the RedSky API",
+		set:   stringSetting(func(c *Config) *string { return &c.RedSkyBaseURL }),
+	},
+	{
+		env:   "REDSKY_TIMEOUT",
+		flag:  "redsky-timeout",
+		usage: "timeout for requests to the RedSky API",
+		set:   durationSetting(func(c *Config) *time.Duration { return &c.RedSkyTimeout }),
+	},
+	{
+		env:   "NAME_CACHE_SIZE",
+		flag:  "name-cache-size",
+		usage: "number of product names to cache, 0 to disable the cache",
+		set:   intSetting(func(c *Config) *int { return &c.NameCacheSize }),
+	},
+	{
+		env:   "NAME_CACHE_TTL",
+		flag:  "name-cache-ttl",
+		usage: "how long a cached product name is fresh",
+		set:   durationSetting(func(c *Config) *time.Duration { return &c.NameCacheTTL }),
+	},
+	{
+		env:   "NAME_CACHE_NEGATIVE_TTL",
+		flag:  "name-cache-negative-ttl",
+		usage: "how long an unknown product is remembered",
+		set:   durationSetting(func(c *Config) *time.Duration { return &c.NameCacheNegativeTTL }),
+	},
+	{
+		env:   "NAME_CACHE_STALE_WHILE_REVALIDATE",
+		flag:  "name-cache-stale-while-revalidate",
+		usage: "how long an expired name may be served while it is refreshed",
+		set:   durationSetting(func(c *Config) *time.Duration { return &c.NameCacheStaleWhileRevalidate }),
+	},
+}
+
+// flagValues collects command line flags so they can be applied after the
+// config file and the environment
+type flagValues struct {
+	name   string
+	values map[string]string
+}
+
+func (f flagValues) String() string {
+	return f.values[f.name]
+}
+
+func (f flagValues) Set(value string) error {
+	f.values[f.name] = value
+	return nil
+}
+
+// LoadConfig builds a Config from, in increasing order of precedence, the
+// defaults, an optional JSON config file, the environment, and the command
+// line. The config file is named by the -config flag or the CONFIG_FILE
+// environment variable. The result is validated before it is returned.
+func LoadConfig(args []string, getenv func(string) string) (Config, error) {
+	config := DefaultConfig()
+
+	fs := flag.NewFlagSet("productaggregate", flag.ContinueOnError)
+	configFile := fs.String("config", getenv("CONFIG_FILE"), "path to a JSON config file")
+
+	flags := make(map[string]string)
+	for _, s := range settings {
+		if s.flag != "" {
+			fs.Var(flagValues{name: s.flag, values: flags}, s.flag, s.usage)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return config, err
+	}
+
+	if *configFile != "" {
+		if err := config.applyFile(*configFile); err != nil {
+			return config, err
+		}
+	}
+
+	for _, s := range settings {
+		value := getenv(s.env)
+		if value == "" {
+			continue
+		}
+
+		if err := s.set(&config, value); err != nil {
+			return config, fmt.Errorf("%s: %s", s.env, err)
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := flags[s.flag]
+		if s.flag == "" || !ok {
+			continue
+		}
+
+		if err := s.set(&config, value); err != nil {
+			return config, fmt.Errorf("-%s: %s", s.flag, err)
+		}
+	}
+
+	return config, config.Validate()
+}
+
+func (c *Config) applyFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %s", err)
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("parsing config file %s: %s", path, err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s, ok := settingForFlag(key)
+		if !ok {
+			return fmt.Errorf("config file %s: unknown setting %q", path, key)
+		}
+
+		if err := s.set(c, values[key]); err != nil {
+			return fmt.Errorf("config file %s: %s: %s", path, key, err)
+		}
+	}
+
+	return nil
+}
+
+func settingForFlag(name string) (setting, bool) {
+	for _, s := range settings {
+		if s.flag != "" && s.flag == name {
+			return s, true
+		}
+	}
+
+	return setting{}, false
+}
+
+// ConfigError lists every problem found while validating a Config
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks that the Config describes a usable service
+func (c Config) Validate() error {
+	var problems []string
+
+	if c.ListenAddr == "" {
+		problems = append(problems, "listen address must not be empty")
+	}
+
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read timeout", c.ReadTimeout},
+		{"write timeout", c.WriteTimeout},
+		{"shutdown timeout", c.ShutdownTimeout},
+		{"price timeout", c.PriceTimeout},
+		{"name timeout", c.NameTimeout},
+		{"RedSky timeout", c.RedSkyTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be positive, got %s", timeout.name, timeout.value))
+		}
+	}
+
+	if c.BatchConcurrency <= 0 {
+		problems = append(problems, fmt.Sprintf("batch concurrency must be positive, got %d", c.BatchConcurrency))
+	}
+
+	if c.CacheMaxAge < 0 {
+		problems = append(problems, fmt.Sprintf("cache max age must not be negative, got %s", c.CacheMaxAge))
+	}
+
+	if _, err := newProductIDRules(c.ProductIDMin, c.ProductIDMax, c.ProductIDPattern); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if c.NameCacheSize < 0 {
+		problems = append(problems, fmt.Sprintf("name cache size must not be negative, got %d", c.NameCacheSize))
+	}
+
+	if c.NameCacheSize > 0 {
+		if c.NameCacheTTL <= 0 {
+			problems = append(problems, fmt.Sprintf("name cache TTL must be positive, got %s", c.NameCacheTTL))
+		}
+
+		if c.NameCacheNegativeTTL < 0 {
+			problems = append(problems, fmt.Sprintf("name cache negative TTL must not be negative, got %s", c.NameCacheNegativeTTL))
+		}
+
+		if c.NameCacheStaleWhileRevalidate < 0 {
+			problems = append(problems, fmt.Sprintf("name cache stale-while-revalidate must not be negative, got %s", c.NameCacheStaleWhileRevalidate))
+		}
+	}
+
+	switch c.PriceBackend {
+	case PriceBackendDatastore:
+		if c.ProjectID == "" {
+			problems = append(problems, "PROJECT_ID is required for the datastore price backend")
+		}
+
+		if c.DatastoreID == "" {
+			problems = append(problems, "DATASTORE_ID is required for the datastore price backend")
+		}
+
+	case PriceBackendMemory:
+
+	default:
+		problems = append(problems, fmt.Sprintf("unknown price backend %q, want %s or %s", c.PriceBackend, PriceBackendDatastore, PriceBackendMemory))
+	}
+
+	switch c.NameBackend {
+	case NameBackendRedSky:
+		if err := validateBaseURL(c.RedSkyBaseURL); err != nil {
+			problems = append(problems, fmt.Sprintf("RedSky base URL: %s", err))
+		}
+
+	case NameBackendMemory:
+
+	default:
+		problems = append(problems, fmt.Sprintf("unknown name backend %q, want %s or %s", c.NameBackend, NameBackendRedSky, NameBackendMemory))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return &ConfigError{Problems: problems}
+}
+
+func validateBaseURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must be an http or https URL", raw)
+	}
+
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", raw)
+	}
+
+	return nil
+}
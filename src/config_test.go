@@ -0,0 +1,252 @@
+package productaggregate
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func helperGetenv(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func helperWriteConfigFile(t *testing.T, data string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	env := map[string]string{
+		"PROJECT_ID":   "project",
+		"DATASTORE_ID": "products",
+	}
+
+	config, err := LoadConfig(nil, helperGetenv(env))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := DefaultConfig()
+	want.ProjectID = "project"
+	want.DatastoreID = "products"
+	if config != want {
+		t.Errorf("got %+v, want %+v", config, want)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := helperWriteConfigFile(t, `{
+		"price-backend": "memory",
+		"name-backend": "memory",
+		"addr": ":7000",
+		"redsky-timeout": "1s"
+	}`)
+
+	env := map[string]string{
+		"CONFIG_FILE":    path,
+		"LISTEN_ADDR":    ":8000",
+		"REDSKY_TIMEOUT": "2s",
+	}
+	args := []string{"-redsky-timeout", "3s"}
+
+	config, err := LoadConfig(args, helperGetenv(env))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if config.PriceBackend != PriceBackendMemory {
+		t.Errorf("got price backend %s, want %s from the config file", config.PriceBackend, PriceBackendMemory)
+	}
+
+	if config.ListenAddr != ":8000" {
+		t.Errorf("got listen address %s, want :8000 from the environment", config.ListenAddr)
+	}
+
+	if config.RedSkyTimeout != 3*time.Second {
+		t.Errorf("got RedSky timeout %s, want 3s from the command line", config.RedSkyTimeout)
+	}
+}
+
+func TestLoadConfigPort(t *testing.T) {
+	env := map[string]string{
+		"PRICE_BACKEND": "memory",
+		"PORT":          "9090",
+	}
+
+	config, err := LoadConfig(nil, helperGetenv(env))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if config.ListenAddr != ":9090" {
+		t.Errorf("got %s, want :9090", config.ListenAddr)
+	}
+}
+
+var loadConfigErrorTests = []struct {
+	name string
+	args []string
+	env  map[string]string
+	file string
+	want []string
+}{
+	{
+		name: "Missing datastore settings",
+		want: []string{
+			"PROJECT_ID is required for the datastore price backend",
+			"DATASTORE_ID is required for the datastore price backend",
+		},
+	},
+	{
+		name: "Unknown backends",
+		env: map[string]string{
+			"PRICE_BACKEND": "mysql",
+			"NAME_BACKEND":  "walmart",
+		},
+		want: []string{
+			`unknown price backend "mysql"`,
+			`unknown name backend "walmart"`,
+		},
+	},
+	{
+		name: "Bad RedSky base URL",
+		args: []string{"-price-backend", "memory", "-redsky-base-url", "redsky.target.com"},
+		want: []string{`RedSky base URL: "redsky.target.com" must be an http or https URL`},
+	},
+	{
+		name: "Negative timeout",
+		args: []string{"-price-backend", "memory", "-write-timeout", "-1s"},
+		want: []string{"write timeout must be positive, got -1s"},
+	},
+	{
+		name: "Bad product ID rules",
+		args: []string{"-price-backend", "memory", "-product-id-min", "10", "-product-id-max", "5", "-product-id-pattern", "[0-9"},
+		want: []string{
+			"product ID max must be 0 or at least the min of 10, got 5",
+			`invalid product ID pattern "[0-9"`,
+		},
+	},
+	{
+		name: "Unparseable duration",
+		env:  map[string]string{"REDSKY_TIMEOUT": "soon"},
+		want: []string{`REDSKY_TIMEOUT: invalid duration "soon"`},
+	},
+	{
+		name: "Unparseable boolean",
+		env:  map[string]string{"REQUIRE_IF_MATCH": "sometimes"},
+		want: []string{`REQUIRE_IF_MATCH: invalid boolean "sometimes"`},
+	},
+	{
+		name: "Unknown flag",
+		args: []string{"-colour", "blue"},
+		want: []string{"flag provided but not defined: -colour"},
+	},
+	{
+		name: "Unknown config file setting",
+		file: `{"colour": "blue"}`,
+		want: []string{`unknown setting "colour"`},
+	},
+	{
+		name: "Malformed config file",
+		file: `price-backend=memory`,
+		want: []string{"parsing config file"},
+	},
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, tt := range loadConfigErrorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{}
+			for key, value := range tt.env {
+				env[key] = value
+			}
+
+			if tt.file != "" {
+				env["CONFIG_FILE"] = helperWriteConfigFile(t, tt.file)
+			}
+
+			_, err := LoadConfig(tt.args, helperGetenv(env))
+			if err == nil {
+				t.Fatal("expected error. none found")
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("got %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRequestHandlerValidatesConfig(t *testing.T) {
+	_, err := NewRequestHandler(DefaultConfig())
+
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Errorf("got %+v, want a *ConfigError", err)
+	}
+}
+
+func TestNewRequestHandlerInMemory(t *testing.T) {
+	config := DefaultConfig()
+	config.PriceBackend = PriceBackendMemory
+	config.NameBackend = NameBackendMemory
+
+	if _, err := NewRequestHandler(config); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestNewRequestHandlerExchangeRates(t *testing.T) {
+	config := DefaultConfig()
+	config.PriceBackend = PriceBackendMemory
+	config.NameBackend = NameBackendMemory
+
+	config.ExchangeRatesFile = "testdata/exchange_rates.json"
+	if _, err := NewRequestHandler(config); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	config.ExchangeRatesFile = "testdata/missing.json"
+	if _, err := NewRequestHandler(config); err == nil {
+		t.Error("expected error. none found")
+	}
+}
+
+func TestNewRequestHandlerPromotions(t *testing.T) {
+	config := DefaultConfig()
+	config.PriceBackend = PriceBackendMemory
+	config.NameBackend = NameBackendMemory
+
+	config.PromotionsFile = "testdata/promotions.json"
+	rh, err := NewRequestHandler(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(rh.sources) != 3 || rh.sources[2].name != SourcePromotion {
+		t.Errorf("got sources %+v, want the promotion source registered last", rh.sources)
+	}
+
+	config.PromotionsFile = "testdata/exchange_rates.json"
+	if _, err := NewRequestHandler(config); err == nil {
+		t.Error("expected error. none found")
+	}
+}
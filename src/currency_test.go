@@ -0,0 +1,38 @@
+package productaggregate
+
+import (
+	"testing"
+)
+
+var lookupCurrencyTests = []struct {
+	in        string
+	want      Currency
+	wantFound bool
+}{
+	{"USD", Currency{Code: "USD", MinorUnits: 2}, true},
+	{"usd", Currency{Code: "USD", MinorUnits: 2}, true},
+	{" eur ", Currency{Code: "EUR", MinorUnits: 2}, true},
+	{"JPY", Currency{Code: "JPY", MinorUnits: 0}, true},
+	{"KWD", Currency{Code: "KWD", MinorUnits: 3}, true},
+	{"CLF", Currency{Code: "CLF", MinorUnits: 4}, true},
+	{"XAU", Currency{}, false},
+	{"ABC", Currency{}, false},
+	{"", Currency{}, false},
+}
+
+func TestLookupCurrency(t *testing.T) {
+	for _, tt := range lookupCurrencyTests {
+		t.Run(tt.in, func(t *testing.T) {
+			currency, found := LookupCurrency(tt.in)
+			if currency != tt.want || found != tt.wantFound {
+				t.Errorf("got %+v %t, want %+v %t", currency, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestCurrencyExponentFallsBackToCents(t *testing.T) {
+	if exponent := CurrencyExponent("ABC"); exponent != 2 {
+		t.Errorf("got %d, want 2", exponent)
+	}
+}
@@ -0,0 +1,310 @@
+package productaggregate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"time"
+)
+
+// ErrRateNotFound is returned when no exchange rate is known between two
+// currencies
+var ErrRateNotFound = errors.New("exchange rate not found")
+
+// crossRateExponent is the number of decimal places kept for rates derived
+// from two others, which rarely have an exact decimal form
+const crossRateExponent = 10
+
+// ExchangeRate is the number of units of To that one unit of From buys
+type ExchangeRate struct {
+	From      string
+	To        string
+	Rate      Money
+	Timestamp time.Time
+}
+
+// ExchangeRateProvider looks up exchange rates between currencies. A
+// provider which does not know a pair should return ErrRateNotFound.
+type ExchangeRateProvider interface {
+	Rate(ctx context.Context, from string, to string) (ExchangeRate, error)
+}
+
+// StaticExchangeRates serves rates from a fixed table, quoted against a
+// single base currency. Rates between two other currencies are derived
+// through the base.
+type StaticExchangeRates struct {
+	base      string
+	timestamp time.Time
+	rates     map[string]Money
+}
+
+// staticRatesFile is the format of a static exchange rates file, such as
+//
+//	{
+//	  "base": "USD",
+//	  "timestamp": "2026-10-01T00:00:00Z",
+//	  "rates": {"EUR": 0.92, "GBP": "0.79"}
+//	}
+type staticRatesFile struct {
+	Base      string           `json:"base"`
+	Timestamp time.Time        `json:"timestamp"`
+	Rates     map[string]Money `json:"rates"`
+}
+
+// LoadStaticExchangeRates reads a static exchange rates file
+func LoadStaticExchangeRates(path string) (*StaticExchangeRates, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading exchange rates file: %s", err)
+	}
+
+	rates, err := parseStaticExchangeRates(data)
+	if err != nil {
+		return nil, fmt.Errorf("exchange rates file %s: %s", path, err)
+	}
+
+	return rates, nil
+}
+
+func parseStaticExchangeRates(data []byte) (*StaticExchangeRates, error) {
+	var file staticRatesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	base, ok := LookupCurrency(file.Base)
+	if !ok {
+		return nil, fmt.Errorf("base %q is not an ISO 4217 currency code", file.Base)
+	}
+
+	if file.Timestamp.IsZero() {
+		return nil, errors.New("timestamp is missing")
+	}
+
+	rates := make(map[string]Money, len(file.Rates))
+	for code, rate := range file.Rates {
+		currency, ok := LookupCurrency(code)
+		if !ok {
+			return nil, fmt.Errorf("%q is not an ISO 4217 currency code", code)
+		}
+
+		if rate.Sign() <= 0 {
+			return nil, fmt.Errorf("rate for %s must be positive, got %s", currency.Code, rate)
+		}
+
+		rates[currency.Code] = rate
+	}
+
+	return &StaticExchangeRates{
+		base:      base.Code,
+		timestamp: file.Timestamp,
+		rates:     rates,
+	}, nil
+}
+
+// Rate returns the rate from one currency to another
+func (s *StaticExchangeRates) Rate(ctx context.Context, from string, to string) (ExchangeRate, error) {
+	rate := ExchangeRate{
+		From:      from,
+		To:        to,
+		Timestamp: s.timestamp,
+	}
+
+	fromRate, ok := s.rateFromBase(from)
+	if !ok {
+		return ExchangeRate{}, fmt.Errorf("%w: %s to %s", ErrRateNotFound, from, to)
+	}
+
+	toRate, ok := s.rateFromBase(to)
+	if !ok {
+		return ExchangeRate{}, fmt.Errorf("%w: %s to %s", ErrRateNotFound, from, to)
+	}
+
+	switch {
+	case from == to:
+		rate.Rate = NewMoney(1, 0)
+		return rate, nil
+
+	case from == s.base:
+		rate.Rate = toRate
+		return rate, nil
+	}
+
+	cross := new(big.Rat).Quo(toRate.Rat(), fromRate.Rat())
+	value, err := moneyFromRat(cross, crossRateExponent)
+	if err != nil {
+		return ExchangeRate{}, err
+	}
+
+	rate.Rate = value
+	return rate, nil
+}
+
+func (s *StaticExchangeRates) rateFromBase(code string) (Money, bool) {
+	if code == s.base {
+		return NewMoney(1, 0), true
+	}
+
+	rate, ok := s.rates[code]
+	return rate, ok
+}
+
+// PriceConversion describes how a price was converted from the product's
+// base currency price. Value is FromValue multiplied by Rate, rounded half
+// away from zero to the minor unit of the price's currency.
+type PriceConversion struct {
+	FromValue        Money     `json:"from_value"`
+	FromCurrencyCode string    `json:"from_currency_code"`
+	Rate             Money     `json:"rate"`
+	RateTimestamp    time.Time `json:"rate_timestamp"`
+}
+
+// ConvertingProductPriceRepository wraps another ProductPriceRepository.
+// When a product has no price in the requested currency, its base currency
+// price is converted instead.
+type ConvertingProductPriceRepository struct {
+	next  ProductPriceRepository
+	rates ExchangeRateProvider
+}
+
+// NewConvertingProductPriceRepository creates a new
+// ConvertingProductPriceRepository in front of next
+func NewConvertingProductPriceRepository(next ProductPriceRepository, rates ExchangeRateProvider) *ConvertingProductPriceRepository {
+	return &ConvertingProductPriceRepository{
+		next:  next,
+		rates: rates,
+	}
+}
+
+// Get fetches a product price by id, converting it when there is none in
+// the requested currency. When there is no rate either, the price is not
+// found.
+func (c *ConvertingProductPriceRepository) Get(ctx context.Context, productID int, query PriceQuery) (*ProductPrice, error) {
+	price, err := c.next.Get(ctx, productID, query)
+	if !errors.Is(err, ErrCurrencyNotFound) || query.CurrencyCode == "" {
+		return price, err
+	}
+
+	baseQuery := query
+	baseQuery.CurrencyCode = ""
+
+	base, baseErr := c.next.Get(ctx, productID, baseQuery)
+	if baseErr != nil {
+		return base, baseErr
+	}
+
+	converted, convertErr := c.convert(ctx, base, query.CurrencyCode)
+	if errors.Is(convertErr, ErrRateNotFound) {
+		return price, err
+	}
+
+	return converted, convertErr
+}
+
+// GetMulti fetches many products' prices, converting those with none in the
+// requested currency. It uses the wrapped repository's GetMulti when it has
+// one.
+func (c *ConvertingProductPriceRepository) GetMulti(ctx context.Context, productIDs []int, query PriceQuery) ([]*ProductPrice, []error) {
+	prices, errs := getMultiPrices(ctx, c.next, productIDs, query)
+	if query.CurrencyCode == "" {
+		return prices, errs
+	}
+
+	var unconverted []int
+	var positions []int
+	for i, err := range errs {
+		if errors.Is(err, ErrCurrencyNotFound) {
+			unconverted = append(unconverted, productIDs[i])
+			positions = append(positions, i)
+		}
+	}
+
+	if len(unconverted) == 0 {
+		return prices, errs
+	}
+
+	baseQuery := query
+	baseQuery.CurrencyCode = ""
+
+	bases, baseErrs := getMultiPrices(ctx, c.next, unconverted, baseQuery)
+	for j, i := range positions {
+		if baseErrs[j] != nil {
+			prices[i], errs[i] = bases[j], baseErrs[j]
+			continue
+		}
+
+		converted, err := c.convert(ctx, bases[j], query.CurrencyCode)
+		if errors.Is(err, ErrRateNotFound) {
+			continue
+		}
+
+		prices[i], errs[i] = converted, err
+	}
+
+	return prices, errs
+}
+
+// convert converts a base currency price into currencyCode
+func (c *ConvertingProductPriceRepository) convert(ctx context.Context, base *ProductPrice, currencyCode string) (*ProductPrice, error) {
+	rate, err := c.rates.Rate(ctx, base.CurrencyCode, currencyCode)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := base.Price.Mul(rate.Rate.Rat(), CurrencyExponent(currencyCode))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProductPrice{
+		ProductID:    base.ProductID,
+		Price:        value,
+		CurrencyCode: currencyCode,
+		Version:      base.Version,
+		UpdatedAt:    base.UpdatedAt,
+		Conversion: &PriceConversion{
+			FromValue:        base.Price,
+			FromCurrencyCode: base.CurrencyCode,
+			Rate:             rate.Rate,
+			RateTimestamp:    rate.Timestamp,
+		},
+	}, nil
+}
+
+// Put updates a product price
+func (c *ConvertingProductPriceRepository) Put(ctx context.Context, price ProductPrice) error {
+	return c.next.Put(ctx, price)
+}
+
+// PutMulti updates many product prices
+func (c *ConvertingProductPriceRepository) PutMulti(ctx context.Context, prices []ProductPrice, options PutMultiOptions) []error {
+	return putMultiPrices(ctx, c.next, prices, options)
+}
+
+// List pages through the stored prices, without converting them
+func (c *ConvertingProductPriceRepository) List(ctx context.Context, query PriceListQuery) (PriceListPage, error) {
+	return c.next.List(ctx, query)
+}
+
+// Replace swaps one of a product's prices for another
+func (c *ConvertingProductPriceRepository) Replace(ctx context.Context, original ProductPrice, price ProductPrice) error {
+	return c.next.Replace(ctx, original, price)
+}
+
+// Delete soft deletes a product's prices
+func (c *ConvertingProductPriceRepository) Delete(ctx context.Context, productID int, version int64) error {
+	return c.next.Delete(ctx, productID, version)
+}
+
+// Undelete restores a product's deleted prices
+func (c *ConvertingProductPriceRepository) Undelete(ctx context.Context, productID int) error {
+	return c.next.Undelete(ctx, productID)
+}
+
+// History lists a product's price changes
+func (c *ConvertingProductPriceRepository) History(ctx context.Context, productID int, query PriceHistoryQuery) (PriceHistoryPage, error) {
+	return c.next.History(ctx, productID, query)
+}
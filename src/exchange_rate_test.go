@@ -0,0 +1,163 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func helperStaticExchangeRates(t *testing.T) *StaticExchangeRates {
+	rates, err := LoadStaticExchangeRates("testdata/exchange_rates.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return rates
+}
+
+var exchangeRateTests = []struct {
+	from string
+	to   string
+	want string
+}{
+	{"USD", "EUR", "0.92"},
+	{"USD", "USD", "1"},
+	{"EUR", "EUR", "1"},
+	{"EUR", "USD", "1.0869565217"},
+	{"EUR", "GBP", "0.8586956522"},
+	{"GBP", "JPY", "190.1898734177"},
+}
+
+func TestStaticExchangeRatesRate(t *testing.T) {
+	rates := helperStaticExchangeRates(t)
+	timestamp := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tt := range exchangeRateTests {
+		t.Run(tt.from+tt.to, func(t *testing.T) {
+			rate, err := rates.Rate(context.Background(), tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if rate.Rate.String() != tt.want || !rate.Timestamp.Equal(timestamp) {
+				t.Errorf("got %s at %s, want %s at %s", rate.Rate, rate.Timestamp, tt.want, timestamp)
+			}
+		})
+	}
+}
+
+func TestStaticExchangeRatesRateNotFound(t *testing.T) {
+	rates := helperStaticExchangeRates(t)
+
+	for _, pair := range [][2]string{{"USD", "CHF"}, {"CHF", "USD"}, {"CHF", "EUR"}} {
+		if _, err := rates.Rate(context.Background(), pair[0], pair[1]); !errors.Is(err, ErrRateNotFound) {
+			t.Errorf("%s to %s: got error %+v, want %+v", pair[0], pair[1], err, ErrRateNotFound)
+		}
+	}
+}
+
+var parseStaticExchangeRatesErrorTests = []struct {
+	name string
+	data string
+	want string
+}{
+	{"Malformed", `{"base":`, "unexpected end of JSON input"},
+	{"Unknown base", `{"base":"XYZ","timestamp":"2026-10-01T00:00:00Z"}`, `base "XYZ" is not an ISO 4217 currency code`},
+	{"Missing timestamp", `{"base":"USD","rates":{"EUR":0.92}}`, "timestamp is missing"},
+	{"Unknown currency", `{"base":"USD","timestamp":"2026-10-01T00:00:00Z","rates":{"XYZ":1}}`, `"XYZ" is not an ISO 4217 currency code`},
+	{"Zero rate", `{"base":"USD","timestamp":"2026-10-01T00:00:00Z","rates":{"EUR":0}}`, "rate for EUR must be positive"},
+}
+
+func TestParseStaticExchangeRatesErrors(t *testing.T) {
+	for _, tt := range parseStaticExchangeRatesErrorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseStaticExchangeRates([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %+v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+var convertingPriceTests = []struct {
+	name     string
+	currency string
+	want     string
+	from     string
+	err      error
+}{
+	{"Base currency is not converted", "", "13.49 USD", "", nil},
+	{"Stored currency is not converted", "GBP", "10.99 GBP", "", nil},
+	{"Rounded to cents", "EUR", "12.41 EUR", "13.49 USD", nil},
+	{"Rounded to whole yen", "JPY", "2027 JPY", "13.49 USD", nil},
+	{"No rate", "CHF", "", "", ErrCurrencyNotFound},
+}
+
+func TestConvertingProductPriceRepositoryGet(t *testing.T) {
+	stored := helperPriceRepository(t, nil,
+		ProductPrice{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+		ProductPrice{ProductID: 10, Price: MustParseMoney("10.99"), CurrencyCode: "GBP"},
+	)
+
+	repository := NewConvertingProductPriceRepository(stored, helperStaticExchangeRates(t))
+
+	for _, tt := range convertingPriceTests {
+		t.Run(tt.name, func(t *testing.T) {
+			price, err := repository.Get(context.Background(), 10, PriceQuery{CurrencyCode: tt.currency})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %+v, want %+v", err, tt.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if got := price.Price.String() + " " + price.CurrencyCode; got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+
+			from := ""
+			if price.Conversion != nil {
+				from = price.Conversion.FromValue.String() + " " + price.Conversion.FromCurrencyCode
+			}
+
+			if from != tt.from {
+				t.Errorf("got conversion from %q, want %q", from, tt.from)
+			}
+		})
+	}
+}
+
+func TestConvertingProductPriceRepositoryProductNotFound(t *testing.T) {
+	repository := NewConvertingProductPriceRepository(NewInMemoryProductPriceRepository(), helperStaticExchangeRates(t))
+
+	_, err := repository.Get(context.Background(), 10, PriceQuery{CurrencyCode: "EUR"})
+	if !errors.Is(err, ErrPriceNotFound) {
+		t.Errorf("got error %+v, want %+v", err, ErrPriceNotFound)
+	}
+}
+
+func TestRequestHandlerConvertedPrice(t *testing.T) {
+	stored := helperPriceRepository(t, nil, ProductPrice{ProductID: 123, Price: MustParseMoney("13.49"), CurrencyCode: "USD"})
+
+	rh := newRequestHandler(
+		NewConvertingProductPriceRepository(stored, helperStaticExchangeRates(t)),
+		StubNameRepository{
+			nr: nameResult{name: "Picard"},
+		},
+		SourceOptions{},
+		SourceOptions{},
+	)
+
+	w := httptest.NewRecorder()
+	rh.HandleRequest(w, httptest.NewRequest("GET", "http://example.com/123?currency=EUR", nil))
+
+	want := `{"product_id":123,"name":"Picard","current_price":{"value":12.41,"currency_code":"EUR","conversion":{"from_value":13.49,"from_currency_code":"USD","rate":0.92,"rate_timestamp":"2026-10-01T00:00:00Z"}}}`
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("got %d %s, want 200 %s", w.Code, w.Body.String(), want)
+	}
+}
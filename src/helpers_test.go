@@ -0,0 +1,138 @@
+package productaggregate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type priceGetResult struct {
+	price *ProductPrice
+	err   error
+}
+
+type nameResult struct {
+	name string
+	err  error
+}
+
+// StubPriceRepository answers every call with fixed results. It is the one
+// fake ProductPriceRepository; tests needing more embed it, or an
+// InMemoryProductPriceRepository, and override only what they need.
+type StubPriceRepository struct {
+	pgr priceGetResult
+	ppr error
+}
+
+func (s StubPriceRepository) Get(ctx context.Context, productID int, query PriceQuery) (*ProductPrice, error) {
+	return s.pgr.price, s.pgr.err
+}
+
+func (s StubPriceRepository) Put(ctx context.Context, price ProductPrice) error {
+	return s.ppr
+}
+
+func (s StubPriceRepository) Replace(ctx context.Context, original ProductPrice, price ProductPrice) error {
+	return s.ppr
+}
+
+func (s StubPriceRepository) History(ctx context.Context, productID int, query PriceHistoryQuery) (PriceHistoryPage, error) {
+	return PriceHistoryPage{}, nil
+}
+
+func (s StubPriceRepository) List(ctx context.Context, query PriceListQuery) (PriceListPage, error) {
+	return PriceListPage{}, nil
+}
+
+func (s StubPriceRepository) Delete(ctx context.Context, productID int, version int64) error {
+	return s.ppr
+}
+
+func (s StubPriceRepository) Undelete(ctx context.Context, productID int) error {
+	return s.ppr
+}
+
+type StubNameRepository struct {
+	nr nameResult
+}
+
+func (s StubNameRepository) Get(ctx context.Context, productID int) (string, error) {
+	return s.nr.name, s.nr.err
+}
+
+// fakeClock is a clock tests move by hand
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+}
+
+// helperPriceRepository puts prices, in order, into a new in-memory
+// repository. When now is set, it is the repository's clock.
+func helperPriceRepository(t *testing.T, now func() time.Time, prices ...ProductPrice) *InMemoryProductPriceRepository {
+	t.Helper()
+
+	repository := NewInMemoryProductPriceRepository()
+	if now != nil {
+		repository.now = now
+	}
+
+	for _, price := range prices {
+		if err := repository.Put(context.Background(), price); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	return repository
+}
+
+// helperNameRepository names products in a new in-memory repository
+func helperNameRepository(names map[int]string) *InMemoryProductNameRepository {
+	repository := NewInMemoryProductNameRepository()
+	for productID, name := range names {
+		repository.Put(productID, name)
+	}
+
+	return repository
+}
+
+// helperGetETag returns the ETag of a GET of the product
+func helperGetETag(t *testing.T, rh RequestHandler, productID int) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	rh.HandleRequest(w, httptest.NewRequest("GET", fmt.Sprintf("http://example.com/%d", productID), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET product %d: got %d %s", productID, w.Code, w.Body.String())
+	}
+
+	return w.Header().Get("ETag")
+}
+
+func helperDecodeJSON(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want %d", w.Code, w.Body.String(), http.StatusOK)
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
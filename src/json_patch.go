@@ -0,0 +1,469 @@
+package productaggregate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// applyMergePatch applies a JSON Merge Patch (RFC 7396) to a document
+// decoded into interface{} values. The target is changed in place.
+func applyMergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+
+		targetObject[name] = applyMergePatch(targetObject[name], value)
+	}
+
+	return targetObject
+}
+
+// jsonPatchOperation is one operation of a JSON Patch (RFC 6902). Path and
+// From are pointers so that missing members can be told apart from the
+// pointer to the whole document.
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// validate checks that the operation has the members its op needs
+func (o jsonPatchOperation) validate() error {
+	switch o.Op {
+	case "add", "remove", "replace", "move", "copy", "test":
+	case "":
+		return errors.New(`missing "op"`)
+	default:
+		return fmt.Errorf("unknown op %q", o.Op)
+	}
+
+	if o.Path == nil {
+		return errors.New(`missing "path"`)
+	}
+
+	if _, err := parsePointer(*o.Path); err != nil {
+		return err
+	}
+
+	switch o.Op {
+	case "add", "replace", "test":
+		if o.Value == nil {
+			return errors.New(`missing "value"`)
+		}
+
+	case "move", "copy":
+		if o.From == nil {
+			return errors.New(`missing "from"`)
+		}
+
+		if _, err := parsePointer(*o.From); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// errPatchTestFailed is returned when a JSON Patch test operation finds a
+// different value
+var errPatchTestFailed = errors.New("test failed")
+
+// patchError reports the JSON Patch operation which could not be applied
+type patchError struct {
+	index int
+	op    string
+	err   error
+}
+
+func (e *patchError) Error() string {
+	return fmt.Sprintf("JSON Patch operation %d (%s): %s", e.index, e.op, e.err)
+}
+
+func (e *patchError) Unwrap() error {
+	return e.err
+}
+
+// applyJSONPatch applies the operations of a JSON Patch in order, to a
+// document decoded into interface{} values. The operations must have been
+// validated. The document may be changed in place even when an operation
+// fails.
+func applyJSONPatch(doc interface{}, operations []jsonPatchOperation) (interface{}, error) {
+	for i, operation := range operations {
+		var err error
+		doc, err = operation.apply(doc)
+		if err != nil {
+			return nil, &patchError{index: i, op: operation.Op, err: err}
+		}
+	}
+
+	return doc, nil
+}
+
+func (o jsonPatchOperation) apply(doc interface{}) (interface{}, error) {
+	path, _ := parsePointer(*o.Path)
+
+	switch o.Op {
+	case "add":
+		return addValue(doc, path, decodeJSONValue(o.Value))
+
+	case "remove":
+		return removeValue(doc, path)
+
+	case "replace":
+		if _, err := getValue(doc, path); err != nil {
+			return nil, err
+		}
+
+		if len(path) == 0 {
+			return decodeJSONValue(o.Value), nil
+		}
+
+		doc, err := removeValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+
+		return addValue(doc, path, decodeJSONValue(o.Value))
+
+	case "move":
+		from, _ := parsePointer(*o.From)
+		if len(from) == len(path) && hasPrefix(path, from) {
+			return doc, nil
+		}
+
+		if hasPrefix(path, from) {
+			return nil, fmt.Errorf("cannot move %s into one of its children", formatPointer(from))
+		}
+
+		value, err := getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		doc, err = removeValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		return addValue(doc, path, value)
+
+	case "copy":
+		from, _ := parsePointer(*o.From)
+		value, err := getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		return addValue(doc, path, copyJSONValue(value))
+
+	default:
+		value, err := getValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !equalJSONValues(value, decodeJSONValue(o.Value)) {
+			return nil, fmt.Errorf("%s: %w", formatPointer(path), errPatchTestFailed)
+		}
+
+		return doc, nil
+	}
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped
+// reference tokens. The empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must be empty or start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	return tokens, nil
+}
+
+// formatPointer joins reference tokens into a JSON Pointer, for errors
+func formatPointer(tokens []string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteString("/")
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+
+	return b.String()
+}
+
+// arrayIndex reads a reference token as an index into an array of length
+// n. "-", the index after the last element, is only allowed when end is
+// set.
+func arrayIndex(token string, n int, end bool) (int, error) {
+	if token == "-" && end {
+		return n, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || strconv.Itoa(i) != token {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+
+	max := n - 1
+	if end {
+		max = n
+	}
+
+	if i > max {
+		return 0, fmt.Errorf("index %d is out of range", i)
+	}
+
+	return i, nil
+}
+
+// getValue returns the value the tokens refer to
+func getValue(doc interface{}, tokens []string) (interface{}, error) {
+	for i, token := range tokens {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%s: does not exist", formatPointer(tokens[:i+1]))
+			}
+			doc = value
+
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", formatPointer(tokens[:i+1]), err)
+			}
+			doc = node[index]
+
+		default:
+			return nil, fmt.Errorf("%s: does not exist", formatPointer(tokens[:i+1]))
+		}
+	}
+
+	return doc, nil
+}
+
+// updateParent applies f to the container holding the value the tokens
+// refer to, and returns the document with the container f returns in its
+// place
+func updateParent(doc interface{}, tokens []string, f func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	parentTokens, last := tokens[:len(tokens)-1], tokens[len(tokens)-1]
+	parent, err := getValue(doc, parentTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := f(parent, last)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", formatPointer(tokens), err)
+	}
+
+	if len(parentTokens) == 0 {
+		return updated, nil
+	}
+
+	// Arrays may have grown or shrunk, so the container is put back into
+	// its own parent
+	return updateParent(doc, parentTokens, func(grandparent interface{}, token string) (interface{}, error) {
+		switch node := grandparent.(type) {
+		case map[string]interface{}:
+			node[token] = updated
+			return node, nil
+
+		default:
+			list := node.([]interface{})
+			index, _ := arrayIndex(token, len(list), false)
+			list[index] = updated
+			return list, nil
+		}
+	})
+}
+
+// addValue adds a member to an object, replacing any already there, or
+// inserts an element into an array
+func addValue(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	return updateParent(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+
+		default:
+			return nil, errors.New("parent is not an object or array")
+		}
+	})
+}
+
+// removeValue removes a member from an object, or an element from an array
+func removeValue(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+
+	return updateParent(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[token]; !ok {
+				return nil, errors.New("does not exist")
+			}
+
+			delete(node, token)
+			return node, nil
+
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+
+			return append(node[:index], node[index+1:]...), nil
+
+		default:
+			return nil, errors.New("does not exist")
+		}
+	})
+}
+
+// decodeJSONValue decodes a JSON value, keeping numbers as json.Number so
+// that decimals are not rounded through float64. The value must be valid
+// JSON.
+func decodeJSONValue(data []byte) interface{} {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var value interface{}
+	dec.Decode(&value)
+	return value
+}
+
+// copyJSONValue deep copies a decoded JSON value
+func copyJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for name, member := range v {
+			object[name] = copyJSONValue(member)
+		}
+		return object
+
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, element := range v {
+			list[i] = copyJSONValue(element)
+		}
+		return list
+
+	default:
+		return value
+	}
+}
+
+// maxJSONNumberExponent bounds the exponents of numbers compared by value,
+// as big.Rat would otherwise expand 1e999999999 in full
+const maxJSONNumberExponent = 1000
+
+// jsonNumberRat reads a JSON number exactly, unless its exponent is out of
+// bounds
+func jsonNumberRat(n json.Number) (*big.Rat, bool) {
+	if i := strings.IndexAny(string(n), "eE"); i >= 0 {
+		exponent, err := strconv.Atoi(string(n[i+1:]))
+		if err != nil || exponent > maxJSONNumberExponent || exponent < -maxJSONNumberExponent {
+			return nil, false
+		}
+	}
+
+	return new(big.Rat).SetString(string(n))
+}
+
+// equalJSONValues compares decoded JSON values as RFC 6902's test does, so
+// that numbers are equal when their values are, however they are written.
+// Numbers with exponents too large to compare by value are only equal when
+// written the same way.
+func equalJSONValues(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+
+		if a == b {
+			return true
+		}
+
+		x, okX := jsonNumberRat(a)
+		y, okY := jsonNumberRat(b)
+		return okX && okY && x.Cmp(y) == 0
+
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+
+		for name, value := range a {
+			other, ok := b[name]
+			if !ok || !equalJSONValues(value, other) {
+				return false
+			}
+		}
+		return true
+
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+
+		for i := range a {
+			if !equalJSONValues(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,267 @@
+package productaggregate
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// Examples from RFC 7396, appendix A
+var mergePatchTests = []struct {
+	target string
+	patch  string
+	want   string
+}{
+	{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+	{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+	{`{"a":"b"}`, `{"a":null}`, `{}`},
+	{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+	{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+	{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+	{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+	{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+	{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+	{`{"a":"b"}`, `["c"]`, `["c"]`},
+	{`{"a":"foo"}`, `null`, `null`},
+	{`{"a":"foo"}`, `"bar"`, `"bar"`},
+	{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+	{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+	{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	{`{"value":13.490}`, `{"currency_code":"EUR"}`, `{"currency_code":"EUR","value":13.490}`},
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	for _, tt := range mergePatchTests {
+		got := applyMergePatch(decodeJSONValue([]byte(tt.target)), decodeJSONValue([]byte(tt.patch)))
+
+		data, err := json.Marshal(got)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if string(data) != tt.want {
+			t.Errorf("applyMergePatch(%s, %s) = %s, want %s", tt.target, tt.patch, data, tt.want)
+		}
+	}
+}
+
+// Mostly examples from RFC 6902, appendix A
+var jsonPatchTests = []struct {
+	name    string
+	doc     string
+	patch   string
+	want    string
+	wantErr string
+}{
+	{
+		name:  "Add an object member",
+		doc:   `{"foo":"bar"}`,
+		patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+		want:  `{"baz":"qux","foo":"bar"}`,
+	},
+	{
+		name:  "Add an array element",
+		doc:   `{"foo":["bar","baz"]}`,
+		patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+		want:  `{"foo":["bar","qux","baz"]}`,
+	},
+	{
+		name:  "Add to the end of an array",
+		doc:   `{"foo":["bar"]}`,
+		patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+		want:  `{"foo":["bar",["abc","def"]]}`,
+	},
+	{
+		name:  "Remove an object member",
+		doc:   `{"baz":"qux","foo":"bar"}`,
+		patch: `[{"op":"remove","path":"/baz"}]`,
+		want:  `{"foo":"bar"}`,
+	},
+	{
+		name:  "Remove an array element",
+		doc:   `{"foo":["bar","qux","baz"]}`,
+		patch: `[{"op":"remove","path":"/foo/1"}]`,
+		want:  `{"foo":["bar","baz"]}`,
+	},
+	{
+		name:  "Replace a value",
+		doc:   `{"baz":"qux","foo":"bar"}`,
+		patch: `[{"op":"replace","path":"/baz","value":"boo"}]`,
+		want:  `{"baz":"boo","foo":"bar"}`,
+	},
+	{
+		name:  "Move a value",
+		doc:   `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+		patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+		want:  `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+	},
+	{
+		name:  "Move an array element",
+		doc:   `{"foo":["all","grass","cows","eat"]}`,
+		patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+		want:  `{"foo":["all","cows","eat","grass"]}`,
+	},
+	{
+		name:  "Copy a value",
+		doc:   `{"foo":{"bar":1}}`,
+		patch: `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"replace","path":"/baz/bar","value":2}]`,
+		want:  `{"baz":{"bar":2},"foo":{"bar":1}}`,
+	},
+	{
+		name:  "Test passes",
+		doc:   `{"baz":"qux","foo":["a",2,"c"],"price":13.49}`,
+		patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2},{"op":"test","path":"/price","value":13.490}]`,
+		want:  `{"baz":"qux","foo":["a",2,"c"],"price":13.49}`,
+	},
+	{
+		name:    "Test fails",
+		doc:     `{"baz":"qux"}`,
+		patch:   `[{"op":"test","path":"/baz","value":"bar"}]`,
+		wantErr: "JSON Patch operation 0 (test): /baz: test failed",
+	},
+	{
+		name:  "Escaped pointers",
+		doc:   `{"/":9,"~1":10}`,
+		patch: `[{"op":"test","path":"/~01","value":10},{"op":"remove","path":"/~1"}]`,
+		want:  `{"~1":10}`,
+	},
+	{
+		name:  "Replace the whole document",
+		doc:   `{"foo":"bar"}`,
+		patch: `[{"op":"replace","path":"","value":{"baz":"qux"}}]`,
+		want:  `{"baz":"qux"}`,
+	},
+	{
+		name:    "Add to a missing object",
+		doc:     `{"foo":"bar"}`,
+		patch:   `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+		wantErr: "JSON Patch operation 0 (add): /baz: does not exist",
+	},
+	{
+		name:    "Remove a missing member",
+		doc:     `{"foo":"bar"}`,
+		patch:   `[{"op":"remove","path":"/baz"}]`,
+		wantErr: "JSON Patch operation 0 (remove): /baz: does not exist",
+	},
+	{
+		name:    "Replace a missing member",
+		doc:     `{"foo":"bar"}`,
+		patch:   `[{"op":"add","path":"/a","value":1},{"op":"replace","path":"/baz","value":1}]`,
+		wantErr: "JSON Patch operation 1 (replace): /baz: does not exist",
+	},
+	{
+		name:    "Array index out of range",
+		doc:     `{"foo":["bar","baz"]}`,
+		patch:   `[{"op":"add","path":"/foo/3","value":"qux"}]`,
+		wantErr: "JSON Patch operation 0 (add): /foo/3: index 3 is out of range",
+	},
+	{
+		name:    "Array index with a leading zero",
+		doc:     `{"foo":["bar","baz"]}`,
+		patch:   `[{"op":"remove","path":"/foo/01"}]`,
+		wantErr: `JSON Patch operation 0 (remove): /foo/01: "01" is not an array index`,
+	},
+	{
+		name:    "Move into a child",
+		doc:     `{"foo":{"bar":1}}`,
+		patch:   `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`,
+		wantErr: "JSON Patch operation 0 (move): cannot move /foo into one of its children",
+	},
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	for _, tt := range jsonPatchTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var operations []jsonPatchOperation
+			if err := json.Unmarshal([]byte(tt.patch), &operations); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			for _, operation := range operations {
+				if err := operation.validate(); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			got, err := applyJSONPatch(decodeJSONValue([]byte(tt.doc)), operations)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			data, _ := json.Marshal(got)
+			if string(data) != tt.want {
+				t.Errorf("got %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONPatchTestFailureMatches(t *testing.T) {
+	path := "/a"
+	_, err := applyJSONPatch(decodeJSONValue([]byte(`{"a":1}`)), []jsonPatchOperation{{Op: "test", Path: &path, Value: json.RawMessage(`1.5`)}})
+	if !errors.Is(err, errPatchTestFailed) {
+		t.Errorf("got %+v, want %+v", err, errPatchTestFailed)
+	}
+}
+
+var equalJSONValuesTests = []struct {
+	a    string
+	b    string
+	want bool
+}{
+	{`1.0`, `1`, true},
+	{`1e2`, `100`, true},
+	{`13.49`, `13.490`, true},
+	{`1`, `2`, false},
+	{`1e999999999`, `1`, false},
+	{`1e999999999`, `1e999999999`, true},
+	{`1e-999999999`, `0`, false},
+	{`[1, {"a": 2.0}]`, `[1.0, {"a": 2}]`, true},
+}
+
+func TestEqualJSONValues(t *testing.T) {
+	for _, tt := range equalJSONValuesTests {
+		if got := equalJSONValues(decodeJSONValue([]byte(tt.a)), decodeJSONValue([]byte(tt.b))); got != tt.want {
+			t.Errorf("equalJSONValues(%s, %s) = %t, want %t", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+var jsonPatchValidateTests = []struct {
+	operation string
+	want      string
+}{
+	{`{"path":"/a"}`, `missing "op"`},
+	{`{"op":"delete","path":"/a"}`, `unknown op "delete"`},
+	{`{"op":"remove"}`, `missing "path"`},
+	{`{"op":"remove","path":"a"}`, `path "a" must be empty or start with /`},
+	{`{"op":"add","path":"/a"}`, `missing "value"`},
+	{`{"op":"add","path":"/a","value":null}`, ``},
+	{`{"op":"copy","path":"/a"}`, `missing "from"`},
+	{`{"op":"move","path":"/a","from":"/b"}`, ``},
+}
+
+func TestJSONPatchOperationValidate(t *testing.T) {
+	for _, tt := range jsonPatchValidateTests {
+		var operation jsonPatchOperation
+		if err := json.Unmarshal([]byte(tt.operation), &operation); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		got := ""
+		if err := operation.validate(); err != nil {
+			got = err.Error()
+		}
+
+		if got != tt.want {
+			t.Errorf("validate(%s) = %q, want %q", tt.operation, got, tt.want)
+		}
+	}
+}
@@ -3,11 +3,18 @@ package productaggregate
 import (
 	"log"
 	"net/http"
+	"os"
+	"sync"
+)
+
+var (
+	cloudFunctionMu      sync.Mutex
+	cloudFunctionHandler *RequestHandler
 )
 
 // StartCloudFunction starts the product handler in Google Cloud
 func StartCloudFunction(w http.ResponseWriter, r *http.Request) {
-	handler, err := NewRequestHandler()
+	handler, err := cloudFunctionRequestHandler()
 	if err != nil {
 		msg := "Could not process request"
 		http.Error(w, msg, http.StatusInternalServerError)
@@ -17,3 +24,28 @@ func StartCloudFunction(w http.ResponseWriter, r *http.Request) {
 
 	handler.HandleRequest(w, r)
 }
+
+// cloudFunctionRequestHandler builds the handler on first use, and reuses it
+// for as long as the function instance lives so that caches stay warm. A
+// failed initialization is retried on the next request.
+func cloudFunctionRequestHandler() (*RequestHandler, error) {
+	cloudFunctionMu.Lock()
+	defer cloudFunctionMu.Unlock()
+
+	if cloudFunctionHandler != nil {
+		return cloudFunctionHandler, nil
+	}
+
+	config, err := LoadConfig(nil, os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+
+	handler, err := NewRequestHandler(config)
+	if err != nil {
+		return nil, err
+	}
+
+	cloudFunctionHandler = &handler
+	return cloudFunctionHandler, nil
+}
@@ -0,0 +1,41 @@
+package productaggregate
+
+import (
+	"context"
+	"sync"
+)
+
+// InMemoryProductNameRepository serves product names from process memory.
+// It is safe for concurrent use, and is intended for local runs and tests.
+type InMemoryProductNameRepository struct {
+	mu    sync.RWMutex
+	names map[int]string
+}
+
+// NewInMemoryProductNameRepository creates a new, empty InMemoryProductNameRepository
+func NewInMemoryProductNameRepository() *InMemoryProductNameRepository {
+	return &InMemoryProductNameRepository{
+		names: make(map[int]string),
+	}
+}
+
+// Get fetches a product's name by id
+func (m *InMemoryProductNameRepository) Get(ctx context.Context, productID int) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	name, ok := m.names[productID]
+	if !ok {
+		return "", ErrProductNotFound
+	}
+
+	return name, nil
+}
+
+// Put sets a product's name
+func (m *InMemoryProductNameRepository) Put(productID int, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.names[productID] = name
+}
@@ -0,0 +1,31 @@
+package productaggregate
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInMemoryProductNameRepository(t *testing.T) {
+	repository := NewInMemoryProductNameRepository()
+	repository.Put(10, "Picard")
+
+	var tests = []struct {
+		productID int
+		want      string
+		wantErr   error
+	}{
+		{10, "Picard", nil},
+		{11, "", ErrProductNotFound},
+	}
+
+	for _, tt := range tests {
+		name, err := repository.Get(context.Background(), tt.productID)
+		if err != tt.wantErr {
+			t.Errorf("product %d: got error %+v, want %+v", tt.productID, err, tt.wantErr)
+		}
+
+		if name != tt.want {
+			t.Errorf("product %d: got %s, want %s", tt.productID, name, tt.want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package productaggregate
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// InMemoryProductPriceRepository keeps product prices in process memory.
+// It is safe for concurrent use, and is intended for local runs and tests.
+type InMemoryProductPriceRepository struct {
+	now func() time.Time
+
+	mu       sync.RWMutex
+	entities map[int]*priceEntity
+	history  map[int][]PriceChange
+}
+
+// NewInMemoryProductPriceRepository creates a new, empty InMemoryProductPriceRepository
+func NewInMemoryProductPriceRepository() *InMemoryProductPriceRepository {
+	return &InMemoryProductPriceRepository{
+		now:      time.Now,
+		entities: make(map[int]*priceEntity),
+		history:  make(map[int][]PriceChange),
+	}
+}
+
+// Get fetches a product price by id
+func (m *InMemoryProductPriceRepository) Get(ctx context.Context, productID int, query PriceQuery) (*ProductPrice, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entity, ok := m.entities[productID]
+	if !ok {
+		return nil, ErrPriceNotFound
+	}
+
+	at := query.At
+	if at.IsZero() {
+		at = m.now()
+	}
+
+	price, err := entity.price(query.CurrencyCode, at)
+	if err != nil {
+		return nil, err
+	}
+
+	return price, nil
+}
+
+// GetMulti fetches many products' prices. The results are in the same
+// order as productIDs, and each has its own error.
+func (m *InMemoryProductPriceRepository) GetMulti(ctx context.Context, productIDs []int, query PriceQuery) ([]*ProductPrice, []error) {
+	prices := make([]*ProductPrice, len(productIDs))
+	errs := make([]error, len(productIDs))
+	for i, productID := range productIDs {
+		prices[i], errs[i] = m.Get(ctx, productID, query)
+	}
+
+	return prices, errs
+}
+
+// Put sets a product's price in one currency
+func (m *InMemoryProductPriceRepository) Put(ctx context.Context, price ProductPrice) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entity, ok := m.entities[price.ProductID]
+	if !ok {
+		entity = &priceEntity{ProductID: price.ProductID}
+	}
+
+	now := m.now()
+	old, expired, err := entity.setPrice(price, now)
+	if err != nil {
+		return err
+	}
+
+	m.entities[price.ProductID] = entity
+
+	changes := newExpiryChanges(ctx, price.ProductID, expired, now)
+	changes = append(changes, newPriceChange(ctx, old, price, now.Add(time.Duration(len(expired)))))
+	m.history[price.ProductID] = append(m.history[price.ProductID], changes...)
+	return nil
+}
+
+// PutMulti sets many prices, each in one currency. The results are in the
+// same order as prices, and each has its own error.
+func (m *InMemoryProductPriceRepository) PutMulti(ctx context.Context, prices []ProductPrice, options PutMultiOptions) []error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Work on copies, so that an aborted atomic update changes nothing
+	entities := make(map[int]*priceEntity)
+	for _, price := range prices {
+		if entity, ok := m.entities[price.ProductID]; ok {
+			clone := *entity
+			entities[price.ProductID] = &clone
+		}
+	}
+
+	changes, errs := applyPrices(ctx, entities, prices, m.now())
+	if options.Atomic && abortPrices(errs) {
+		return errs
+	}
+
+	for i, price := range prices {
+		if errs[i] == nil {
+			m.entities[price.ProductID] = entities[price.ProductID]
+		}
+	}
+
+	for _, change := range changes {
+		m.history[change.ProductID] = append(m.history[change.ProductID], change)
+	}
+
+	return errs
+}
+
+// Replace swaps one of a product's prices for another
+func (m *InMemoryProductPriceRepository) Replace(ctx context.Context, original ProductPrice, price ProductPrice) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entity, ok := m.entities[price.ProductID]
+	if !ok {
+		return ErrPriceNotFound
+	}
+
+	now := m.now()
+	replaced, expired, err := entity.replacePrice(original, price, now)
+	if err != nil {
+		return err
+	}
+
+	changes := newExpiryChanges(ctx, price.ProductID, expired, now)
+	changes = append(changes, newReplacementChanges(ctx, replaced, price, now.Add(time.Duration(len(expired))))...)
+	m.history[price.ProductID] = append(m.history[price.ProductID], changes...)
+	return nil
+}
+
+// Delete soft deletes a product's prices
+func (m *InMemoryProductPriceRepository) Delete(ctx context.Context, productID int, version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entity, ok := m.entities[productID]
+	if !ok {
+		return ErrPriceNotFound
+	}
+
+	now := m.now()
+	if err := entity.delete(version, ActorFromContext(ctx), now); err != nil {
+		return err
+	}
+
+	m.history[productID] = append(m.history[productID], newDeletionChanges(ctx, entity, PriceChangeDelete, now)...)
+	return nil
+}
+
+// Undelete restores a product's deleted prices
+func (m *InMemoryProductPriceRepository) Undelete(ctx context.Context, productID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entity, ok := m.entities[productID]
+	if !ok {
+		return ErrPriceNotFound
+	}
+
+	now := m.now()
+	if err := entity.undelete(now); err != nil {
+		return err
+	}
+
+	m.history[productID] = append(m.history[productID], newDeletionChanges(ctx, entity, PriceChangeUndelete, now)...)
+	return nil
+}
+
+// List pages through the stored prices. Products are listed in order of
+// their IDs, and page tokens hold the ID of the next product.
+func (m *InMemoryProductPriceRepository) List(ctx context.Context, query PriceListQuery) (PriceListPage, error) {
+	if err := query.validate(); err != nil {
+		return PriceListPage{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var page PriceListPage
+	if len(query.ProductIDs) > 0 {
+		for _, productID := range query.ProductIDs {
+			if entity, ok := m.entities[productID]; ok {
+				page.Prices = append(page.Prices, entity.storedPrices()...)
+			}
+		}
+
+		return page, nil
+	}
+
+	start := math.MinInt64
+	if query.PageToken != "" {
+		position, err := decodePageToken(query.PageToken)
+		if err != nil {
+			return PriceListPage{}, err
+		}
+
+		start, err = strconv.Atoi(position)
+		if err != nil {
+			return PriceListPage{}, ErrInvalidPageToken
+		}
+	}
+
+	productIDs := make([]int, 0, len(m.entities))
+	for productID := range m.entities {
+		if productID >= start {
+			productIDs = append(productIDs, productID)
+		}
+	}
+	sort.Ints(productIDs)
+
+	for i, productID := range productIDs {
+		if i == query.pageSize() {
+			page.NextPageToken = encodePageToken(strconv.Itoa(productID))
+			break
+		}
+
+		page.Prices = append(page.Prices, m.entities[productID].storedPrices()...)
+	}
+
+	return page, nil
+}
+
+// History lists a product's price changes. Page tokens hold the position of
+// the next change in the product's history.
+func (m *InMemoryProductPriceRepository) History(ctx context.Context, productID int, query PriceHistoryQuery) (PriceHistoryPage, error) {
+	start := 0
+	if query.PageToken != "" {
+		position, err := decodePageToken(query.PageToken)
+		if err != nil {
+			return PriceHistoryPage{}, err
+		}
+
+		start, err = strconv.Atoi(position)
+		if err != nil || start < 0 {
+			return PriceHistoryPage{}, ErrInvalidPageToken
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var page PriceHistoryPage
+	history := m.history[productID]
+	for i := start; i < len(history); i++ {
+		if !query.includes(history[i].ChangedAt) {
+			continue
+		}
+
+		if len(page.Changes) == query.pageSize() {
+			page.NextPageToken = encodePageToken(strconv.Itoa(i))
+			break
+		}
+
+		page.Changes = append(page.Changes, history[i])
+	}
+
+	return page, nil
+}
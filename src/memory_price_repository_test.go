@@ -0,0 +1,143 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInMemoryProductPriceRepositoryGetNotFound(t *testing.T) {
+	repository := NewInMemoryProductPriceRepository()
+
+	price, err := repository.Get(context.Background(), 10, PriceQuery{})
+	if price != nil {
+		t.Errorf("expected no price, got %+v", price)
+	}
+
+	if !errors.Is(err, ErrPriceNotFound) {
+		t.Errorf("got error %+v, want %+v", err, ErrPriceNotFound)
+	}
+}
+
+func TestInMemoryProductPriceRepositoryGetCurrencyNotFound(t *testing.T) {
+	repository := NewInMemoryProductPriceRepository()
+	repository.Put(context.Background(), ProductPrice{ProductID: 10, Price: NewMoney(1, 0), CurrencyCode: "USD"})
+
+	_, err := repository.Get(context.Background(), 10, PriceQuery{CurrencyCode: "EUR"})
+	if !errors.Is(err, ErrCurrencyNotFound) || !errors.Is(err, ErrPriceNotFound) {
+		t.Errorf("got error %+v, want %+v", err, ErrCurrencyNotFound)
+	}
+}
+
+var inMemoryPriceRoundTripTests = []struct {
+	name  string
+	in    []ProductPrice
+	query PriceQuery
+	want  ProductPrice
+}{
+	{
+		name: "Single put",
+		in: []ProductPrice{
+			{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+		},
+		want: ProductPrice{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD", Version: 1},
+	},
+	{
+		name: "Later put overwrites earlier put",
+		in: []ProductPrice{
+			{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+			{ProductID: 10, Price: NewMoney(12, 0), CurrencyCode: "USD"},
+		},
+		want: ProductPrice{ProductID: 10, Price: NewMoney(12, 0), CurrencyCode: "USD", Version: 2},
+	},
+	{
+		name: "Base currency is the first put",
+		in: []ProductPrice{
+			{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+			{ProductID: 10, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"},
+		},
+		want: ProductPrice{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD", Version: 2},
+	},
+	{
+		name: "Other currencies are left alone",
+		in: []ProductPrice{
+			{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+			{ProductID: 10, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"},
+			{ProductID: 10, Price: MustParseMoney("13.99"), CurrencyCode: "USD"},
+		},
+		query: PriceQuery{CurrencyCode: "EUR"},
+		want:  ProductPrice{ProductID: 10, Price: MustParseMoney("12.50"), CurrencyCode: "EUR", Version: 3},
+	},
+	{
+		name: "Other products are left alone",
+		in: []ProductPrice{
+			{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+			{ProductID: 11, Price: NewMoney(12, 0), CurrencyCode: "EUR"},
+		},
+		want: ProductPrice{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD", Version: 1},
+	},
+}
+
+func TestInMemoryProductPriceRepositoryRoundTrip(t *testing.T) {
+	for _, tt := range inMemoryPriceRoundTripTests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := NewInMemoryProductPriceRepository()
+			repository.now = func() time.Time { return scheduleStart }
+			for _, price := range tt.in {
+				if err := repository.Put(context.Background(), price); err != nil {
+					t.Fatalf("unexpected error: %+v", err)
+				}
+			}
+
+			got, err := repository.Get(context.Background(), tt.want.ProductID, tt.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			want := tt.want
+			want.UpdatedAt = scheduleStart
+			if *got != want {
+				t.Errorf("got %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestInMemoryProductPriceRepositoryGetReturnsCopy(t *testing.T) {
+	repository := NewInMemoryProductPriceRepository()
+	repository.Put(context.Background(), ProductPrice{ProductID: 10, Price: NewMoney(1, 0), CurrencyCode: "USD"})
+
+	got, _ := repository.Get(context.Background(), 10, PriceQuery{})
+	got.Price = NewMoney(2, 0)
+
+	again, _ := repository.Get(context.Background(), 10, PriceQuery{})
+	if again.Price != NewMoney(1, 0) {
+		t.Errorf("stored price was modified through a returned value: %+v", again)
+	}
+}
+
+func TestInMemoryProductPriceRepositoryConcurrent(t *testing.T) {
+	repository := NewInMemoryProductPriceRepository()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(productID int) {
+			defer wg.Done()
+			repository.Put(context.Background(), ProductPrice{ProductID: productID, Price: NewMoney(1, 0), CurrencyCode: "USD"})
+		}(i)
+		go func(productID int) {
+			defer wg.Done()
+			repository.Get(context.Background(), productID, PriceQuery{})
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 50; i++ {
+		if _, err := repository.Get(context.Background(), i, PriceQuery{}); err != nil {
+			t.Errorf("product %d: unexpected error: %+v", i, err)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package productaggregate
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// maxMoneyExponent is the most decimal places a Money can hold
+const maxMoneyExponent = 18
+
+// maxMoneyDigits is the most significant digits a Money can hold, as its
+// units are an int64
+const maxMoneyDigits = 19
+
+// Money is an exact decimal amount: units divided by 10 to the power of
+// exponent. Unlike a float64, it encodes to JSON exactly as it was decoded,
+// so 13.49 never becomes 13.490000001.
+//
+// The zero value is an amount of 0.
+type Money struct {
+	units    int64
+	exponent int
+}
+
+// MoneyError is returned when an amount cannot be parsed or represented
+type MoneyError struct {
+	Value  string
+	Reason string
+}
+
+func (e *MoneyError) Error() string {
+	return fmt.Sprintf("invalid amount %s: %s", e.Value, e.Reason)
+}
+
+// NewMoney creates an amount of units / 10^exponent. For example,
+// NewMoney(1349, 2) is 13.49. It panics if exponent is out of range.
+func NewMoney(units int64, exponent int) Money {
+	if exponent < 0 || exponent > maxMoneyExponent {
+		panic(fmt.Sprintf("productaggregate: money exponent %d out of range", exponent))
+	}
+
+	return Money{
+		units:    units,
+		exponent: exponent,
+	}
+}
+
+// ParseMoney parses a decimal amount such as "13.49", "-2", or "1.5e1". The
+// number of decimal places is kept, so "1.50" stays distinct from "1.5" when
+// printed, although the two are equal.
+func ParseMoney(s string) (Money, error) {
+	invalid := func(reason string) (Money, error) {
+		return Money{}, &MoneyError{Value: s, Reason: reason}
+	}
+
+	mantissa := s
+	shift := 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return invalid("malformed exponent")
+		}
+
+		// Any more would leave too many decimal places, however many
+		// digits the mantissa has
+		if e < -maxMoneyExponent {
+			return invalid(fmt.Sprintf("more than %d decimal places", maxMoneyExponent))
+		}
+
+		mantissa = s[:i]
+		shift = e
+	}
+
+	negative := strings.HasPrefix(mantissa, "-")
+	mantissa = strings.TrimPrefix(mantissa, "-")
+
+	whole, fraction := mantissa, ""
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		whole, fraction = mantissa[:i], mantissa[i+1:]
+		if fraction == "" {
+			return invalid("no digits after the decimal point")
+		}
+	}
+
+	if whole == "" {
+		return invalid("no digits before the decimal point")
+	}
+
+	digits := whole + fraction
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return invalid("not a decimal number")
+		}
+	}
+
+	// The digits are only padded with zeros once they are known to fit, so
+	// that an amount such as 1e10000000 is not expanded
+	exponent := len(fraction) - shift
+	if exponent < 0 {
+		significant := len(strings.TrimLeft(digits, "0"))
+		if significant > 0 && -exponent > maxMoneyDigits-significant {
+			return invalid("out of range")
+		}
+
+		if significant > 0 {
+			digits += strings.Repeat("0", -exponent)
+		}
+		exponent = 0
+	}
+
+	if exponent > maxMoneyExponent {
+		return invalid(fmt.Sprintf("more than %d decimal places", maxMoneyExponent))
+	}
+
+	var units big.Int
+	units.SetString(digits, 10)
+	if negative {
+		units.Neg(&units)
+	}
+
+	if !units.IsInt64() {
+		return invalid("out of range")
+	}
+
+	return Money{units: units.Int64(), exponent: exponent}, nil
+}
+
+// MustParseMoney is like ParseMoney but panics if s cannot be parsed. It is
+// intended for constants.
+func MustParseMoney(s string) Money {
+	m, err := ParseMoney(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+// String formats the amount with all of its decimal places
+func (m Money) String() string {
+	s := strconv.FormatInt(m.units, 10)
+	if m.exponent == 0 {
+		return s
+	}
+
+	sign := ""
+	if m.units < 0 {
+		sign, s = "-", s[1:]
+	}
+
+	if len(s) <= m.exponent {
+		s = strings.Repeat("0", m.exponent-len(s)+1) + s
+	}
+
+	point := len(s) - m.exponent
+	return sign + s[:point] + "." + s[point:]
+}
+
+// Exponent is the number of decimal places the amount is kept at
+func (m Money) Exponent() int {
+	return m.exponent
+}
+
+// Units is the amount multiplied by 10^Exponent
+func (m Money) Units() int64 {
+	return m.units
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of the amount
+func (m Money) Sign() int {
+	switch {
+	case m.units < 0:
+		return -1
+	case m.units > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Rat returns the amount as a rational number
+func (m Money) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(m.units), pow10(m.exponent))
+}
+
+// Cmp compares two amounts, returning -1, 0 or +1
+func (m Money) Cmp(other Money) int {
+	return m.Rat().Cmp(other.Rat())
+}
+
+// Equal reports whether two amounts are the same, whatever their exponents
+func (m Money) Equal(other Money) bool {
+	return m.Cmp(other) == 0
+}
+
+// Add returns m + other, kept at the larger of their exponents
+func (m Money) Add(other Money) (Money, error) {
+	sum := new(big.Rat).Add(m.Rat(), other.Rat())
+	return moneyFromRat(sum, maxInt(m.exponent, other.exponent))
+}
+
+// Sub returns m - other, kept at the larger of their exponents
+func (m Money) Sub(other Money) (Money, error) {
+	difference := new(big.Rat).Sub(m.Rat(), other.Rat())
+	return moneyFromRat(difference, maxInt(m.exponent, other.exponent))
+}
+
+// Mul returns m * factor, rounded half away from zero to exponent decimal
+// places
+func (m Money) Mul(factor *big.Rat, exponent int) (Money, error) {
+	product := new(big.Rat).Mul(m.Rat(), factor)
+	return moneyFromRat(product, exponent)
+}
+
+// Round returns the amount rounded half away from zero to exponent decimal
+// places. Rounding to more decimal places than the amount has pads it.
+func (m Money) Round(exponent int) (Money, error) {
+	return moneyFromRat(m.Rat(), exponent)
+}
+
+// IsExactAt reports whether the amount can be held at exponent decimal
+// places without rounding
+func (m Money) IsExactAt(exponent int) bool {
+	rounded, err := m.Round(exponent)
+	return err == nil && rounded.Equal(m)
+}
+
+// CurrencyExponent is the number of decimal places of a currency's minor
+// unit. Unknown currencies are assumed to have cents.
+func CurrencyExponent(currencyCode string) int {
+	if currency, ok := LookupCurrency(currencyCode); ok {
+		return currency.MinorUnits
+	}
+
+	return 2
+}
+
+// RoundTo returns the amount rounded half away from zero to the minor unit
+// of the currency
+func (m Money) RoundTo(currencyCode string) (Money, error) {
+	return m.Round(CurrencyExponent(currencyCode))
+}
+
+// moneyFromRat rounds r half away from zero to exponent decimal places
+func moneyFromRat(r *big.Rat, exponent int) (Money, error) {
+	if exponent < 0 || exponent > maxMoneyExponent {
+		return Money{}, &MoneyError{Value: r.RatString(), Reason: fmt.Sprintf("exponent %d out of range", exponent)}
+	}
+
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(pow10(exponent)))
+
+	// Round half away from zero: truncate |scaled| + 1/2
+	num := new(big.Int).Abs(scaled.Num())
+	denom := scaled.Denom()
+	num.Mul(num, big.NewInt(2))
+	num.Add(num, denom)
+	denom = new(big.Int).Mul(denom, big.NewInt(2))
+	units := num.Quo(num, denom)
+	if scaled.Sign() < 0 {
+		units.Neg(units)
+	}
+
+	if !units.IsInt64() {
+		return Money{}, &MoneyError{Value: r.FloatString(exponent), Reason: "out of range"}
+	}
+
+	return Money{units: units.Int64(), exponent: exponent}, nil
+}
+
+// moneyFromFloat converts a float64 to the shortest decimal which reads back
+// as the same float64, so 13.49 becomes exactly 13.49
+func moneyFromFloat(f float64) (Money, error) {
+	return ParseMoney(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// MarshalJSON encodes the amount as a JSON number with all of its decimal
+// places
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON decodes a JSON number, or a string holding one, without
+// going through float64
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return &MoneyError{Value: s, Reason: "malformed string"}
+		}
+		s = unquoted
+	}
+
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
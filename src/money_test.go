@@ -0,0 +1,202 @@
+package productaggregate
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+var parseMoneyTests = []struct {
+	in          string
+	out         string
+	expectError bool
+}{
+	{"13.49", "13.49", false},
+	{"100", "100", false},
+	{"1.50", "1.50", false},
+	{"-2.5", "-2.5", false},
+	{"0.05", "0.05", false},
+	{"-0.05", "-0.05", false},
+	{"13.490000001", "13.490000001", false},
+	{"1.5e1", "15", false},
+	{"15E-1", "1.5", false},
+	{"9223372036854775807", "9223372036854775807", false},
+	{"9223372036854775808", "", true},
+	{"0.0000000000000000001", "", true},
+	{"1e18", "1000000000000000000", false},
+	{"0.01e20", "1000000000000000000", false},
+	{"1e19", "", true},
+	{"0e10000000", "0", false},
+	{"1e10000000", "", true},
+	{"1e-10000000", "", true},
+	{"1e-9223372036854775808", "", true},
+	{"", "", true},
+	{"abc", "", true},
+	{"1.", "", true},
+	{".5", "", true},
+	{"1.2.3", "", true},
+	{"1e", "", true},
+	{"NaN", "", true},
+	{"+1", "", true},
+}
+
+func TestParseMoney(t *testing.T) {
+	for _, tt := range parseMoneyTests {
+		t.Run(tt.in, func(t *testing.T) {
+			m, err := ParseMoney(tt.in)
+
+			haveError := err != nil
+			if haveError && !tt.expectError {
+				t.Errorf("received unexpected error: %s", err)
+			}
+
+			if !haveError && tt.expectError {
+				t.Errorf("expected error. did not receive error")
+			}
+
+			if !haveError && m.String() != tt.out {
+				t.Errorf("got %s, want %s", m.String(), tt.out)
+			}
+		})
+	}
+}
+
+func TestMoneyJSONRoundTrip(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{`{"value":13.49}`, `{"value":13.49}`},
+		{`{"value":13.490000001}`, `{"value":13.490000001}`},
+		{`{"value":"19.99"}`, `{"value":19.99}`},
+		{`{"value":0.1}`, `{"value":0.1}`},
+	}
+
+	for _, tt := range tests {
+		var v struct {
+			Value Money `json:"value"`
+		}
+
+		if err := json.Unmarshal([]byte(tt.in), &v); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.in, err)
+		}
+
+		out, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.in, err)
+		}
+
+		if string(out) != tt.out {
+			t.Errorf("got %s, want %s", out, tt.out)
+		}
+	}
+}
+
+func TestMoneyUnmarshalJSONInvalid(t *testing.T) {
+	for _, in := range []string{`"abc"`, `true`, `{}`, `"1e"`} {
+		var m Money
+
+		var moneyErr *MoneyError
+		if err := json.Unmarshal([]byte(in), &m); !errors.As(err, &moneyErr) {
+			t.Errorf("%s: got error %+v, want a *MoneyError", in, err)
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	a := MustParseMoney("13.49")
+	b := MustParseMoney("0.011")
+
+	sum, err := a.Add(b)
+	if err != nil || sum.String() != "13.501" {
+		t.Errorf("13.49 + 0.011: got %s %+v, want 13.501", sum, err)
+	}
+
+	difference, err := a.Sub(b)
+	if err != nil || difference.String() != "13.479" {
+		t.Errorf("13.49 - 0.011: got %s %+v, want 13.479", difference, err)
+	}
+
+	// Floats get this wrong: 0.1 + 0.2 != 0.3
+	tenth := MustParseMoney("0.1")
+	sum, _ = tenth.Add(MustParseMoney("0.2"))
+	if !sum.Equal(MustParseMoney("0.3")) {
+		t.Errorf("0.1 + 0.2: got %s, want 0.3", sum)
+	}
+
+	discounted, err := a.Mul(big.NewRat(9, 10), 2)
+	if err != nil || discounted.String() != "12.14" {
+		t.Errorf("13.49 * 0.9: got %s %+v, want 12.14", discounted, err)
+	}
+}
+
+func TestMoneyRound(t *testing.T) {
+	var tests = []struct {
+		in       string
+		exponent int
+		out      string
+	}{
+		{"1.005", 2, "1.01"},
+		{"1.004", 2, "1.00"},
+		{"-1.005", 2, "-1.01"},
+		{"2.5", 0, "3"},
+		{"-2.5", 0, "-3"},
+		{"2", 2, "2.00"},
+	}
+
+	for _, tt := range tests {
+		rounded, err := MustParseMoney(tt.in).Round(tt.exponent)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.in, err)
+		}
+
+		if rounded.String() != tt.out {
+			t.Errorf("%s rounded to %d: got %s, want %s", tt.in, tt.exponent, rounded, tt.out)
+		}
+	}
+}
+
+func TestMoneyCmp(t *testing.T) {
+	if MustParseMoney("1.50").Cmp(MustParseMoney("1.5")) != 0 {
+		t.Error("1.50 should equal 1.5")
+	}
+
+	if MustParseMoney("-1").Cmp(MustParseMoney("0.01")) != -1 {
+		t.Error("-1 should be less than 0.01")
+	}
+
+	if MustParseMoney("-0.01").Sign() != -1 || (Money{}).Sign() != 0 {
+		t.Error("unexpected sign")
+	}
+}
+
+func TestMoneyRoundTo(t *testing.T) {
+	var tests = []struct {
+		in       string
+		currency string
+		out      string
+	}{
+		{"13.4900001", "USD", "13.49"},
+		{"1000.5", "JPY", "1001"},
+		{"1.2345", "kwd", "1.235"},
+	}
+
+	for _, tt := range tests {
+		rounded, err := MustParseMoney(tt.in).RoundTo(tt.currency)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.in, err)
+		}
+
+		if rounded.String() != tt.out {
+			t.Errorf("%s in %s: got %s, want %s", tt.in, tt.currency, rounded, tt.out)
+		}
+	}
+}
+
+func TestMoneyFromFloat(t *testing.T) {
+	m, err := moneyFromFloat(13.49)
+	if err != nil || m.String() != "13.49" {
+		t.Errorf("got %s %+v, want 13.49", m, err)
+	}
+}
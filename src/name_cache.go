@@ -0,0 +1,248 @@
+package productaggregate
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NameCacheOptions controls a CachingProductNameRepository
+type NameCacheOptions struct {
+	// Size is the maximum number of products kept in the cache
+	Size int
+
+	// TTL is how long a fetched name is served without asking upstream
+	TTL time.Duration
+
+	// NegativeTTL is how long an unknown product is remembered as unknown
+	NegativeTTL time.Duration
+
+	// StaleWhileRevalidate is how long past its TTL an entry may still be
+	// served while it is refreshed in the background
+	StaleWhileRevalidate time.Duration
+
+	// FetchTimeout bounds each upstream fetch, including background
+	// refreshes. Zero means defaultNameFetchTimeout.
+	FetchTimeout time.Duration
+}
+
+const defaultNameFetchTimeout = 10 * time.Second
+
+// NameCacheStats counts cache lookups. Stale entries served while they are
+// refreshed count as hits.
+type NameCacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// CachingProductNameRepository wraps another ProductNameRepository with a
+// bounded LRU cache. It is safe for concurrent use.
+type CachingProductNameRepository struct {
+	// Accessed atomically, and kept first for 64-bit alignment on 32-bit
+	// platforms
+	hits   uint64
+	misses uint64
+
+	next    ProductNameRepository
+	options NameCacheOptions
+	now     func() time.Time
+
+	mu       sync.Mutex
+	lru      *list.List
+	entries  map[int]*list.Element
+	inFlight map[int]*nameFetch
+
+	// refreshes tracks upstream fetches, so tests can wait for them
+	refreshes sync.WaitGroup
+}
+
+// nameFetch is an upstream fetch shared by every caller asking for the same
+// product while it runs
+type nameFetch struct {
+	done chan struct{}
+	name string
+	err  error
+}
+
+type nameCacheEntry struct {
+	productID int
+	name      string
+	notFound  bool
+	fetchedAt time.Time
+}
+
+// NewCachingProductNameRepository creates a new CachingProductNameRepository
+// in front of next
+func NewCachingProductNameRepository(next ProductNameRepository, options NameCacheOptions) *CachingProductNameRepository {
+	return &CachingProductNameRepository{
+		next:     next,
+		options:  options,
+		now:      time.Now,
+		lru:      list.New(),
+		entries:  make(map[int]*list.Element),
+		inFlight: make(map[int]*nameFetch),
+	}
+}
+
+// Stats returns the number of cache hits and misses so far
+func (c *CachingProductNameRepository) Stats() NameCacheStats {
+	return NameCacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+}
+
+// Get fetches a product's name by id, from the cache when possible
+func (c *CachingProductNameRepository) Get(ctx context.Context, productID int) (string, error) {
+	name, _, err := c.GetWithAge(ctx, productID)
+	return name, err
+}
+
+// GetWithAge is like Get, but also says how long ago the name was fetched
+// from upstream. The age is zero when the name was not served from the cache.
+func (c *CachingProductNameRepository) GetWithAge(ctx context.Context, productID int) (string, time.Duration, error) {
+	if entry, ok := c.lookup(productID); ok {
+		atomic.AddUint64(&c.hits, 1)
+
+		age := c.now().Sub(entry.fetchedAt)
+		if entry.notFound {
+			return "", age, ErrProductNotFound
+		}
+		return entry.name, age, nil
+	}
+
+	atomic.AddUint64(&c.misses, 1)
+	name, err := c.fetch(ctx, productID)
+	return name, 0, err
+}
+
+// lookup returns a cached name, and starts a background refresh when the
+// entry is stale
+func (c *CachingProductNameRepository) lookup(productID int) (nameCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[productID]
+	if !ok {
+		return nameCacheEntry{}, false
+	}
+
+	entry := element.Value.(*nameCacheEntry)
+	ttl := c.options.TTL
+	if entry.notFound {
+		ttl = c.options.NegativeTTL
+	}
+
+	age := c.now().Sub(entry.fetchedAt)
+	switch {
+	case age < ttl:
+
+	case age < ttl+c.options.StaleWhileRevalidate:
+		c.startFetch(productID)
+
+	default:
+		c.lru.Remove(element)
+		delete(c.entries, productID)
+		return nameCacheEntry{}, false
+	}
+
+	c.lru.MoveToFront(element)
+	return *entry, true
+}
+
+// fetch waits for an upstream fetch of productID, joining one already in
+// flight if there is one
+func (c *CachingProductNameRepository) fetch(ctx context.Context, productID int) (string, error) {
+	c.mu.Lock()
+	f := c.startFetch(productID)
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.name, f.err
+
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// startFetch returns the fetch in flight for productID, starting one if there
+// is none. The fetch does not share any caller's context, since it serves
+// every caller waiting on it and may be a background refresh; it is bounded
+// by FetchTimeout instead. c.mu must be held.
+func (c *CachingProductNameRepository) startFetch(productID int) *nameFetch {
+	if f, ok := c.inFlight[productID]; ok {
+		return f
+	}
+
+	f := &nameFetch{done: make(chan struct{})}
+	c.inFlight[productID] = f
+	c.refreshes.Add(1)
+
+	go func() {
+		defer c.refreshes.Done()
+
+		timeout := c.options.FetchTimeout
+		if timeout == 0 {
+			timeout = defaultNameFetchTimeout
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		f.name, f.err = c.load(ctx, productID)
+
+		c.mu.Lock()
+		delete(c.inFlight, productID)
+		c.mu.Unlock()
+		close(f.done)
+	}()
+
+	return f
+}
+
+// load fetches a name from upstream and caches the result. On failure any
+// stale entry is kept, and served until it expires.
+func (c *CachingProductNameRepository) load(ctx context.Context, productID int) (string, error) {
+	name, err := c.next.Get(ctx, productID)
+	if errors.Is(err, ErrProductNotFound) {
+		c.store(productID, "", true)
+		return "", err
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	c.store(productID, name, false)
+	return name, nil
+}
+
+func (c *CachingProductNameRepository) store(productID int, name string, notFound bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &nameCacheEntry{
+		productID: productID,
+		name:      name,
+		notFound:  notFound,
+		fetchedAt: c.now(),
+	}
+
+	if element, ok := c.entries[productID]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[productID] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.options.Size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*nameCacheEntry).productID)
+	}
+}
@@ -0,0 +1,318 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingNameRepository serves names from a map and counts upstream calls.
+// When block is set, each call waits for it to be closed first.
+type countingNameRepository struct {
+	mu    sync.Mutex
+	names map[int]string
+	err   error
+	calls int
+	block chan struct{}
+}
+
+func (c *countingNameRepository) Get(ctx context.Context, productID int) (string, error) {
+	c.mu.Lock()
+	c.calls++
+	block := c.block
+	c.mu.Unlock()
+
+	if block != nil {
+		select {
+		case <-block:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return "", c.err
+	}
+
+	name, ok := c.names[productID]
+	if !ok {
+		return "", ErrProductNotFound
+	}
+
+	return name, nil
+}
+
+func (c *countingNameRepository) set(productID int, name string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.names[productID] = name
+	c.err = err
+}
+
+func (c *countingNameRepository) callCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.calls
+}
+
+var testNameCacheOptions = NameCacheOptions{
+	Size:                 2,
+	TTL:                  time.Minute,
+	NegativeTTL:          10 * time.Second,
+	StaleWhileRevalidate: time.Minute,
+}
+
+func helperNameCache(names map[int]string) (*CachingProductNameRepository, *countingNameRepository, *fakeClock) {
+	upstream := &countingNameRepository{names: names}
+	clock := &fakeClock{now: time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)}
+
+	cache := NewCachingProductNameRepository(upstream, testNameCacheOptions)
+	cache.now = clock.Now
+
+	return cache, upstream, clock
+}
+
+func helperGetName(t *testing.T, cache *CachingProductNameRepository, productID int, want string) {
+	t.Helper()
+
+	name, err := cache.Get(context.Background(), productID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if name != want {
+		t.Errorf("got %s, want %s", name, want)
+	}
+}
+
+func TestNameCacheHit(t *testing.T) {
+	cache, upstream, clock := helperNameCache(map[int]string{1: "Picard"})
+
+	helperGetName(t, cache, 1, "Picard")
+	clock.Advance(30 * time.Second)
+	helperGetName(t, cache, 1, "Picard")
+
+	if upstream.callCount() != 1 {
+		t.Errorf("got %d upstream calls, want 1", upstream.callCount())
+	}
+
+	want := NameCacheStats{Hits: 1, Misses: 1}
+	if cache.Stats() != want {
+		t.Errorf("got %+v, want %+v", cache.Stats(), want)
+	}
+}
+
+func TestNameCacheStaleWhileRevalidate(t *testing.T) {
+	cache, upstream, clock := helperNameCache(map[int]string{1: "Picard"})
+
+	helperGetName(t, cache, 1, "Picard")
+	upstream.set(1, "Jean-Luc Picard", nil)
+	clock.Advance(90 * time.Second)
+
+	// The stale name is served while the refresh runs
+	helperGetName(t, cache, 1, "Picard")
+	cache.refreshes.Wait()
+
+	helperGetName(t, cache, 1, "Jean-Luc Picard")
+
+	if upstream.callCount() != 2 {
+		t.Errorf("got %d upstream calls, want 2", upstream.callCount())
+	}
+}
+
+func TestNameCacheStaleKeptWhenRefreshFails(t *testing.T) {
+	cache, upstream, clock := helperNameCache(map[int]string{1: "Picard"})
+
+	helperGetName(t, cache, 1, "Picard")
+	upstream.set(1, "", errors.New("RedSky is down"))
+	clock.Advance(90 * time.Second)
+
+	helperGetName(t, cache, 1, "Picard")
+	cache.refreshes.Wait()
+	helperGetName(t, cache, 1, "Picard")
+
+	// Past the stale window, the failure reaches the caller
+	clock.Advance(time.Minute)
+	if _, err := cache.Get(context.Background(), 1); err == nil {
+		t.Error("expected error. none found")
+	}
+}
+
+func TestNameCacheMissesShareFetch(t *testing.T) {
+	cache, upstream, _ := helperNameCache(map[int]string{1: "Picard"})
+	upstream.block = make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			helperGetName(t, cache, 1, "Picard")
+		}()
+	}
+
+	for cache.Stats().Misses < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	close(upstream.block)
+	wg.Wait()
+
+	if upstream.callCount() != 1 {
+		t.Errorf("got %d upstream calls, want 1", upstream.callCount())
+	}
+}
+
+func TestNameCacheRefreshSharesFetch(t *testing.T) {
+	cache, upstream, clock := helperNameCache(map[int]string{1: "Picard"})
+
+	helperGetName(t, cache, 1, "Picard")
+	upstream.block = make(chan struct{})
+	clock.Advance(90 * time.Second)
+
+	// Stale lookups while the refresh runs do not start another one
+	for i := 0; i < 3; i++ {
+		helperGetName(t, cache, 1, "Picard")
+	}
+	close(upstream.block)
+	cache.refreshes.Wait()
+
+	if upstream.callCount() != 2 {
+		t.Errorf("got %d upstream calls, want 2", upstream.callCount())
+	}
+}
+
+func TestNameCacheFetchTimeout(t *testing.T) {
+	cache, upstream, clock := helperNameCache(map[int]string{1: "Picard"})
+	cache.options.FetchTimeout = 10 * time.Millisecond
+
+	helperGetName(t, cache, 1, "Picard")
+	upstream.block = make(chan struct{})
+	defer close(upstream.block)
+	clock.Advance(90 * time.Second)
+
+	// The background refresh gives up instead of running forever
+	helperGetName(t, cache, 1, "Picard")
+	cache.refreshes.Wait()
+
+	cache.mu.Lock()
+	inFlight := len(cache.inFlight)
+	cache.mu.Unlock()
+	if inFlight != 0 {
+		t.Errorf("got %d fetches in flight, want 0", inFlight)
+	}
+}
+
+func TestNameCacheCallerContext(t *testing.T) {
+	cache, upstream, _ := helperNameCache(map[int]string{1: "Picard"})
+	upstream.block = make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A caller which gives up does not fail the fetch for everyone else
+	if _, err := cache.Get(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %+v, want %+v", err, context.Canceled)
+	}
+
+	close(upstream.block)
+	helperGetName(t, cache, 1, "Picard")
+
+	if upstream.callCount() != 1 {
+		t.Errorf("got %d upstream calls, want 1", upstream.callCount())
+	}
+}
+
+func TestNameCacheExpiry(t *testing.T) {
+	cache, upstream, clock := helperNameCache(map[int]string{1: "Picard"})
+
+	helperGetName(t, cache, 1, "Picard")
+	clock.Advance(2*time.Minute + time.Second)
+	helperGetName(t, cache, 1, "Picard")
+
+	if upstream.callCount() != 2 {
+		t.Errorf("got %d upstream calls, want 2", upstream.callCount())
+	}
+
+	want := NameCacheStats{Hits: 0, Misses: 2}
+	if cache.Stats() != want {
+		t.Errorf("got %+v, want %+v", cache.Stats(), want)
+	}
+}
+
+func TestNameCacheNegative(t *testing.T) {
+	cache, upstream, clock := helperNameCache(map[int]string{})
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Get(context.Background(), 1); !errors.Is(err, ErrProductNotFound) {
+			t.Errorf("got error %+v, want %+v", err, ErrProductNotFound)
+		}
+	}
+
+	if upstream.callCount() != 1 {
+		t.Errorf("got %d upstream calls, want 1", upstream.callCount())
+	}
+
+	// Unknown products use the shorter negative TTL
+	upstream.set(1, "Picard", nil)
+	clock.Advance(80 * time.Second)
+	helperGetName(t, cache, 1, "Picard")
+}
+
+func TestNameCacheErrorsNotCached(t *testing.T) {
+	cache, upstream, _ := helperNameCache(map[int]string{})
+	upstream.set(1, "", errors.New("RedSky is down"))
+
+	if _, err := cache.Get(context.Background(), 1); err == nil {
+		t.Error("expected error. none found")
+	}
+
+	upstream.set(1, "Picard", nil)
+	helperGetName(t, cache, 1, "Picard")
+}
+
+func TestNameCacheEviction(t *testing.T) {
+	cache, upstream, _ := helperNameCache(map[int]string{1: "Picard", 2: "Riker", 3: "Data"})
+
+	helperGetName(t, cache, 1, "Picard")
+	helperGetName(t, cache, 2, "Riker")
+	helperGetName(t, cache, 1, "Picard")
+
+	// 2 is now least recently used, and is evicted to make room for 3
+	helperGetName(t, cache, 3, "Data")
+	helperGetName(t, cache, 1, "Picard")
+	helperGetName(t, cache, 2, "Riker")
+
+	if upstream.callCount() != 4 {
+		t.Errorf("got %d upstream calls, want 4", upstream.callCount())
+	}
+}
+
+func TestNameCacheConcurrent(t *testing.T) {
+	cache, _, clock := helperNameCache(map[int]string{1: "Picard", 2: "Riker", 3: "Data"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cache.Get(context.Background(), i%3+1)
+			if i%10 == 0 {
+				clock.Advance(30 * time.Second)
+			}
+		}(i)
+	}
+	wg.Wait()
+	cache.refreshes.Wait()
+
+	stats := cache.Stats()
+	if stats.Hits+stats.Misses != 100 {
+		t.Errorf("got %+v, want 100 lookups", stats)
+	}
+}
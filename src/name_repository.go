@@ -1,18 +1,70 @@
 package productaggregate
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 // ProductNameRepository handles product names
 type ProductNameRepository interface {
-	Get(productID int) (string, error)
+	Get(ctx context.Context, productID int) (string, error)
+}
+
+var (
+	// ErrProductNotFound is returned when the upstream does not know the product
+	ErrProductNotFound = errors.New("product not found")
+
+	// ErrUpstreamUnavailable is returned when the upstream answers with a
+	// server error
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+
+	// ErrUpstreamRateLimited is returned when the upstream asks us to slow
+	// down. The error is always a *RateLimitedError.
+	ErrUpstreamRateLimited = errors.New("upstream rate limited")
+)
+
+// UpstreamStatusError is returned when the upstream answers with an
+// unexpected HTTP status. It matches ErrUpstreamUnavailable for 5xx statuses.
+type UpstreamStatusError struct {
+	StatusCode int
+}
+
+func (e *UpstreamStatusError) Error() string {
+	return fmt.Sprintf("upstream responded with status %d", e.StatusCode)
+}
+
+// Is reports whether the status means the upstream is unavailable
+func (e *UpstreamStatusError) Is(target error) bool {
+	return target == ErrUpstreamUnavailable && e.StatusCode >= 500
+}
+
+// RateLimitedError is returned when the upstream rate limits us. RetryAfter
+// is zero when the upstream did not say when to retry.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	if e.RetryAfter == 0 {
+		return ErrUpstreamRateLimited.Error()
+	}
+
+	return fmt.Sprintf("%s, retry after %s", ErrUpstreamRateLimited, e.RetryAfter)
+}
+
+// Is makes errors.Is(err, ErrUpstreamRateLimited) match
+func (e *RateLimitedError) Is(target error) bool {
+	return target == ErrUpstreamRateLimited
 }
 
 type targetResponse struct {
@@ -28,16 +80,56 @@ type targetResponse struct {
 // TargetProductNameRepository handles product name fetching from Target's API
 type TargetProductNameRepository struct {
 	httpClient *http.Client
+	baseURL    string
+	backoff    *retryAfterBackoff
+}
+
+// retryAfterBackoff remembers the most recent Retry-After from the upstream,
+// so that we stop calling it until then
+type retryAfterBackoff struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+func (b *retryAfterBackoff) wait(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.Before(b.until) {
+		return b.until.Sub(now)
+	}
+
+	return 0
+}
+
+func (b *retryAfterBackoff) set(until time.Time) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until.After(b.until) {
+		b.until = until
+	}
 }
 
 // NewTargetProductNameRepository creates a new TargetProductNameRepository
-func NewTargetProductNameRepository() TargetProductNameRepository {
+// which calls the RedSky API at baseURL
+func NewTargetProductNameRepository(baseURL string, timeout time.Duration) TargetProductNameRepository {
 	return TargetProductNameRepository{
-		httpClient: getClient(),
+		httpClient: getClient(timeout),
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		backoff:    &retryAfterBackoff{},
 	}
 }
 
-func getClient() *http.Client {
+func getClient(timeout time.Duration) *http.Client {
 	// See: https://medium.com/@nate510/don-t-use-go-s-default-http-client-4804cb19f779
 	var netTransport = &http.Transport{
 		Dial: (&net.Dialer{
@@ -47,30 +139,84 @@ func getClient() *http.Client {
 	}
 
 	return &http.Client{
-		Timeout:   time.Second * 10,
+		Timeout:   timeout,
 		Transport: netTransport,
 	}
 }
 
-const redskyAPI = "https://redsky.target.com/v2/pdp/tcin/%d?excludes=taxonomy,price,promotion,bulk_ship,rating_and_review_reviews,rating_and_review_statistics,question_answer_statistics"
+const redskyPath = "/v2/pdp/tcin/%d?excludes=taxonomy,price,promotion,bulk_ship,rating_and_review_reviews,rating_and_review_statistics,question_answer_statistics"
 
 // Get fetches a product's name by id
-func (t TargetProductNameRepository) Get(productID int) (string, error) {
-	url := fmt.Sprintf(redskyAPI, productID)
+func (t TargetProductNameRepository) Get(ctx context.Context, productID int) (string, error) {
+	if wait := t.backoff.wait(time.Now()); wait > 0 {
+		return "", &RateLimitedError{RetryAfter: wait}
+	}
+
+	url := t.baseURL + fmt.Sprintf(redskyPath, productID)
 	log.Printf("Making request to %s", url)
 
-	response, err := t.httpClient.Get(url)
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := t.httpClient.Do(request)
 	if err != nil {
 		return "", err
 	}
 
 	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return "", ErrProductNotFound
+
+	case response.StatusCode == http.StatusTooManyRequests:
+		retryAfter := parseRetryAfter(response.Header.Get("Retry-After"), time.Now())
+		t.backoff.set(time.Now().Add(retryAfter))
+		return "", &RateLimitedError{RetryAfter: retryAfter}
+
+	case response.StatusCode != http.StatusOK:
+		return "", &UpstreamStatusError{StatusCode: response.StatusCode}
+	}
+
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		return "", err
 	}
 
-	return t.readTitle(body)
+	title, err := t.readTitle(body)
+	if err != nil {
+		return "", err
+	}
+
+	// RedSky answers unknown products with an empty item
+	if title == "" {
+		return "", ErrProductNotFound
+	}
+
+	return title, nil
+}
+
+// parseRetryAfter reads a Retry-After header, which holds either a number of
+// seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
 }
 
 func (t TargetProductNameRepository) readTitle(data []byte) (string, error) {
@@ -1,12 +1,14 @@
 package productaggregate
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 var readTitleTests = []struct {
@@ -53,25 +55,174 @@ func TestNameRepositoryReadTitle(t *testing.T) {
 }
 
 func TestNewTargetNameRepository(t *testing.T) {
-	NewTargetProductNameRepository()
+	repository := NewTargetProductNameRepository("https://example.com/", time.Second)
+	if repository.baseURL != "https://example.com" {
+		t.Errorf("got base URL %s, want https://example.com", repository.baseURL)
+	}
 }
 
 func TestTargetNameRepositoryGet(t *testing.T) {
+	data := helperLoadBytes(t, "products", "notfound.json")
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, "BAD DATA")
+		w.Write(data)
 	}))
 	defer ts.Close()
 
 	repository := TargetProductNameRepository{
 		httpClient: ts.Client(),
+		baseURL:    ts.URL,
 	}
 
-	resp, err := repository.Get(123)
+	resp, err := repository.Get(context.Background(), 123)
 	if resp != "" {
 		t.Errorf("Expected '', got '%s'", resp)
 	}
 
-	if err != nil {
-		t.Errorf("Expected no error, got '%+v'", err)
+	if !errors.Is(err, ErrProductNotFound) {
+		t.Errorf("Expected ErrProductNotFound, got '%+v'", err)
+	}
+}
+
+type upstreamResponse struct {
+	code       int
+	retryAfter string
+	body       []byte
+}
+
+var nameRepositoryStatusTests = []struct {
+	name           string
+	in             upstreamResponse
+	want           string
+	wantErr        error
+	wantRetryAfter time.Duration
+}{
+	{
+		name: "Found",
+		in:   upstreamResponse{code: http.StatusOK, body: []byte(`{"product":{"item":{"product_description":{"title":"Picard"}}}}`)},
+		want: "Picard",
+	},
+	{
+		name:    "Not found status",
+		in:      upstreamResponse{code: http.StatusNotFound, body: []byte("<html>Not Found</html>")},
+		wantErr: ErrProductNotFound,
+	},
+	{
+		name:    "Server error",
+		in:      upstreamResponse{code: http.StatusInternalServerError, body: []byte("<html>Oops</html>")},
+		wantErr: ErrUpstreamUnavailable,
+	},
+	{
+		name:    "Service unavailable",
+		in:      upstreamResponse{code: http.StatusServiceUnavailable},
+		wantErr: ErrUpstreamUnavailable,
+	},
+	{
+		name:           "Rate limited with seconds",
+		in:             upstreamResponse{code: http.StatusTooManyRequests, retryAfter: "120"},
+		wantErr:        ErrUpstreamRateLimited,
+		wantRetryAfter: 2 * time.Minute,
+	},
+	{
+		name:    "Rate limited without Retry-After",
+		in:      upstreamResponse{code: http.StatusTooManyRequests},
+		wantErr: ErrUpstreamRateLimited,
+	},
+}
+
+func TestTargetNameRepositoryGetStatus(t *testing.T) {
+	for _, tt := range nameRepositoryStatusTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.in.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.in.retryAfter)
+				}
+				w.WriteHeader(tt.in.code)
+				w.Write(tt.in.body)
+			}))
+			defer ts.Close()
+
+			repository := NewTargetProductNameRepository(ts.URL, time.Second)
+
+			name, err := repository.Get(context.Background(), 123)
+			if name != tt.want {
+				t.Errorf("got %s, want %s", name, tt.want)
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %+v, want %+v", err, tt.wantErr)
+			}
+
+			var rateLimited *RateLimitedError
+			if errors.As(err, &rateLimited) && rateLimited.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("got retry after %s, want %s", rateLimited.RetryAfter, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestTargetNameRepositoryNotFoundIsNotUnavailable(t *testing.T) {
+	err := &UpstreamStatusError{StatusCode: http.StatusBadRequest}
+	if errors.Is(err, ErrUpstreamUnavailable) {
+		t.Errorf("%s should not match ErrUpstreamUnavailable", err)
+	}
+}
+
+func TestTargetNameRepositoryHonorsRetryAfter(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	repository := NewTargetProductNameRepository(ts.URL, time.Second)
+	repository.Get(context.Background(), 123)
+
+	_, err := repository.Get(context.Background(), 456)
+	if !errors.Is(err, ErrUpstreamRateLimited) {
+		t.Errorf("got error %+v, want %+v", err, ErrUpstreamRateLimited)
+	}
+
+	if calls != 1 {
+		t.Errorf("got %d upstream calls, want 1", calls)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"-5", 0},
+		{"Wed, 01 Apr 2020 12:01:30 GMT", 90 * time.Second},
+		{"Wed, 01 Apr 2020 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.in, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q): got %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTargetNameRepositoryGetCancelled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should not reach the upstream")
+	}))
+	defer ts.Close()
+
+	repository := NewTargetProductNameRepository(ts.URL, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := repository.Get(ctx, 123); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %+v, want %+v", err, context.Canceled)
 	}
 }
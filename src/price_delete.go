@@ -0,0 +1,153 @@
+package productaggregate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ErrPriceNotDeleted is returned when restoring prices which were not
+// deleted
+var ErrPriceNotDeleted = errors.New("product prices are not deleted")
+
+// PriceDeletedError is returned for a product whose prices were deleted. It
+// matches ErrPriceNotFound.
+type PriceDeletedError struct {
+	DeletedAt time.Time
+	DeletedBy string
+}
+
+func (e *PriceDeletedError) Error() string {
+	return fmt.Sprintf("product prices were deleted at %s by %s", e.DeletedAt.Format(time.RFC3339), e.DeletedBy)
+}
+
+// Is reports whether target is ErrPriceNotFound
+func (e *PriceDeletedError) Is(target error) bool {
+	return target == ErrPriceNotFound
+}
+
+// deleted reports whether the entity holds a tombstone
+func (e *priceEntity) deleted() bool {
+	return !e.DeletedAt.IsZero()
+}
+
+// delete leaves a tombstone recording who deleted the entity's prices, and
+// when. The prices are kept, so that undelete can restore them. A version
+// is expected as for setPrice.
+func (e *priceEntity) delete(version int64, actor string, now time.Time) error {
+	if e.deleted() {
+		return &PriceDeletedError{DeletedAt: e.DeletedAt, DeletedBy: e.DeletedBy}
+	}
+
+	if len(e.Prices) == 0 {
+		return ErrPriceNotFound
+	}
+
+	if err := e.checkVersion(version); err != nil {
+		return err
+	}
+
+	e.DeletedAt = now.UTC()
+	e.DeletedBy = actor
+	e.Version++
+	e.UpdatedAt = now.UTC()
+	return nil
+}
+
+// undelete removes the entity's tombstone, restoring its prices
+func (e *priceEntity) undelete(now time.Time) error {
+	if !e.deleted() {
+		if len(e.Prices) == 0 {
+			return ErrPriceNotFound
+		}
+
+		return ErrPriceNotDeleted
+	}
+
+	e.DeletedAt = time.Time{}
+	e.DeletedBy = ""
+	e.Version++
+	e.UpdatedAt = now.UTC()
+	return nil
+}
+
+// HandleDelete deletes a product's prices in every currency. The product's
+// name is untouched, as it belongs to RedSky.
+func (rh RequestHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	productID, ok := rh.productID(w, r)
+	if !ok {
+		return
+	}
+
+	version, ok := rh.expectedVersion(w, r, productID)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := sourceContext(r.Context(), rh.priceTimeout)
+	defer cancel()
+
+	ctx = WithActor(ctx, r.Header.Get(actorHeader))
+
+	err := rh.priceRepository.Delete(ctx, productID, version)
+	var deleted *PriceDeletedError
+	if errors.As(err, &deleted) {
+		msg := fmt.Sprintf("Product price was already deleted at %s by %s", deleted.DeletedAt.Format(time.RFC3339), deleted.DeletedBy)
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrPriceNotFound) {
+		msg := "Product price not found"
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+	var mismatch *VersionMismatchError
+	if errors.As(err, &mismatch) {
+		rh.writeVersionMismatch(w, r, productID, mismatch)
+		return
+	}
+	if err != nil {
+		msg := "Error deleting product price"
+		http.Error(w, msg, http.StatusInternalServerError)
+		log.Printf("Failed deleting product %d price: %s", productID, err)
+		return
+	}
+
+	fmt.Fprint(w, "Product price deleted")
+}
+
+// HandleUndelete restores a product's deleted prices
+func (rh RequestHandler) HandleUndelete(w http.ResponseWriter, r *http.Request) {
+	productID, ok := rh.productID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := sourceContext(r.Context(), rh.priceTimeout)
+	defer cancel()
+
+	ctx = WithActor(ctx, r.Header.Get(actorHeader))
+
+	err := rh.priceRepository.Undelete(ctx, productID)
+	switch {
+	case errors.Is(err, ErrPriceNotFound):
+		msg := "Product price not found"
+		http.Error(w, msg, http.StatusNotFound)
+		return
+
+	case errors.Is(err, ErrPriceNotDeleted):
+		msg := "Product price is not deleted"
+		http.Error(w, msg, http.StatusConflict)
+		return
+
+	case err != nil:
+		msg := "Error restoring product price"
+		http.Error(w, msg, http.StatusInternalServerError)
+		log.Printf("Failed restoring product %d price: %s", productID, err)
+		return
+	}
+
+	fmt.Fprint(w, "Product price restored")
+}
@@ -0,0 +1,241 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPriceDeletion(t *testing.T) {
+	deletedAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	memory := NewInMemoryProductPriceRepository()
+	memory.now = func() time.Time { return deletedAt }
+	datastore := helperPropertyRepository(t, newPropertyDatastoreClient())
+	datastore.now = func() time.Time { return deletedAt }
+
+	repositories := map[string]ProductPriceRepository{
+		"memory":    memory,
+		"datastore": datastore,
+	}
+
+	for backend, repository := range repositories {
+		ctx := WithActor(context.Background(), "worf")
+		for _, price := range []ProductPrice{
+			{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+			{ProductID: 10, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"},
+		} {
+			if err := repository.Put(ctx, price); err != nil {
+				t.Fatalf("%s: unexpected error: %+v", backend, err)
+			}
+		}
+
+		if err := repository.Delete(ctx, 11, 0); !errors.Is(err, ErrPriceNotFound) {
+			t.Errorf("%s: got %+v deleting a product without prices, want %+v", backend, err, ErrPriceNotFound)
+		}
+
+		var mismatch *VersionMismatchError
+		if err := repository.Delete(ctx, 10, 1); !errors.As(err, &mismatch) || mismatch.Current != 2 {
+			t.Errorf("%s: got %+v deleting a stale version, want a mismatch with version 2", backend, err)
+		}
+
+		if err := repository.Delete(ctx, 10, 2); err != nil {
+			t.Fatalf("%s: unexpected error: %+v", backend, err)
+		}
+
+		var deleted *PriceDeletedError
+		_, err := repository.Get(ctx, 10, PriceQuery{CurrencyCode: "EUR"})
+		if !errors.As(err, &deleted) || !errors.Is(err, ErrPriceNotFound) {
+			t.Fatalf("%s: got %+v, want a *PriceDeletedError", backend, err)
+		}
+
+		if !deleted.DeletedAt.Equal(deletedAt) || deleted.DeletedBy != "worf" {
+			t.Errorf("%s: got tombstone %+v, want worf at %s", backend, deleted, deletedAt)
+		}
+
+		if page, err := repository.List(ctx, PriceListQuery{ProductIDs: []int{10}}); err != nil || len(page.Prices) != 0 {
+			t.Errorf("%s: got %+v %+v, want deleted prices left out", backend, page, err)
+		}
+
+		if err := repository.Delete(ctx, 10, 0); !errors.As(err, &deleted) {
+			t.Errorf("%s: got %+v deleting twice, want a *PriceDeletedError", backend, err)
+		}
+
+		if err := repository.Undelete(ctx, 10); err != nil {
+			t.Fatalf("%s: unexpected error: %+v", backend, err)
+		}
+
+		price, err := repository.Get(ctx, 10, PriceQuery{CurrencyCode: "EUR"})
+		if err != nil || price.Price.String() != "12.50" || price.Version != 4 {
+			t.Errorf("%s: got %+v %+v, want 12.50 restored at version 4", backend, price, err)
+		}
+
+		if err := repository.Undelete(ctx, 10); !errors.Is(err, ErrPriceNotDeleted) {
+			t.Errorf("%s: got %+v, want %+v", backend, err, ErrPriceNotDeleted)
+		}
+
+		if err := repository.Undelete(ctx, 11); !errors.Is(err, ErrPriceNotFound) {
+			t.Errorf("%s: got %+v, want %+v", backend, err, ErrPriceNotFound)
+		}
+	}
+}
+
+func TestPutAfterDeletion(t *testing.T) {
+	repository := NewInMemoryProductPriceRepository()
+	ctx := context.Background()
+
+	repository.Put(ctx, ProductPrice{ProductID: 10, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"})
+	if err := repository.Delete(ctx, 10, 0); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// Only a product with prices matches *
+	err := repository.Put(ctx, ProductPrice{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD", Version: AnyVersion})
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("got %+v, want %+v", err, ErrVersionMismatch)
+	}
+
+	if err := repository.Put(ctx, ProductPrice{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD"}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// The product starts over with only the new price
+	price, err := repository.Get(ctx, 10, PriceQuery{})
+	if err != nil || price.CurrencyCode != "USD" {
+		t.Errorf("got %+v %+v, want USD as the base currency", price, err)
+	}
+
+	if _, err := repository.Get(ctx, 10, PriceQuery{CurrencyCode: "EUR"}); !errors.Is(err, ErrCurrencyNotFound) {
+		t.Errorf("got %+v, want the deleted EUR price gone", err)
+	}
+
+	if err := repository.Undelete(ctx, 10); !errors.Is(err, ErrPriceNotDeleted) {
+		t.Errorf("got %+v, want %+v", err, ErrPriceNotDeleted)
+	}
+}
+
+func TestPriceDeletionRecordsHistory(t *testing.T) {
+	repository := NewInMemoryProductPriceRepository()
+	clock := &fakeClock{now: historyStart}
+	repository.now = clock.Now
+
+	for _, price := range []ProductPrice{
+		{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+		{ProductID: 10, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"},
+	} {
+		repository.Put(WithActor(context.Background(), "jdoe"), price)
+		clock.Advance(time.Minute)
+	}
+
+	if err := repository.Delete(WithActor(context.Background(), "worf"), 10, 0); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	clock.Advance(time.Minute)
+
+	if err := repository.Undelete(WithActor(context.Background(), "riker"), 10); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	page, err := repository.History(context.Background(), 10, PriceHistoryQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	want := "[-->13.49 00:00 jdoe][-->12.50 00:01 jdoe]" +
+		"[delete 13.49->- 00:02 worf][delete 12.50->- 00:02 worf]" +
+		"[undelete -->13.49 00:03 riker][undelete -->12.50 00:03 riker]"
+	if got := describeChanges(page.Changes); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestProductPriceRepositoryDeleteRecordsHistory(t *testing.T) {
+	client := newPropertyDatastoreClient()
+	repository := helperPropertyRepository(t, client)
+	repository.now = func() time.Time { return historyStart }
+
+	repository.Put(context.Background(), ProductPrice{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD"})
+
+	repository.now = func() time.Time { return historyStart.Add(time.Minute) }
+	if err := repository.Delete(WithActor(context.Background(), "worf"), 10, 0); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	changes := helperStoredChanges(t, client, repository.keyFromProductID(10), historyStart.Add(time.Minute))
+	if got, want := describeChanges(changes), "[delete 13.49->- 00:01 worf]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+var priceDeleteRequestTests = []struct {
+	name     string
+	method   string
+	path     string
+	ifMatch  string
+	wantCode int
+	wantBody string
+}{
+	{"Delete", "DELETE", "/products/123", "", http.StatusOK, "Product price deleted"},
+	{"Delete the price", "DELETE", "/products/123/price", "GET", http.StatusOK, "Product price deleted"},
+	{"Delete a stale version", "DELETE", "/products/123", `"7-0000000000000000"`, http.StatusPreconditionFailed, "Product price has changed since it was read\n"},
+	{"Delete without a price", "DELETE", "/products/456", "", http.StatusNotFound, "Product price not found\n"},
+	{"Undelete without a price", "POST", "/products/456/price/undelete", "", http.StatusNotFound, "Product price not found\n"},
+	{"Undelete prices not deleted", "POST", "/products/123/price/undelete", "", http.StatusConflict, "Product price is not deleted\n"},
+}
+
+func TestRequestHandlerDelete(t *testing.T) {
+	for _, tt := range priceDeleteRequestTests {
+		t.Run(tt.name, func(t *testing.T) {
+			rh := helperRouterHandler(t)
+
+			r := httptest.NewRequest(tt.method, "http://example.com"+tt.path, nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", strings.Replace(tt.ifMatch, "GET", helperGetETag(t, rh, 123), 1))
+			}
+
+			w := httptest.NewRecorder()
+			rh.HandleRequest(w, r)
+
+			if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRequestHandlerDeleteAndUndelete(t *testing.T) {
+	rh := helperRouterHandler(t)
+	rh.priceRepository.(*InMemoryProductPriceRepository).now = func() time.Time {
+		return time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	steps := []struct {
+		method   string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"DELETE", "/products/123", http.StatusOK, "Product price deleted"},
+		{"GET", "/products/123/price", http.StatusNotFound, "Product not found\n"},
+		{"GET", "/products/123", http.StatusOK, `{"product_id":123,"name":"Picard","_meta":{"errors":[{"source":"price","kind":"not_found"}]}}`},
+		{"DELETE", "/products/123", http.StatusNotFound, "Product price was already deleted at 2030-01-01T00:00:00Z by data\n"},
+		{"POST", "/products/123/price/undelete", http.StatusOK, "Product price restored"},
+		{"GET", "/products/123/price", http.StatusOK, `{"product_id":123,"current_price":{"value":13.49,"currency_code":"USD"}}`},
+	}
+
+	for _, step := range steps {
+		r := httptest.NewRequest(step.method, "http://example.com"+step.path, strings.NewReader(""))
+		r.Header.Set(actorHeader, "data")
+
+		w := httptest.NewRecorder()
+		rh.HandleRequest(w, r)
+
+		if w.Code != step.wantCode || w.Body.String() != step.wantBody {
+			t.Fatalf("%s %s: got %d %q, want %d %q", step.method, step.path, w.Code, w.Body.String(), step.wantCode, step.wantBody)
+		}
+	}
+}
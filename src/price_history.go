@@ -0,0 +1,368 @@
+package productaggregate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// ErrInvalidPageToken is returned when a page token was not issued by the
+// repository it is given to
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// Price history page sizes
+const (
+	DefaultPriceHistoryPageSize = 50
+	MaxPriceHistoryPageSize     = 500
+)
+
+// AnonymousActor is recorded for changes made without a known actor
+const AnonymousActor = "anonymous"
+
+type actorKey struct{}
+
+// WithActor returns a context recording who is making changes, for the
+// price history
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor recorded by WithActor, or
+// AnonymousActor
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+
+	return AnonymousActor
+}
+
+// Actions recorded in the price history
+const (
+	PriceChangePut      = "put"
+	PriceChangeDelete   = "delete"
+	PriceChangeUndelete = "undelete"
+
+	// PriceChangeExpire records a scheduled price dropped once its window
+	// had ended
+	PriceChangeExpire = "expire"
+
+	// PriceChangeRemove records a price moved to another currency or
+	// window, which is recorded as put there
+	PriceChangeRemove = "remove"
+)
+
+// PriceChange records one change to a product's price. OldValue is nil
+// when the product had no price in the currency with the same effective
+// window before, or when a deleted price was restored. NewValue is nil when
+// the price was deleted, removed or expired.
+type PriceChange struct {
+	ProductID     int        `json:"-"`
+	Action        string     `json:"action"`
+	CurrencyCode  string     `json:"currency_code"`
+	EffectiveFrom *time.Time `json:"effective_from,omitempty"`
+	EffectiveTo   *time.Time `json:"effective_to,omitempty"`
+	OldValue      *Money     `json:"old_value"`
+	NewValue      *Money     `json:"new_value"`
+	ChangedAt     time.Time  `json:"changed_at"`
+	Actor         string     `json:"actor"`
+}
+
+// newPriceChange records the put of price, replacing old, which may be nil
+func newPriceChange(ctx context.Context, old *ProductPrice, price ProductPrice, now time.Time) PriceChange {
+	newValue := price.Price
+	change := PriceChange{
+		ProductID:     price.ProductID,
+		Action:        PriceChangePut,
+		CurrencyCode:  price.CurrencyCode,
+		EffectiveFrom: price.EffectiveFrom,
+		EffectiveTo:   price.EffectiveTo,
+		NewValue:      &newValue,
+		ChangedAt:     now.UTC(),
+		Actor:         ActorFromContext(ctx),
+	}
+
+	if old != nil {
+		oldValue := old.Price
+		change.OldValue = &oldValue
+	}
+
+	return change
+}
+
+// newReplacementChanges records replaced being replaced by price. A price
+// which stays in the same currency and window is recorded as a put over
+// it; otherwise replaced is recorded as removed, a nanosecond before price
+// is put.
+func newReplacementChanges(ctx context.Context, replaced ProductPrice, price ProductPrice, now time.Time) []PriceChange {
+	if replaced.CurrencyCode == price.CurrencyCode && replaced.sameWindow(price) {
+		return []PriceChange{newPriceChange(ctx, &replaced, price, now)}
+	}
+
+	oldValue := replaced.Price
+	removal := PriceChange{
+		ProductID:     price.ProductID,
+		Action:        PriceChangeRemove,
+		CurrencyCode:  replaced.CurrencyCode,
+		EffectiveFrom: replaced.EffectiveFrom,
+		EffectiveTo:   replaced.EffectiveTo,
+		OldValue:      &oldValue,
+		ChangedAt:     now.UTC(),
+		Actor:         ActorFromContext(ctx),
+	}
+
+	return []PriceChange{removal, newPriceChange(ctx, nil, price, now.Add(time.Nanosecond))}
+}
+
+// newExpiryChanges records the expiry of scheduled prices dropped by a
+// change to the product at now. Changes are a nanosecond apart, so that they
+// sort in order, and the change which dropped them should follow them.
+func newExpiryChanges(ctx context.Context, productID int, expired []ProductPrice, now time.Time) []PriceChange {
+	changes := make([]PriceChange, len(expired))
+	for i, price := range expired {
+		oldValue := price.Price
+		changes[i] = PriceChange{
+			ProductID:     productID,
+			Action:        PriceChangeExpire,
+			CurrencyCode:  price.CurrencyCode,
+			EffectiveFrom: price.EffectiveFrom,
+			EffectiveTo:   price.EffectiveTo,
+			OldValue:      &oldValue,
+			ChangedAt:     now.Add(time.Duration(i)).UTC(),
+			Actor:         ActorFromContext(ctx),
+		}
+	}
+
+	return changes
+}
+
+// newDeletionChanges records the deletion of each of the entity's prices,
+// or their restoration for PriceChangeUndelete. Changes are a nanosecond
+// apart, so that they sort in order.
+func newDeletionChanges(ctx context.Context, entity *priceEntity, action string, now time.Time) []PriceChange {
+	changes := make([]PriceChange, len(entity.Prices))
+	for i, price := range entity.Prices {
+		value := price.Price
+		changes[i] = PriceChange{
+			ProductID:     entity.ProductID,
+			Action:        action,
+			CurrencyCode:  price.CurrencyCode,
+			EffectiveFrom: price.EffectiveFrom,
+			EffectiveTo:   price.EffectiveTo,
+			ChangedAt:     now.Add(time.Duration(i)).UTC(),
+			Actor:         ActorFromContext(ctx),
+		}
+
+		if action == PriceChangeDelete {
+			changes[i].OldValue = &value
+		} else {
+			changes[i].NewValue = &value
+		}
+	}
+
+	return changes
+}
+
+// PriceHistoryQuery selects a page of a product's price history
+type PriceHistoryQuery struct {
+	// From and To limit the history to changes at or after From, and before
+	// To. Either is ignored when zero.
+	From time.Time
+	To   time.Time
+
+	// PageSize is the most changes returned at once. DefaultPriceHistoryPageSize
+	// is used when it is zero, and it is capped at MaxPriceHistoryPageSize.
+	PageSize int
+
+	// PageToken continues from an earlier page's NextPageToken
+	PageToken string
+}
+
+func (q PriceHistoryQuery) pageSize() int {
+	switch {
+	case q.PageSize <= 0:
+		return DefaultPriceHistoryPageSize
+
+	case q.PageSize > MaxPriceHistoryPageSize:
+		return MaxPriceHistoryPageSize
+
+	default:
+		return q.PageSize
+	}
+}
+
+// includes reports whether a change made at t is within the query's range
+func (q PriceHistoryQuery) includes(t time.Time) bool {
+	if !q.From.IsZero() && t.Before(q.From) {
+		return false
+	}
+
+	return q.To.IsZero() || t.Before(q.To)
+}
+
+// PriceHistoryPage is one page of a product's price history, oldest first.
+// NextPageToken is empty on the last page.
+type PriceHistoryPage struct {
+	Changes       []PriceChange
+	NextPageToken string
+}
+
+func encodePageToken(position string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(position))
+}
+
+func decodePageToken(token string) (string, error) {
+	position, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(position) == 0 {
+		return "", ErrInvalidPageToken
+	}
+
+	return string(position), nil
+}
+
+// historyKeyName names a change's entity so that keys sort by time. A
+// random suffix keeps changes made in the same nanosecond from overwriting
+// each other, which is likely where the clock is coarser than that.
+func historyKeyName(changedAt time.Time) string {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		panic(fmt.Sprintf("reading random history key suffix: %s", err))
+	}
+
+	return fmt.Sprintf("%s-%x", historyKeyBound(changedAt), suffix)
+}
+
+// historyKeyBound sorts before the names of every change made at or after
+// t, and after those of every change made before it
+func historyKeyBound(t time.Time) string {
+	return fmt.Sprintf("%019d", t.UnixNano())
+}
+
+// Datastore property names for PriceChange
+const (
+	propertyAction      = "action"
+	propertyOldUnits    = "old_units"
+	propertyOldExponent = "old_exponent"
+	propertyNewUnits    = "new_units"
+	propertyNewExponent = "new_exponent"
+	propertyChangedAt   = "changed_at"
+	propertyActor       = "actor"
+)
+
+// Save encodes the change for the datastore
+func (c *PriceChange) Save() ([]datastore.Property, error) {
+	props := []datastore.Property{
+		{Name: propertyProductID, Value: int64(c.ProductID)},
+		{Name: propertyAction, Value: c.Action},
+		{Name: propertyCurrencyCode, Value: c.CurrencyCode},
+		{Name: propertyChangedAt, Value: c.ChangedAt},
+		{Name: propertyActor, Value: c.Actor},
+	}
+
+	if c.EffectiveFrom != nil {
+		props = append(props, datastore.Property{Name: propertyEffectiveFrom, Value: *c.EffectiveFrom, NoIndex: true})
+	}
+
+	if c.EffectiveTo != nil {
+		props = append(props, datastore.Property{Name: propertyEffectiveTo, Value: *c.EffectiveTo, NoIndex: true})
+	}
+
+	if c.OldValue != nil {
+		props = append(props,
+			datastore.Property{Name: propertyOldUnits, Value: c.OldValue.Units(), NoIndex: true},
+			datastore.Property{Name: propertyOldExponent, Value: int64(c.OldValue.Exponent()), NoIndex: true},
+		)
+	}
+
+	if c.NewValue != nil {
+		props = append(props,
+			datastore.Property{Name: propertyNewUnits, Value: c.NewValue.Units(), NoIndex: true},
+			datastore.Property{Name: propertyNewExponent, Value: int64(c.NewValue.Exponent()), NoIndex: true},
+		)
+	}
+
+	return props, nil
+}
+
+// Load decodes a change from the datastore. Changes stored before actions
+// were recorded are puts.
+func (c *PriceChange) Load(props []datastore.Property) error {
+	var oldUnits, oldExponent, newUnits, newExponent int64
+	hasOld, hasNew := false, false
+	c.Action = PriceChangePut
+
+	for _, prop := range props {
+		var ok bool
+		switch prop.Name {
+		case propertyProductID:
+			var id int64
+			id, ok = prop.Value.(int64)
+			c.ProductID = int(id)
+
+		case propertyAction:
+			c.Action, ok = prop.Value.(string)
+
+		case propertyCurrencyCode:
+			c.CurrencyCode, ok = prop.Value.(string)
+
+		case propertyOldUnits:
+			oldUnits, ok = prop.Value.(int64)
+			hasOld = true
+
+		case propertyOldExponent:
+			oldExponent, ok = prop.Value.(int64)
+
+		case propertyNewUnits:
+			newUnits, ok = prop.Value.(int64)
+			hasNew = true
+
+		case propertyNewExponent:
+			newExponent, ok = prop.Value.(int64)
+
+		case propertyChangedAt:
+			c.ChangedAt, ok = prop.Value.(time.Time)
+
+		case propertyActor:
+			c.Actor, ok = prop.Value.(string)
+
+		case propertyEffectiveFrom, propertyEffectiveTo:
+			var t time.Time
+			t, ok = prop.Value.(time.Time)
+			if prop.Name == propertyEffectiveFrom {
+				c.EffectiveFrom = &t
+			} else {
+				c.EffectiveTo = &t
+			}
+
+		default:
+			ok = true
+		}
+
+		if !ok {
+			return fmt.Errorf("datastore property %s has unexpected type %T", prop.Name, prop.Value)
+		}
+	}
+
+	for _, exponent := range []int64{oldExponent, newExponent} {
+		if exponent < 0 || exponent > maxMoneyExponent {
+			return fmt.Errorf("datastore price exponent %d out of range", exponent)
+		}
+	}
+
+	if hasOld {
+		oldValue := NewMoney(oldUnits, int(oldExponent))
+		c.OldValue = &oldValue
+	}
+
+	if hasNew {
+		newValue := NewMoney(newUnits, int(newExponent))
+		c.NewValue = &newValue
+	}
+
+	return nil
+}
@@ -0,0 +1,279 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+var historyStart = time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+
+// helperPriceHistory makes five changes to product 10, a minute apart,
+// followed by one to product 11
+func helperPriceHistory(t *testing.T) *InMemoryProductPriceRepository {
+	clock := &fakeClock{now: historyStart}
+	repository := helperPriceRepository(t, clock.Now)
+
+	ctx := WithActor(context.Background(), "merchandising")
+	for i := 1; i <= 5; i++ {
+		price := ProductPrice{ProductID: 10, Price: NewMoney(int64(i), 0), CurrencyCode: "USD"}
+		if err := repository.Put(ctx, price); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		clock.Advance(time.Minute)
+	}
+
+	repository.Put(context.Background(), ProductPrice{ProductID: 11, Price: NewMoney(1, 0), CurrencyCode: "USD"})
+
+	return repository
+}
+
+func describeChanges(changes []PriceChange) string {
+	s := ""
+	for _, change := range changes {
+		before, after := "-", "-"
+		if change.OldValue != nil {
+			before = change.OldValue.String()
+		}
+
+		if change.NewValue != nil {
+			after = change.NewValue.String()
+		}
+
+		action := ""
+		if change.Action != PriceChangePut {
+			action = change.Action + " "
+		}
+
+		s += fmt.Sprintf("[%s%s->%s %s %s]", action, before, after, change.ChangedAt.Format("15:04"), change.Actor)
+	}
+
+	return s
+}
+
+var priceHistoryTests = []struct {
+	name  string
+	query PriceHistoryQuery
+	want  string
+}{
+	{
+		name:  "Everything",
+		query: PriceHistoryQuery{},
+		want:  "[-->1 00:00 merchandising][1->2 00:01 merchandising][2->3 00:02 merchandising][3->4 00:03 merchandising][4->5 00:04 merchandising]",
+	},
+	{
+		name:  "From is inclusive and to is exclusive",
+		query: PriceHistoryQuery{From: historyStart.Add(time.Minute), To: historyStart.Add(3 * time.Minute)},
+		want:  "[1->2 00:01 merchandising][2->3 00:02 merchandising]",
+	},
+}
+
+func TestInMemoryProductPriceRepositoryHistory(t *testing.T) {
+	repository := helperPriceHistory(t)
+
+	for _, tt := range priceHistoryTests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := repository.History(context.Background(), 10, tt.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got := describeChanges(page.Changes); got != tt.want || page.NextPageToken != "" {
+				t.Errorf("got %s %q, want %s and no next page", got, page.NextPageToken, tt.want)
+			}
+		})
+	}
+}
+
+func TestInMemoryProductPriceRepositoryHistoryPages(t *testing.T) {
+	repository := helperPriceHistory(t)
+
+	query := PriceHistoryQuery{From: historyStart.Add(time.Minute), PageSize: 2}
+	var pages []string
+	for {
+		page, err := repository.History(context.Background(), 10, query)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		pages = append(pages, describeChanges(page.Changes))
+		if page.NextPageToken == "" {
+			break
+		}
+
+		query.PageToken = page.NextPageToken
+	}
+
+	want := []string{
+		"[1->2 00:01 merchandising][2->3 00:02 merchandising]",
+		"[3->4 00:03 merchandising][4->5 00:04 merchandising]",
+	}
+	if fmt.Sprint(pages) != fmt.Sprint(want) {
+		t.Errorf("got pages %v, want %v", pages, want)
+	}
+}
+
+func TestInMemoryProductPriceRepositoryHistoryInvalidPageToken(t *testing.T) {
+	repository := helperPriceHistory(t)
+
+	for _, token := range []string{"not base64!", encodePageToken("x"), encodePageToken("-1")} {
+		_, err := repository.History(context.Background(), 10, PriceHistoryQuery{PageToken: token})
+		if !errors.Is(err, ErrInvalidPageToken) {
+			t.Errorf("%q: got error %+v, want %+v", token, err, ErrInvalidPageToken)
+		}
+	}
+}
+
+func TestActorFromContext(t *testing.T) {
+	if actor := ActorFromContext(context.Background()); actor != AnonymousActor {
+		t.Errorf("got %q, want %q", actor, AnonymousActor)
+	}
+
+	if actor := ActorFromContext(WithActor(context.Background(), "")); actor != AnonymousActor {
+		t.Errorf("got %q, want %q", actor, AnonymousActor)
+	}
+
+	if actor := ActorFromContext(WithActor(context.Background(), "jdoe")); actor != "jdoe" {
+		t.Errorf("got %q, want %q", actor, "jdoe")
+	}
+}
+
+// helperStoredChanges loads the changes a propertyDatastoreClient holds for
+// the product key, made at t
+func helperStoredChanges(t *testing.T, client *propertyDatastoreClient, productKey string, at time.Time) []PriceChange {
+	t.Helper()
+
+	parent := datastore.NameKey("test", productKey, nil)
+	prefix := datastore.NameKey("test_history", historyKeyBound(at), parent).String()
+
+	var names []string
+	for name := range client.entities {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := make([]PriceChange, len(names))
+	for i, name := range names {
+		if err := changes[i].Load(client.entities[name]); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	return changes
+}
+
+func TestHistoryKeyName(t *testing.T) {
+	first, second := historyKeyName(historyStart), historyKeyName(historyStart)
+	if first == second {
+		t.Errorf("got %s twice, want distinct names for changes in the same nanosecond", first)
+	}
+
+	for _, name := range []string{first, second} {
+		if name <= historyKeyBound(historyStart) || name >= historyKeyBound(historyStart.Add(time.Nanosecond)) {
+			t.Errorf("got %s, want it between the bounds for its time and the next nanosecond", name)
+		}
+	}
+}
+
+func TestProductPriceRepositoryPutRecordsHistory(t *testing.T) {
+	client := newPropertyDatastoreClient()
+	repository := helperPropertyRepository(t, client)
+	repository.now = func() time.Time { return historyStart }
+
+	ctx := WithActor(context.Background(), "jdoe")
+	repository.Put(ctx, ProductPrice{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD"})
+
+	repository.now = func() time.Time { return historyStart.Add(time.Minute) }
+	repository.Put(ctx, ProductPrice{ProductID: 10, Price: MustParseMoney("13.99"), CurrencyCode: "USD"})
+
+	changes := helperStoredChanges(t, client, repository.keyFromProductID(10), historyStart.Add(time.Minute))
+	if len(changes) != 1 {
+		t.Fatalf("got %d changes stored at 00:01, want 1", len(changes))
+	}
+
+	change := changes[0]
+	if got := describeChanges(changes); got != "[13.49->13.99 00:01 jdoe]" || change.ProductID != 10 || change.CurrencyCode != "USD" {
+		t.Errorf("got %s for %d %s, want [13.49->13.99 00:01 jdoe] for 10 USD", got, change.ProductID, change.CurrencyCode)
+	}
+}
+
+func TestRequestHandlerPriceHistory(t *testing.T) {
+	rh := newRequestHandler(NewInMemoryProductPriceRepository(), StubNameRepository{}, SourceOptions{}, SourceOptions{})
+
+	request := dummyRequest("PUT", `{"value":13.49,"currency_code":"USD"}`)
+	request.Header.Set("X-Actor", "jdoe")
+	rh.HandleRequest(httptest.NewRecorder(), request)
+	rh.HandleRequest(httptest.NewRecorder(), dummyRequest("PUT", `{"value":13.99,"currency_code":"USD"}`))
+
+	w := httptest.NewRecorder()
+	rh.HandleRequest(w, httptest.NewRequest("GET", "http://example.com/123/price/history?page_size=1", nil))
+
+	var response priceHistoryResponse
+	helperDecodeJSON(t, w, &response)
+
+	if got := describeChanges(response.Changes); response.ProductID != 123 || len(response.Changes) != 1 || response.Changes[0].Actor != "jdoe" || response.NextPageToken == "" {
+		t.Fatalf("got %d %s %q, want the first change by jdoe and a next page", response.ProductID, got, response.NextPageToken)
+	}
+
+	w = httptest.NewRecorder()
+	rh.HandleRequest(w, httptest.NewRequest("GET", "http://example.com/123/price/history?page_token="+response.NextPageToken, nil))
+
+	response = priceHistoryResponse{}
+	helperDecodeJSON(t, w, &response)
+
+	if len(response.Changes) != 1 || response.Changes[0].Actor != AnonymousActor || response.NextPageToken != "" {
+		t.Errorf("got %+v, want the last change by %s", response, AnonymousActor)
+	}
+}
+
+var priceHistoryRequestTests = []struct {
+	url  string
+	want httpWant
+}{
+	{
+		url:  "http://example.com/456/price/history",
+		want: httpWant{code: http.StatusOK, body: `{"product_id":456,"changes":[]}`},
+	},
+	{
+		url:  "http://example.com/abc/price/history",
+		want: httpWant{code: http.StatusBadRequest, body: "Invalid product ID\n"},
+	},
+	{
+		url:  "http://example.com/123/price/history?from=yesterday",
+		want: httpWant{code: http.StatusBadRequest, body: "Invalid from timestamp \"yesterday\", want RFC 3339\n"},
+	},
+	{
+		url:  "http://example.com/123/price/history?page_size=501",
+		want: httpWant{code: http.StatusBadRequest, body: "Invalid page size \"501\", want 1 to 500\n"},
+	},
+	{
+		url:  "http://example.com/123/price/history?page_token=bogus",
+		want: httpWant{code: http.StatusBadRequest, body: "Invalid page token\n"},
+	},
+}
+
+func TestRequestHandlerPriceHistoryErrors(t *testing.T) {
+	rh := newRequestHandler(NewInMemoryProductPriceRepository(), StubNameRepository{}, SourceOptions{}, SourceOptions{})
+
+	for _, tt := range priceHistoryRequestTests {
+		t.Run(tt.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rh.HandleRequest(w, httptest.NewRequest("GET", tt.url, nil))
+
+			if w.Code != tt.want.code || w.Body.String() != tt.want.body {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body.String(), tt.want.code, tt.want.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,447 @@
+package productaggregate
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Columns of price CSV files. The first line of a file names its columns,
+// in any order. The effective window columns are optional, and empty for
+// unscheduled prices.
+const (
+	ColumnProductID     = "product_id"
+	ColumnPrice         = "price"
+	ColumnCurrency      = "currency"
+	ColumnEffectiveFrom = "effective_from"
+	ColumnEffectiveTo   = "effective_to"
+)
+
+// priceColumns are the columns written on export, and all those read on
+// import
+var priceColumns = []string{ColumnProductID, ColumnPrice, ColumnCurrency, ColumnEffectiveFrom, ColumnEffectiveTo}
+
+// csvFields are the priceUpdate fields of each column, for naming the
+// column in validation errors
+var csvFields = map[string]string{
+	"value":         ColumnPrice,
+	"currency_code": ColumnCurrency,
+}
+
+// PriceImportRow is one price of an import, with the line of the file it
+// was read from
+type PriceImportRow struct {
+	Line  int
+	Price ProductPrice
+}
+
+// PriceImportError reports a problem with one line of an import
+type PriceImportError struct {
+	Line   int
+	Reason string
+}
+
+func (e *PriceImportError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// ReadPriceCSV reads prices from a CSV file, validating every line as a
+// price PUT would. Each invalid line is reported with a *PriceImportError,
+// and only the valid lines are returned as rows. A file which is not CSV,
+// or whose columns are wrong, fails with a single error.
+func ReadPriceCSV(r io.Reader) ([]PriceImportRow, []error) {
+	reader := &csvLineReader{lines: bufio.NewReader(r)}
+
+	header, line, err := reader.Read()
+	if err == io.EOF {
+		return nil, []error{&PriceImportError{Line: 1, Reason: "file is empty"}}
+	}
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	columns, err := csvColumns(header)
+	if err != nil {
+		return nil, []error{&PriceImportError{Line: line, Reason: err.Error()}}
+	}
+
+	var rows []PriceImportRow
+	var errs []error
+
+	// seen holds the line each product's price in a currency and window was
+	// first read from, to catch a price given twice
+	seen := make(map[string]int)
+
+	for {
+		record, line, err := reader.Read()
+		if err == io.EOF {
+			return rows, errs
+		}
+
+		var importErr *PriceImportError
+		if errors.As(err, &importErr) {
+			errs = append(errs, err)
+			continue
+		}
+
+		if err != nil {
+			return rows, append(errs, err)
+		}
+
+		price, reason := parsePriceRecord(record, columns)
+		if reason != "" {
+			errs = append(errs, &PriceImportError{Line: line, Reason: reason})
+			continue
+		}
+
+		key := fmt.Sprintf("%d %s %s", price.ProductID, price.CurrencyCode, DescribeWindow(price))
+		if first, ok := seen[key]; ok {
+			errs = append(errs, &PriceImportError{Line: line, Reason: fmt.Sprintf("repeats the price on line %d", first)})
+			continue
+		}
+		seen[key] = line
+
+		rows = append(rows, PriceImportRow{Line: line, Price: price})
+	}
+}
+
+// csvLineReader reads CSV records along with the line each starts on,
+// which encoding/csv does not report. Blank lines are skipped, and quoted
+// fields may span lines.
+type csvLineReader struct {
+	lines *bufio.Reader
+	line  int
+}
+
+// Read returns the next record and its line. A malformed record is
+// reported with a *PriceImportError, and reading may go on after it.
+func (r *csvLineReader) Read() ([]string, int, error) {
+	var text strings.Builder
+	start := 0
+
+	for {
+		next, err := r.lines.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+
+		if next != "" {
+			r.line++
+			if text.Len() == 0 && strings.TrimSpace(next) == "" {
+				continue
+			}
+
+			if start == 0 {
+				start = r.line
+			}
+			text.WriteString(next)
+		}
+
+		// A record is complete once its quotes are balanced
+		complete := text.Len() > 0 && strings.Count(text.String(), `"`)%2 == 0
+		if complete || err == io.EOF {
+			break
+		}
+	}
+
+	if text.Len() == 0 {
+		return nil, 0, io.EOF
+	}
+
+	reader := csv.NewReader(strings.NewReader(text.String()))
+	reader.TrimLeadingSpace = true
+
+	record, err := reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, start, &PriceImportError{Line: start + parseErr.Line - 1, Reason: parseErr.Err.Error()}
+	}
+
+	return record, start, err
+}
+
+// csvColumns finds each column's position in the header
+func csvColumns(header []string) (map[string]int, error) {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		known := false
+		for _, column := range priceColumns {
+			known = known || name == column
+		}
+
+		if !known {
+			return nil, fmt.Errorf("unknown column %q, want %s", name, strings.Join(priceColumns, ", "))
+		}
+
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("column %q is repeated", name)
+		}
+
+		columns[name] = i
+	}
+
+	for _, column := range []string{ColumnProductID, ColumnPrice, ColumnCurrency} {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("missing column %q", column)
+		}
+	}
+
+	return columns, nil
+}
+
+// parsePriceRecord reads and validates one line's price, returning why it
+// is invalid if it is
+func parsePriceRecord(record []string, columns map[string]int) (ProductPrice, string) {
+	field := func(column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+
+		return strings.TrimSpace(record[i])
+	}
+
+	if len(record) != len(columns) {
+		return ProductPrice{}, fmt.Sprintf("has %d fields, want %d", len(record), len(columns))
+	}
+
+	productID, err := strconv.Atoi(field(ColumnProductID))
+	if err != nil {
+		return ProductPrice{}, fmt.Sprintf("%s %q is not an integer", ColumnProductID, field(ColumnProductID))
+	}
+
+	var update priceUpdate
+	if raw := field(ColumnPrice); raw != "" {
+		value, err := ParseMoney(raw)
+		if err != nil {
+			return ProductPrice{}, fmt.Sprintf("%s %q is not a decimal number", ColumnPrice, raw)
+		}
+		update.Value = &value
+	}
+
+	if raw := field(ColumnCurrency); raw != "" {
+		update.CurrencyCode = &raw
+	}
+
+	for _, column := range []string{ColumnEffectiveFrom, ColumnEffectiveTo} {
+		raw := field(column)
+		if raw == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return ProductPrice{}, fmt.Sprintf("%s %q is not an RFC 3339 time", column, raw)
+		}
+
+		if column == ColumnEffectiveFrom {
+			update.EffectiveFrom = &t
+		} else {
+			update.EffectiveTo = &t
+		}
+	}
+
+	price, fieldErrs := update.toProductPrice(productID)
+	if len(fieldErrs) > 0 {
+		reasons := make([]string, len(fieldErrs))
+		for i, fieldErr := range fieldErrs {
+			column, ok := csvFields[fieldErr.Field]
+			if !ok {
+				column = fieldErr.Field
+			}
+
+			reasons[i] = column + ": " + fieldErr.Reason
+		}
+
+		return ProductPrice{}, strings.Join(reasons, "; ")
+	}
+
+	return price, ""
+}
+
+// Actions an import takes for a row
+const (
+	ImportAdd       = "add"
+	ImportUpdate    = "update"
+	ImportUnchanged = "unchanged"
+)
+
+// PriceDiff is what importing a row would do. Old is the stored price the
+// row replaces, and is nil when the row adds a price.
+type PriceDiff struct {
+	Row    PriceImportRow
+	Action string
+	Old    *ProductPrice
+}
+
+// DiffPrices compares rows with the stored prices they would replace, those
+// of the same product and currency with the same effective window
+func DiffPrices(ctx context.Context, repository ProductPriceRepository, rows []PriceImportRow) ([]PriceDiff, error) {
+	diffs := make([]PriceDiff, len(rows))
+	for start := 0; start < len(rows); start += MaxPriceListPageSize {
+		end := start + MaxPriceListPageSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		var productIDs []int
+		seen := make(map[int]bool)
+		for _, row := range rows[start:end] {
+			if !seen[row.Price.ProductID] {
+				seen[row.Price.ProductID] = true
+				productIDs = append(productIDs, row.Price.ProductID)
+			}
+		}
+
+		page, err := repository.List(ctx, PriceListQuery{ProductIDs: productIDs})
+		if err != nil {
+			return nil, err
+		}
+
+		for i, row := range rows[start:end] {
+			diffs[start+i] = diffPrice(row, page.Prices)
+		}
+	}
+
+	return diffs, nil
+}
+
+// diffPrice finds the stored price the row would replace
+func diffPrice(row PriceImportRow, stored []ProductPrice) PriceDiff {
+	for _, existing := range stored {
+		if existing.ProductID != row.Price.ProductID || existing.CurrencyCode != row.Price.CurrencyCode || !existing.sameWindow(row.Price) {
+			continue
+		}
+
+		old := existing
+		if existing.Price.Equal(row.Price.Price) {
+			return PriceDiff{Row: row, Action: ImportUnchanged, Old: &old}
+		}
+
+		return PriceDiff{Row: row, Action: ImportUpdate, Old: &old}
+	}
+
+	return PriceDiff{Row: row, Action: ImportAdd}
+}
+
+// ImportPrices puts the prices which change, in order, skipping those on
+// lines up to and including resumeAfter. done is called with each line once
+// it is imported, so that a failed import can be resumed after it. The
+// import stops at the first failure, which is returned as a
+// *PriceImportError.
+func ImportPrices(ctx context.Context, repository ProductPriceRepository, diffs []PriceDiff, resumeAfter int, done func(line int) error) error {
+	for _, diff := range diffs {
+		line := diff.Row.Line
+		if line <= resumeAfter {
+			continue
+		}
+
+		if diff.Action != ImportUnchanged {
+			if err := repository.Put(ctx, diff.Row.Price); err != nil {
+				return &PriceImportError{Line: line, Reason: err.Error()}
+			}
+		}
+
+		if err := done(line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Formats prices can be exported in
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+)
+
+// exportedPrice is one price of an NDJSON export, in the form taken by bulk
+// price updates
+type exportedPrice struct {
+	ProductID int `json:"product_id"`
+	ProductPrice
+}
+
+// ExportPrices writes every stored price in format, returning how many it
+// wrote. CSV exports can be imported again as they are.
+func ExportPrices(ctx context.Context, repository ProductPriceRepository, w io.Writer, format string) (int, error) {
+	var write func(price ProductPrice) error
+	var flush func() error
+
+	switch format {
+	case ExportCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(priceColumns); err != nil {
+			return 0, err
+		}
+
+		write = func(price ProductPrice) error {
+			return writer.Write([]string{
+				strconv.Itoa(price.ProductID),
+				price.Price.String(),
+				price.CurrencyCode,
+				formatOptionalTime(price.EffectiveFrom),
+				formatOptionalTime(price.EffectiveTo),
+			})
+		}
+
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+
+	case ExportNDJSON:
+		enc := json.NewEncoder(w)
+		write = func(price ProductPrice) error {
+			return enc.Encode(exportedPrice{ProductID: price.ProductID, ProductPrice: price})
+		}
+
+		flush = func() error {
+			return nil
+		}
+
+	default:
+		return 0, fmt.Errorf("unknown export format %q, want %s or %s", format, ExportCSV, ExportNDJSON)
+	}
+
+	count := 0
+	query := PriceListQuery{PageSize: MaxPriceListPageSize}
+	for {
+		page, err := repository.List(ctx, query)
+		if err != nil {
+			return count, err
+		}
+
+		for _, price := range page.Prices {
+			if err := write(price); err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		if page.NextPageToken == "" {
+			return count, flush()
+		}
+
+		query.PageToken = page.NextPageToken
+	}
+}
+
+// formatOptionalTime formats t as RFC 3339, or as empty when it is nil
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
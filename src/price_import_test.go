@@ -0,0 +1,314 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+var readPriceCSVTests = []struct {
+	name     string
+	csv      string
+	wantRows string
+	wantErrs string
+}{
+	{
+		name:     "Valid",
+		csv:      "product_id,price,currency\n13860428,13.49,usd\n\n54456119, 9.5 ,EUR\n",
+		wantRows: "2:13860428 13.49 USD; 4:54456119 9.50 EUR",
+	},
+	{
+		name:     "Columns in any order, with a schedule",
+		csv:      "Currency,product_id,effective_from,price,effective_to\r\nUSD,1,2030-01-01T00:00:00Z,5,\r\n",
+		wantRows: "2:1 5.00 USD from 2030-01-01T00:00:00Z",
+	},
+	{
+		name: "Invalid lines",
+		csv: "product_id,price,currency\n" +
+			"one,1,USD\n" +
+			"2,abc,USD\n" +
+			"3,-1,USD\n" +
+			"4,1.234,USD\n" +
+			"5,1,XYZ\n" +
+			"6,1\n" +
+			"7,\"1\n.00\",USD\n" +
+			"8,1,USD\n" +
+			"8,2,usd\n",
+		wantRows: "10:8 1.00 USD",
+		wantErrs: `line 2: product_id "one" is not an integer; ` +
+			`line 3: price "abc" is not a decimal number; ` +
+			`line 4: price: must not be negative; ` +
+			`line 5: price: USD allows at most 2 decimal places; ` +
+			`line 6: currency: "XYZ" is not an ISO 4217 currency code; ` +
+			`line 7: has 2 fields, want 3; ` +
+			`line 8: price "1\n.00" is not a decimal number; ` +
+			`line 11: repeats the price on line 10`,
+	},
+	{
+		name:     "Malformed line",
+		csv:      "product_id,price,currency\n1,\"2\"x,USD\n3,4,USD\n",
+		wantRows: "3:3 4.00 USD",
+		wantErrs: `line 2: extraneous or missing " in quoted-field`,
+	},
+	{
+		name:     "Unknown column",
+		csv:      "product_id,price,currency,colour\n1,2,USD,red\n",
+		wantErrs: `line 1: unknown column "colour", want product_id, price, currency, effective_from, effective_to`,
+	},
+	{
+		name:     "Missing column",
+		csv:      "product_id,price\n1,2\n",
+		wantErrs: `line 1: missing column "currency"`,
+	},
+	{
+		name:     "Empty",
+		csv:      "",
+		wantErrs: "line 1: file is empty",
+	},
+}
+
+func TestReadPriceCSV(t *testing.T) {
+	for _, tt := range readPriceCSVTests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, errs := ReadPriceCSV(strings.NewReader(tt.csv))
+
+			gotRows := make([]string, len(rows))
+			for i, row := range rows {
+				gotRows[i] = fmt.Sprintf("%d:%d %s %s", row.Line, row.Price.ProductID, row.Price.Price, row.Price.CurrencyCode)
+				if row.Price.EffectiveFrom != nil {
+					gotRows[i] += " from " + row.Price.EffectiveFrom.Format(time.RFC3339)
+				}
+			}
+
+			gotErrs := make([]string, len(errs))
+			for i, err := range errs {
+				gotErrs[i] = err.Error()
+			}
+
+			if got := strings.Join(gotRows, "; "); got != tt.wantRows {
+				t.Errorf("got rows %q, want %q", got, tt.wantRows)
+			}
+
+			if got := strings.Join(gotErrs, "; "); got != tt.wantErrs {
+				t.Errorf("got errors %q, want %q", got, tt.wantErrs)
+			}
+		})
+	}
+}
+
+func helperImportRepository(t *testing.T) *InMemoryProductPriceRepository {
+	from := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	return helperPriceRepository(t, nil,
+		ProductPrice{ProductID: 1, Price: MustParseMoney("10.00"), CurrencyCode: "USD"},
+		ProductPrice{ProductID: 1, Price: MustParseMoney("8.00"), CurrencyCode: "USD", EffectiveFrom: &from},
+		ProductPrice{ProductID: 2, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"},
+	)
+}
+
+func TestDiffPrices(t *testing.T) {
+	repository := helperImportRepository(t)
+
+	rows, errs := ReadPriceCSV(strings.NewReader("product_id,price,currency,effective_from\n" +
+		"1,9.00,USD,\n" +
+		"1,8.00,USD,2030-01-01T00:00:00Z\n" +
+		"1,7.00,EUR,\n" +
+		"2,12.5,EUR,\n" +
+		"3,1.00,USD,\n"))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	diffs, err := DiffPrices(context.Background(), repository, rows)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got := make([]string, len(diffs))
+	for i, diff := range diffs {
+		got[i] = fmt.Sprintf("%d %s", diff.Row.Line, diff.Action)
+		if diff.Old != nil {
+			got[i] += " from " + diff.Old.Price.String()
+		}
+	}
+
+	want := "2 update from 10.00; 3 unchanged from 8.00; 4 add; 5 unchanged from 12.50; 6 add"
+	if strings.Join(got, "; ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, "; "), want)
+	}
+}
+
+// failingPriceRepository fails Puts of one product
+type failingPriceRepository struct {
+	*InMemoryProductPriceRepository
+	failProductID int
+}
+
+func (f failingPriceRepository) Put(ctx context.Context, price ProductPrice) error {
+	if price.ProductID == f.failProductID {
+		return errors.New("datastore unavailable")
+	}
+
+	return f.InMemoryProductPriceRepository.Put(ctx, price)
+}
+
+func TestImportPricesResumes(t *testing.T) {
+	repository := failingPriceRepository{InMemoryProductPriceRepository: helperImportRepository(t), failProductID: 4}
+	ctx := context.Background()
+
+	rows, _ := ReadPriceCSV(strings.NewReader("product_id,price,currency\n1,9.00,USD\n2,12.50,EUR\n4,1.00,USD\n5,2.00,USD\n"))
+	diffs, err := DiffPrices(ctx, repository, rows)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var done []int
+	record := func(line int) error {
+		done = append(done, line)
+		return nil
+	}
+
+	err = ImportPrices(ctx, repository, diffs, 0, record)
+	var importErr *PriceImportError
+	if !errors.As(err, &importErr) || importErr.Line != 4 {
+		t.Fatalf("got %+v, want a failure on line 4", err)
+	}
+
+	if fmt.Sprint(done) != "[2 3]" {
+		t.Errorf("got lines %v done, want [2 3]", done)
+	}
+
+	repository.failProductID = 0
+	done = nil
+	if err := ImportPrices(ctx, repository, diffs, 3, record); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if fmt.Sprint(done) != "[4 5]" {
+		t.Errorf("got lines %v done, want [4 5]", done)
+	}
+
+	// The unchanged price was not put again
+	history, _ := repository.History(ctx, 2, PriceHistoryQuery{})
+	if len(history.Changes) != 1 {
+		t.Errorf("got %d changes to product 2, want 1", len(history.Changes))
+	}
+
+	price, err := repository.Get(ctx, 5, PriceQuery{})
+	if err != nil || price.Price.String() != "2.00" {
+		t.Errorf("got %+v %+v, want 2.00 USD", price, err)
+	}
+}
+
+func TestExportPrices(t *testing.T) {
+	repository := helperImportRepository(t)
+
+	var csv strings.Builder
+	count, err := ExportPrices(context.Background(), repository, &csv, ExportCSV)
+	if err != nil || count != 3 {
+		t.Fatalf("got %d, %+v, want 3 prices", count, err)
+	}
+
+	wantCSV := "product_id,price,currency,effective_from,effective_to\n" +
+		"1,10.00,USD,,\n" +
+		"1,8.00,USD,2030-01-01T00:00:00Z,\n" +
+		"2,12.50,EUR,,\n"
+	if csv.String() != wantCSV {
+		t.Errorf("got %q, want %q", csv.String(), wantCSV)
+	}
+
+	// An export imports again without changes
+	rows, errs := ReadPriceCSV(strings.NewReader(csv.String()))
+	diffs, err := DiffPrices(context.Background(), repository, rows)
+	if len(errs) > 0 || err != nil || len(diffs) != 3 {
+		t.Fatalf("got %v %+v, want 3 rows", errs, err)
+	}
+
+	for _, diff := range diffs {
+		if diff.Action != ImportUnchanged {
+			t.Errorf("got %s for line %d, want unchanged", diff.Action, diff.Row.Line)
+		}
+	}
+
+	var ndjson strings.Builder
+	if _, err := ExportPrices(context.Background(), repository, &ndjson, ExportNDJSON); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	wantNDJSON := `{"product_id":1,"value":10.00,"currency_code":"USD"}` + "\n" +
+		`{"product_id":1,"value":8.00,"currency_code":"USD","effective_from":"2030-01-01T00:00:00Z"}` + "\n" +
+		`{"product_id":2,"value":12.50,"currency_code":"EUR"}` + "\n"
+	if ndjson.String() != wantNDJSON {
+		t.Errorf("got %q, want %q", ndjson.String(), wantNDJSON)
+	}
+
+	if _, err := ExportPrices(context.Background(), repository, &ndjson, "xlsx"); err == nil {
+		t.Errorf("got nil, want an error for an unknown format")
+	}
+}
+
+func TestInMemoryProductPriceRepositoryList(t *testing.T) {
+	repository := NewInMemoryProductPriceRepository()
+	ctx := context.Background()
+	for _, productID := range []int{3, 1, 2} {
+		repository.Put(ctx, ProductPrice{ProductID: productID, Price: MustParseMoney("1.00"), CurrencyCode: "USD"})
+	}
+
+	var got []int
+	query := PriceListQuery{PageSize: 2}
+	for pages := 0; ; pages++ {
+		if pages > 2 {
+			t.Fatalf("got more pages than expected")
+		}
+
+		page, err := repository.List(ctx, query)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		for _, price := range page.Prices {
+			got = append(got, price.ProductID)
+		}
+
+		if page.NextPageToken == "" {
+			break
+		}
+		query.PageToken = page.NextPageToken
+	}
+
+	if fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("got %v, want [1 2 3]", got)
+	}
+
+	page, err := repository.List(ctx, PriceListQuery{ProductIDs: []int{3, 4, 1}})
+	if err != nil || len(page.Prices) != 2 || page.Prices[0].ProductID != 3 || page.Prices[1].ProductID != 1 {
+		t.Errorf("got %+v %+v, want products 3 and 1", page, err)
+	}
+
+	if _, err := repository.List(ctx, PriceListQuery{PageToken: "?"}); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("got %+v, want %+v", err, ErrInvalidPageToken)
+	}
+}
+
+func TestProductPriceRepositoryListProducts(t *testing.T) {
+	client := newPropertyDatastoreClient()
+	repository := helperPropertyRepository(t, client)
+	ctx := context.Background()
+
+	repository.Put(ctx, ProductPrice{ProductID: 1, Price: MustParseMoney("1.00"), CurrencyCode: "USD"})
+	repository.Put(ctx, ProductPrice{ProductID: 1, Price: MustParseMoney("0.90"), CurrencyCode: "EUR"})
+
+	page, err := repository.List(ctx, PriceListQuery{ProductIDs: []int{2, 1}})
+	if err != nil || len(page.Prices) != 2 {
+		t.Fatalf("got %+v %+v, want both prices of product 1", page, err)
+	}
+
+	for _, price := range page.Prices {
+		if price.ProductID != 1 || price.Version != 2 {
+			t.Errorf("got %+v, want product 1 at version 2", price)
+		}
+	}
+}
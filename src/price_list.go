@@ -0,0 +1,79 @@
+package productaggregate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Price list page sizes, counted in products
+const (
+	DefaultPriceListPageSize = 100
+	MaxPriceListPageSize     = 500
+)
+
+// PriceListQuery selects a page of stored prices
+type PriceListQuery struct {
+	// ProductIDs limits the list to those products, which are all listed
+	// on one page. At most MaxPriceListPageSize may be given.
+	ProductIDs []int
+
+	// PageSize is the most products listed at once. DefaultPriceListPageSize
+	// is used when it is zero, and it is capped at MaxPriceListPageSize.
+	PageSize int
+
+	// PageToken continues from an earlier page's NextPageToken
+	PageToken string
+}
+
+func (q PriceListQuery) pageSize() int {
+	switch {
+	case q.PageSize <= 0:
+		return DefaultPriceListPageSize
+
+	case q.PageSize > MaxPriceListPageSize:
+		return MaxPriceListPageSize
+
+	default:
+		return q.PageSize
+	}
+}
+
+// validate checks that the query does not ask for too many products at once
+func (q PriceListQuery) validate() error {
+	if len(q.ProductIDs) > MaxPriceListPageSize {
+		return fmt.Errorf("at most %d products may be listed at once, got %d", MaxPriceListPageSize, len(q.ProductIDs))
+	}
+
+	return nil
+}
+
+// PriceListPage is one page of stored prices, with every price of each
+// product listed together. NextPageToken is empty on the last page.
+type PriceListPage struct {
+	Prices        []ProductPrice
+	NextPageToken string
+}
+
+// storedPrices returns every price the entity holds, in every currency and
+// window, or none when they are deleted
+func (e *priceEntity) storedPrices() []ProductPrice {
+	if e.deleted() {
+		return nil
+	}
+
+	prices := make([]ProductPrice, len(e.Prices))
+	for i, price := range e.Prices {
+		price.ProductID = e.ProductID
+		price.Version = e.Version
+		price.UpdatedAt = e.UpdatedAt
+		prices[i] = price
+	}
+
+	return prices
+}
+
+// productIDFromKey reads the product ID back from a product's key name
+func productIDFromKey(name string) (int, error) {
+	return strconv.Atoi(strings.TrimPrefix(name, "product_"))
+}
@@ -0,0 +1,372 @@
+package productaggregate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/gddo/httputil/header"
+)
+
+// Media types of the patches a PATCH accepts
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// maxPatchAttempts is how many times a patch is applied to a price which
+// keeps changing between being read and being written
+const maxPatchAttempts = 3
+
+// errPatchContention is returned when a price changed every time it was
+// patched
+var errPatchContention = errors.New("product price kept changing while it was patched")
+
+// Errors for PATCH bodies, suitable for clients
+var (
+	errNotJSONPatch      = errors.New("Request body must be a JSON array of JSON Patch operations")
+	errTrailingPatchData = errors.New("Request body must only contain a single JSON value")
+)
+
+// pricePatch changes the JSON document of a price, decoded into interface{}
+// values
+type pricePatch interface {
+	apply(doc interface{}) (interface{}, error)
+}
+
+// mergePatch is a JSON Merge Patch document
+type mergePatch struct {
+	patch interface{}
+}
+
+func (m mergePatch) apply(doc interface{}) (interface{}, error) {
+	return applyMergePatch(doc, m.patch), nil
+}
+
+// jsonPatch is a JSON Patch document
+type jsonPatch []jsonPatchOperation
+
+func (j jsonPatch) apply(doc interface{}) (interface{}, error) {
+	return applyJSONPatch(doc, j)
+}
+
+// patchedPriceError is returned when a patch leaves a price which is not
+// a valid PUT body. Its message is suitable for clients.
+type patchedPriceError struct {
+	reasons []string
+}
+
+func (e *patchedPriceError) Error() string {
+	return "Patched price is invalid: " + strings.Join(e.reasons, "; ")
+}
+
+// readPricePatch decodes a PATCH body of the given media type
+func readPricePatch(body io.Reader, mediaType string) (pricePatch, error) {
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+
+	var patch pricePatch
+	var err error
+	switch mediaType {
+	case mergePatchType:
+		var m mergePatch
+		err = dec.Decode(&m.patch)
+		patch = m
+
+	default:
+		var j jsonPatch
+		err = dec.Decode(&j)
+		patch = j
+
+		var unmarshalTypeError *json.UnmarshalTypeError
+		if errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field == "" {
+			return nil, errNotJSONPatch
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if dec.More() {
+		return nil, errTrailingPatchData
+	}
+
+	return patch, nil
+}
+
+// applyPricePatch patches the JSON document of a price, as a GET returns
+// it, and validates the result as the body of a PUT
+func applyPricePatch(price ProductPrice, patch pricePatch) (ProductPrice, error) {
+	price.Conversion = nil
+	data, err := json.Marshal(price)
+	if err != nil {
+		return ProductPrice{}, err
+	}
+
+	patched, err := patch.apply(decodeJSONValue(data))
+	if err != nil {
+		return ProductPrice{}, err
+	}
+
+	data, err = json.Marshal(patched)
+	if err != nil {
+		return ProductPrice{}, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var update priceUpdate
+	if err := dec.Decode(&update); err != nil {
+		return ProductPrice{}, &patchedPriceError{reasons: []string{patchedDecodeReason(err)}}
+	}
+
+	result, fieldErrs := update.toProductPrice(price.ProductID)
+	if len(fieldErrs) > 0 {
+		reasons := make([]string, len(fieldErrs))
+		for i, fieldErr := range fieldErrs {
+			reasons[i] = fmt.Sprintf("%q %s", fieldErr.Field, fieldErr.Reason)
+		}
+		return ProductPrice{}, &patchedPriceError{reasons: reasons}
+	}
+
+	return result, nil
+}
+
+// patchedDecodeReason explains why a patched price could not be decoded
+func patchedDecodeReason(err error) string {
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var moneyError *MoneyError
+	var timeParseError *time.ParseError
+
+	switch {
+	case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field == "":
+		return "must be an object"
+
+	case errors.As(err, &unmarshalTypeError):
+		return fmt.Sprintf("%q must not be a %s", unmarshalTypeError.Field, unmarshalTypeError.Value)
+
+	case errors.As(err, &moneyError):
+		return fmt.Sprintf("%q %s", "value", moneyError.Reason)
+
+	case errors.As(err, &timeParseError):
+		return fmt.Sprintf("invalid timestamp %s, want RFC 3339", timeParseError.Value)
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return "unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+
+	default:
+		return err.Error()
+	}
+}
+
+// replacePrice swaps the price in original's currency and window for price,
+// and returns the price replaced. Unlike with setPrice, price may move to
+// another currency or window, so it is checked for conflicts against every
+// other price: another in its currency with the same window, or a scheduled
+// one overlapping it. The entity is left alone when the price cannot be
+// replaced. Expired scheduled prices are dropped and returned, and the
+// version is checked and incremented, as by setPrice. The base currency follows a price moved
+// out of it, unless other prices are left in it.
+func (e *priceEntity) replacePrice(original ProductPrice, price ProductPrice, now time.Time) (ProductPrice, []ProductPrice, error) {
+	if e.deleted() {
+		return ProductPrice{}, nil, &PriceDeletedError{DeletedAt: e.DeletedAt, DeletedBy: e.DeletedBy}
+	}
+
+	if err := e.checkVersion(price.Version); err != nil {
+		return ProductPrice{}, nil, err
+	}
+
+	price.Version = 0
+	price.UpdatedAt = time.Time{}
+
+	var replaced *ProductPrice
+	var expired []ProductPrice
+	prices := make([]ProductPrice, 0, len(e.Prices))
+	for _, existing := range e.Prices {
+		if replaced == nil && existing.CurrencyCode == original.CurrencyCode && existing.sameWindow(original) {
+			found := existing
+			found.ProductID = e.ProductID
+			replaced = &found
+			prices = append(prices, price)
+			continue
+		}
+
+		if existing.expiredAt(now) {
+			existing.ProductID = e.ProductID
+			expired = append(expired, existing)
+			continue
+		}
+
+		if existing.CurrencyCode == price.CurrencyCode {
+			clash := existing.sameWindow(price) || (price.scheduled() && existing.scheduled() && price.overlaps(existing))
+			if clash {
+				existing.ProductID = e.ProductID
+				return ProductPrice{}, nil, &PriceConflictError{Existing: existing}
+			}
+		}
+
+		prices = append(prices, existing)
+	}
+
+	if replaced == nil {
+		return ProductPrice{}, nil, ErrCurrencyNotFound
+	}
+
+	baseLeft := false
+	for _, remaining := range prices {
+		if remaining.CurrencyCode == e.BaseCurrency {
+			baseLeft = true
+		}
+	}
+
+	if !baseLeft {
+		e.BaseCurrency = price.CurrencyCode
+	}
+
+	e.Prices = prices
+	e.Version++
+	e.UpdatedAt = now.UTC()
+	return *replaced, expired, nil
+}
+
+// patchPrice patches the price a GET with the query returns, and replaces
+// it with the result, which may be in another currency or window. The
+// patched price is only set if the product's prices have not changed since
+// it was read; if they have, the patch is applied again to the new price.
+// When version is set, the prices must also still be at that version.
+func patchPrice(ctx context.Context, repository ProductPriceRepository, productID int, query PriceQuery, version int64, patch pricePatch) error {
+	for attempt := 1; ; attempt++ {
+		current, err := repository.Get(ctx, productID, query)
+		if err != nil {
+			return err
+		}
+
+		// Converted prices are not stored, so there is nothing to patch
+		if current.Conversion != nil {
+			return ErrCurrencyNotFound
+		}
+
+		current.ProductID = productID
+		price, err := applyPricePatch(*current, patch)
+		if err != nil {
+			return err
+		}
+
+		price.Version = version
+		retry := version == 0 || version == AnyVersion
+		if retry {
+			price.Version = current.Version
+		}
+
+		err = repository.Replace(ctx, *current, price)
+		if !retry || !errors.Is(err, ErrVersionMismatch) {
+			return err
+		}
+
+		if attempt == maxPatchAttempts {
+			return errPatchContention
+		}
+	}
+}
+
+// HandlePatch handles product PATCH requests, which change part of a price
+// rather than replacing it. The price patched is the one a GET with the
+// same currency and at parameters would return.
+func (rh RequestHandler) HandlePatch(w http.ResponseWriter, r *http.Request) {
+	productID, ok := rh.productID(w, r)
+	if !ok {
+		return
+	}
+
+	version, ok := rh.expectedVersion(w, r, productID)
+	if !ok {
+		return
+	}
+
+	mediaType, _ := header.ParseValueAndParams(r.Header, "Content-Type")
+	if mediaType != mergePatchType && mediaType != jsonPatchType {
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		msg := "Content-Type header is not " + mergePatchType + " or " + jsonPatchType
+		http.Error(w, msg, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	query, err := parseProductQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	patch, err := readPricePatch(r.Body, mediaType)
+	if errors.Is(err, errNotJSONPatch) || errors.Is(err, errTrailingPatchData) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		status, msg := decodeErrorResponse(err)
+		http.Error(w, msg, status)
+		return
+	}
+
+	if operations, ok := patch.(jsonPatch); ok {
+		for i, operation := range operations {
+			if err := operation.validate(); err != nil {
+				msg := fmt.Sprintf("Request body contains an invalid JSON Patch operation %d: %s", i, err)
+				http.Error(w, msg, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	ctx, cancel := sourceContext(r.Context(), rh.priceTimeout)
+	defer cancel()
+
+	ctx = WithActor(ctx, r.Header.Get(actorHeader))
+
+	err = patchPrice(ctx, rh.priceRepository, productID, PriceQuery{CurrencyCode: query.CurrencyCode, At: query.At}, version, patch)
+
+	var patchErr *patchError
+	var patchedErr *patchedPriceError
+	var conflict *PriceConflictError
+	var mismatch *VersionMismatchError
+	switch {
+	case errors.Is(err, ErrPriceNotFound):
+		msg := "Product price not found"
+		http.Error(w, msg, http.StatusNotFound)
+
+	case errors.Is(err, errPatchTestFailed):
+		http.Error(w, err.Error(), http.StatusConflict)
+
+	case errors.As(err, &patchErr), errors.As(err, &patchedErr):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+
+	case errors.As(err, &conflict):
+		msg := fmt.Sprintf("Price conflicts with the %s price %s", conflict.Existing.CurrencyCode, DescribeWindow(conflict.Existing))
+		http.Error(w, msg, http.StatusConflict)
+
+	case errors.As(err, &mismatch):
+		rh.writeVersionMismatch(w, r, productID, mismatch)
+
+	case errors.Is(err, errPatchContention):
+		msg := "Product price kept changing while it was patched, try again"
+		http.Error(w, msg, http.StatusConflict)
+
+	case err != nil:
+		msg := "Error updating product"
+		http.Error(w, msg, http.StatusInternalServerError)
+		log.Printf("Failed patching product %d price: %s", productID, err)
+
+	default:
+		fmt.Fprint(w, "Product updated")
+	}
+}
@@ -0,0 +1,513 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var patchRequestTests = []struct {
+	name        string
+	path        string
+	contentType string
+	ifMatch     string
+	body        string
+	wantCode    int
+	wantBody    string
+	wantAccept  string
+
+	// prices are set before the PATCH, besides the USD price of 13.49
+	prices []ProductPrice
+
+	// getPath is fetched after the PATCH, to check the price it set, and
+	// gonePath to check that the price patched was replaced
+	getPath  string
+	getBody  string
+	gonePath string
+	goneBody string
+}{
+	{
+		name:        "Merge patch of the value",
+		path:        "/products/123",
+		contentType: mergePatchType,
+		body:        `{"value": 12.99}`,
+		wantCode:    http.StatusOK,
+		wantBody:    "Product updated",
+		getPath:     "/products/123/price",
+		getBody:     `{"product_id":123,"current_price":{"value":12.99,"currency_code":"USD"}}`,
+	},
+	{
+		name:        "Merge patch of the currency",
+		path:        "/v1/products/123/price",
+		contentType: mergePatchType + "; charset=utf-8",
+		body:        `{"currency_code": "eur"}`,
+		wantCode:    http.StatusOK,
+		wantBody:    "Product updated",
+		getPath:     "/products/123/price",
+		getBody:     `{"product_id":123,"current_price":{"value":13.49,"currency_code":"EUR"}}`,
+		gonePath:    "/products/123/price?currency=USD",
+		goneBody:    "Product not found\n",
+	},
+	{
+		name:        "Merge patch of the currency to one already priced",
+		path:        "/products/123",
+		contentType: mergePatchType,
+		body:        `{"currency_code": "EUR"}`,
+		prices:      []ProductPrice{{ProductID: 123, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"}},
+		wantCode:    http.StatusConflict,
+		wantBody:    "Price conflicts with the EUR price effective from the beginning until indefinitely\n",
+		getPath:     "/products/123/price",
+		getBody:     `{"product_id":123,"current_price":{"value":13.49,"currency_code":"USD"}}`,
+	},
+	{
+		name:        "Merge patch scheduling a price",
+		path:        "/products/123",
+		contentType: mergePatchType,
+		body:        `{"value": 9.99, "effective_from": "2030-01-01T00:00:00Z"}`,
+		wantCode:    http.StatusOK,
+		wantBody:    "Product updated",
+		getPath:     "/products/123/price?at=2030-01-02T00:00:00Z",
+		getBody:     `{"product_id":123,"current_price":{"value":9.99,"currency_code":"USD","effective_from":"2030-01-01T00:00:00Z"}}`,
+		gonePath:    "/products/123/price",
+		goneBody:    "Product not found\n",
+	},
+	{
+		name:        "Merge patch extending a scheduled price",
+		path:        "/products/123?at=2030-01-05T00:00:00Z",
+		contentType: mergePatchType,
+		body:        `{"effective_to": "2030-03-01T00:00:00Z"}`,
+		prices:      []ProductPrice{helperScheduledPrice("10.99", "2030-01-01T00:00:00Z", "2030-02-01T00:00:00Z")},
+		wantCode:    http.StatusOK,
+		wantBody:    "Product updated",
+		getPath:     "/products/123/price?at=2030-02-15T00:00:00Z",
+		getBody:     `{"product_id":123,"current_price":{"value":10.99,"currency_code":"USD","effective_from":"2030-01-01T00:00:00Z","effective_to":"2030-03-01T00:00:00Z"}}`,
+		gonePath:    "/products/123/price",
+		goneBody:    `{"product_id":123,"current_price":{"value":13.49,"currency_code":"USD"}}`,
+	},
+	{
+		name:        "Merge patch removing the value",
+		path:        "/products/123",
+		contentType: mergePatchType,
+		body:        `{"value": null}`,
+		wantCode:    http.StatusUnprocessableEntity,
+		wantBody:    "Patched price is invalid: \"value\" missing\n",
+	},
+	{
+		name:        "Merge patch with too many decimal places",
+		path:        "/products/123",
+		contentType: mergePatchType,
+		body:        `{"value": 1.234}`,
+		wantCode:    http.StatusUnprocessableEntity,
+		wantBody:    "Patched price is invalid: \"value\" USD allows at most 2 decimal places\n",
+	},
+	{
+		name:        "Merge patch adding a field",
+		path:        "/products/123",
+		contentType: mergePatchType,
+		body:        `{"colour": "red"}`,
+		wantCode:    http.StatusUnprocessableEntity,
+		wantBody:    "Patched price is invalid: unknown field \"colour\"\n",
+	},
+	{
+		name:        "Merge patch replacing the price",
+		path:        "/products/123",
+		contentType: mergePatchType,
+		body:        `[1]`,
+		wantCode:    http.StatusUnprocessableEntity,
+		wantBody:    "Patched price is invalid: must be an object\n",
+	},
+	{
+		name:        "JSON Patch",
+		path:        "/products/123",
+		contentType: jsonPatchType,
+		body:        `[{"op": "test", "path": "/value", "value": 13.49}, {"op": "replace", "path": "/value", "value": "14.00"}]`,
+		wantCode:    http.StatusOK,
+		wantBody:    "Product updated",
+		getPath:     "/products/123/price",
+		getBody:     `{"product_id":123,"current_price":{"value":14.00,"currency_code":"USD"}}`,
+	},
+	{
+		name:        "JSON Patch test failing",
+		path:        "/products/123",
+		contentType: jsonPatchType,
+		body:        `[{"op": "test", "path": "/value", "value": 10}, {"op": "replace", "path": "/value", "value": 14}]`,
+		wantCode:    http.StatusConflict,
+		wantBody:    "JSON Patch operation 0 (test): /value: test failed\n",
+		getPath:     "/products/123/price",
+		getBody:     `{"product_id":123,"current_price":{"value":13.49,"currency_code":"USD"}}`,
+	},
+	{
+		name:        "JSON Patch of a missing member",
+		path:        "/products/123",
+		contentType: jsonPatchType,
+		body:        `[{"op": "replace", "path": "/effective_to", "value": "2030-01-01T00:00:00Z"}]`,
+		wantCode:    http.StatusUnprocessableEntity,
+		wantBody:    "JSON Patch operation 0 (replace): /effective_to: does not exist\n",
+	},
+	{
+		name:        "Invalid JSON Patch operation",
+		path:        "/products/123",
+		contentType: jsonPatchType,
+		body:        `[{"op": "replace", "value": 14}]`,
+		wantCode:    http.StatusBadRequest,
+		wantBody:    "Request body contains an invalid JSON Patch operation 0: missing \"path\"\n",
+	},
+	{
+		name:        "JSON Patch which is not an array",
+		path:        "/products/123",
+		contentType: jsonPatchType,
+		body:        `{"value": 14}`,
+		wantCode:    http.StatusBadRequest,
+		wantBody:    "Request body must be a JSON array of JSON Patch operations\n",
+	},
+	{
+		name:        "Malformed patch",
+		path:        "/products/123",
+		contentType: mergePatchType,
+		body:        `{"value": 14`,
+		wantCode:    http.StatusBadRequest,
+		wantBody:    "Request body contains badly-formed JSON\n",
+	},
+	{
+		name:        "Several patches",
+		path:        "/products/123",
+		contentType: mergePatchType,
+		body:        `{"value": 14} {"value": 15}`,
+		wantCode:    http.StatusBadRequest,
+		wantBody:    "Request body must only contain a single JSON value\n",
+	},
+	{
+		name:        "Unsupported patch format",
+		path:        "/products/123",
+		contentType: "application/json",
+		body:        `{"value": 14}`,
+		wantCode:    http.StatusUnsupportedMediaType,
+		wantBody:    "Content-Type header is not application/merge-patch+json or application/json-patch+json\n",
+		wantAccept:  "application/merge-patch+json, application/json-patch+json",
+	},
+	{
+		name:        "Product without a price",
+		path:        "/products/456",
+		contentType: mergePatchType,
+		body:        `{"value": 14}`,
+		wantCode:    http.StatusNotFound,
+		wantBody:    "Product price not found\n",
+	},
+	{
+		name:        "Currency without a price",
+		path:        "/products/123?currency=EUR",
+		contentType: mergePatchType,
+		body:        `{"value": 14}`,
+		wantCode:    http.StatusNotFound,
+		wantBody:    "Product price not found\n",
+	},
+	{
+		name:        "Current version",
+		path:        "/products/123",
+		contentType: mergePatchType,
+		ifMatch:     "GET",
+		body:        `{"value": 14}`,
+		wantCode:    http.StatusOK,
+		wantBody:    "Product updated",
+	},
+	{
+		name:        "Stale version",
+		path:        "/products/123",
+		contentType: mergePatchType,
+		ifMatch:     `"7-0000000000000000"`,
+		body:        `{"value": 14}`,
+		wantCode:    http.StatusPreconditionFailed,
+		wantBody:    "Product price has changed since it was read\n",
+	},
+}
+
+func TestRequestHandlerPatch(t *testing.T) {
+	for _, tt := range patchRequestTests {
+		t.Run(tt.name, func(t *testing.T) {
+			rh := helperRouterHandler(t)
+			for _, price := range tt.prices {
+				if err := rh.priceRepository.Put(context.Background(), price); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			r := httptest.NewRequest("PATCH", "http://example.com"+tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", strings.Replace(tt.ifMatch, "GET", helperGetETag(t, rh, 123), 1))
+			}
+
+			w := httptest.NewRecorder()
+			rh.HandleRequest(w, r)
+
+			if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+			}
+
+			if accept := w.Header().Get("Accept-Patch"); accept != tt.wantAccept {
+				t.Errorf("got Accept-Patch %q, want %q", accept, tt.wantAccept)
+			}
+
+			if tt.getPath == "" {
+				return
+			}
+
+			w = httptest.NewRecorder()
+			rh.HandleRequest(w, httptest.NewRequest("GET", "http://example.com"+tt.getPath, nil))
+			if w.Body.String() != tt.getBody {
+				t.Errorf("got %s after the patch, want %s", w.Body.String(), tt.getBody)
+			}
+
+			if tt.gonePath == "" {
+				return
+			}
+
+			w = httptest.NewRecorder()
+			rh.HandleRequest(w, httptest.NewRequest("GET", "http://example.com"+tt.gonePath, nil))
+			if w.Body.String() != tt.goneBody {
+				t.Errorf("got %s for the price patched, want %s", w.Body.String(), tt.goneBody)
+			}
+		})
+	}
+}
+
+// helperScheduledPrice makes a USD price for product 123 effective from
+// from until to
+func helperScheduledPrice(value string, from string, to string) ProductPrice {
+	effectiveFrom, _ := time.Parse(time.RFC3339, from)
+	effectiveTo, _ := time.Parse(time.RFC3339, to)
+
+	return ProductPrice{
+		ProductID:     123,
+		Price:         MustParseMoney(value),
+		CurrencyCode:  "USD",
+		EffectiveFrom: &effectiveFrom,
+		EffectiveTo:   &effectiveTo,
+	}
+}
+
+// racingPriceRepository changes the price before each of the first races
+// Replaces, as another client would
+type racingPriceRepository struct {
+	*InMemoryProductPriceRepository
+	races *int
+}
+
+func (r racingPriceRepository) Replace(ctx context.Context, original ProductPrice, price ProductPrice) error {
+	if *r.races > 0 {
+		*r.races--
+		r.InMemoryProductPriceRepository.Put(ctx, ProductPrice{ProductID: price.ProductID, Price: MustParseMoney("20.00"), CurrencyCode: "USD"})
+	}
+
+	return r.InMemoryProductPriceRepository.Replace(ctx, original, price)
+}
+
+func TestPatchPriceRetries(t *testing.T) {
+	ctx := context.Background()
+	patch := jsonPatch{{Op: "add", Path: new(string), Value: []byte(`{"value": 1, "currency_code": "USD"}`)}}
+
+	tests := []struct {
+		name      string
+		races     int
+		version   int64
+		wantErr   error
+		wantValue string
+	}{
+		{"Raced once", 1, 0, nil, "1.00"},
+		{"Raced once with any version", 1, AnyVersion, nil, "1.00"},
+		{"Raced every time", maxPatchAttempts, 0, errPatchContention, "20.00"},
+		{"Raced with a version", 1, 1, ErrVersionMismatch, "20.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			races := tt.races
+			repository := racingPriceRepository{InMemoryProductPriceRepository: NewInMemoryProductPriceRepository(), races: &races}
+			repository.InMemoryProductPriceRepository.Put(ctx, ProductPrice{ProductID: 1, Price: MustParseMoney("10.00"), CurrencyCode: "USD"})
+
+			err := patchPrice(ctx, repository, 1, PriceQuery{}, tt.version, patch)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %+v, want %+v", err, tt.wantErr)
+			}
+
+			price, _ := repository.Get(ctx, 1, PriceQuery{})
+			if price.Price.String() != tt.wantValue {
+				t.Errorf("got %s, want %s", price.Price, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestPatchPriceRejectsConvertedPrices(t *testing.T) {
+	repository := StubPriceRepository{pgr: priceGetResult{price: &ProductPrice{
+		Price:        MustParseMoney("12.00"),
+		CurrencyCode: "EUR",
+		Conversion:   &PriceConversion{FromValue: MustParseMoney("13.49"), FromCurrencyCode: "USD"},
+	}}}
+
+	err := patchPrice(context.Background(), repository, 1, PriceQuery{CurrencyCode: "EUR"}, 0, mergePatch{patch: map[string]interface{}{}})
+	if !errors.Is(err, ErrCurrencyNotFound) {
+		t.Errorf("got %+v, want %+v", err, ErrCurrencyNotFound)
+	}
+}
+
+var replacePriceTests = []struct {
+	name     string
+	original ProductPrice
+	price    ProductPrice
+	wantErr  error
+	want     string
+	history  string
+}{
+	{
+		name:     "Value",
+		original: ProductPrice{CurrencyCode: "USD"},
+		price:    ProductPrice{Price: MustParseMoney("14.00"), CurrencyCode: "USD"},
+		want:     "USD 14.00 (base), EUR 12.50, USD 10.99 scheduled",
+		history:  "[13.49->14.00 00:01 jdoe]",
+	},
+	{
+		name:     "Currency",
+		original: ProductPrice{CurrencyCode: "EUR"},
+		price:    ProductPrice{Price: MustParseMoney("12.50"), CurrencyCode: "GBP"},
+		want:     "USD 13.49 (base), GBP 12.50, USD 10.99 scheduled",
+		history:  "[remove 12.50->- 00:01 jdoe][-->12.50 00:01 jdoe]",
+	},
+	{
+		name:     "Window",
+		original: helperScheduledPrice("10.99", "2030-01-01T00:00:00Z", "2030-02-01T00:00:00Z"),
+		price:    helperScheduledPrice("10.99", "2030-01-01T00:00:00Z", "2030-03-01T00:00:00Z"),
+		want:     "USD 13.49 (base), EUR 12.50, USD 10.99 scheduled",
+		history:  "[remove 10.99->- 00:01 jdoe][-->10.99 00:01 jdoe]",
+	},
+	{
+		name:     "Currency of another price",
+		original: ProductPrice{CurrencyCode: "USD"},
+		price:    ProductPrice{Price: MustParseMoney("13.49"), CurrencyCode: "EUR"},
+		wantErr:  ErrPriceConflict,
+		want:     "USD 13.49 (base), EUR 12.50, USD 10.99 scheduled",
+	},
+	{
+		name:     "Window overlapping another price",
+		original: ProductPrice{CurrencyCode: "EUR"},
+		price:    ProductPrice{Price: MustParseMoney("9.99"), CurrencyCode: "USD", EffectiveFrom: helperScheduledPrice("0", "2030-01-15T00:00:00Z", "2030-01-16T00:00:00Z").EffectiveFrom},
+		wantErr:  ErrPriceConflict,
+		want:     "USD 13.49 (base), EUR 12.50, USD 10.99 scheduled",
+	},
+	{
+		name:     "Missing price",
+		original: ProductPrice{CurrencyCode: "GBP"},
+		price:    ProductPrice{Price: MustParseMoney("1.00"), CurrencyCode: "GBP"},
+		wantErr:  ErrCurrencyNotFound,
+		want:     "USD 13.49 (base), EUR 12.50, USD 10.99 scheduled",
+	},
+	{
+		name:     "Stale version",
+		original: ProductPrice{CurrencyCode: "USD"},
+		price:    ProductPrice{Price: MustParseMoney("14.00"), CurrencyCode: "USD", Version: 1},
+		wantErr:  ErrVersionMismatch,
+		want:     "USD 13.49 (base), EUR 12.50, USD 10.99 scheduled",
+	},
+}
+
+// describeStoredPrices lists a product's stored prices, noting the base
+// currency's unscheduled price
+func describeStoredPrices(t *testing.T, repository ProductPriceRepository) string {
+	page, err := repository.List(context.Background(), PriceListQuery{ProductIDs: []int{123}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	base, err := repository.Get(context.Background(), 123, PriceQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var prices []string
+	for _, price := range page.Prices {
+		s := price.CurrencyCode + " " + price.Price.String()
+		switch {
+		case price.scheduled():
+			s += " scheduled"
+		case price.CurrencyCode == base.CurrencyCode:
+			s += " (base)"
+		}
+		prices = append(prices, s)
+	}
+
+	return strings.Join(prices, ", ")
+}
+
+func TestProductPriceRepositoryReplace(t *testing.T) {
+	for _, tt := range replacePriceTests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{}
+			memory := NewInMemoryProductPriceRepository()
+			memory.now = clock.Now
+			datastore := helperPropertyRepository(t, newPropertyDatastoreClient())
+			datastore.now = clock.Now
+
+			repositories := map[string]ProductPriceRepository{
+				"memory":    memory,
+				"datastore": datastore,
+			}
+
+			for backend, repository := range repositories {
+				clock.now = historyStart
+				ctx := WithActor(context.Background(), "jdoe")
+				for _, price := range []ProductPrice{
+					{ProductID: 123, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+					{ProductID: 123, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"},
+					helperScheduledPrice("10.99", "2030-01-01T00:00:00Z", "2030-02-01T00:00:00Z"),
+				} {
+					if err := repository.Put(ctx, price); err != nil {
+						t.Fatalf("%s: unexpected error: %s", backend, err)
+					}
+				}
+
+				clock.Advance(time.Minute)
+
+				original, price := tt.original, tt.price
+				original.ProductID, price.ProductID = 123, 123
+				if price.Version == 0 {
+					price.Version = 3
+				}
+
+				err := repository.Replace(ctx, original, price)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("%s: got %+v, want %+v", backend, err, tt.wantErr)
+				}
+
+				if got := describeStoredPrices(t, repository); got != tt.want {
+					t.Errorf("%s: got %s, want %s", backend, got, tt.want)
+				}
+			}
+
+			page, _ := memory.History(context.Background(), 123, PriceHistoryQuery{From: historyStart.Add(time.Minute)})
+			if got := describeChanges(page.Changes); got != tt.history {
+				t.Errorf("got history %s, want %s", got, tt.history)
+			}
+		})
+	}
+}
+
+func TestProductPriceRepositoryReplaceMovesBaseCurrency(t *testing.T) {
+	repository := NewInMemoryProductPriceRepository()
+	ctx := context.Background()
+
+	repository.Put(ctx, ProductPrice{ProductID: 123, Price: MustParseMoney("13.49"), CurrencyCode: "USD"})
+	repository.Put(ctx, ProductPrice{ProductID: 123, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"})
+
+	err := repository.Replace(ctx, ProductPrice{ProductID: 123, CurrencyCode: "USD"}, ProductPrice{ProductID: 123, Price: MustParseMoney("13.49"), CurrencyCode: "CAD"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if price, err := repository.Get(ctx, 123, PriceQuery{}); err != nil || price.CurrencyCode != "CAD" {
+		t.Errorf("got %+v %+v, want CAD as the base currency", price, err)
+	}
+}
@@ -2,38 +2,129 @@ package productaggregate
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strconv"
+	"time"
 
 	"cloud.google.com/go/datastore"
 )
 
-// ProductPriceRepository handles product prices
+// ErrPriceNotFound is returned when no price is stored for a product
+var ErrPriceNotFound = errors.New("product price not found")
+
+// ErrCurrencyNotFound is returned when a product has prices, but none in the
+// requested currency. It matches ErrPriceNotFound.
+var ErrCurrencyNotFound = fmt.Errorf("%w in the requested currency", ErrPriceNotFound)
+
+// PriceQuery selects which of a product's prices to fetch
+type PriceQuery struct {
+	// CurrencyCode is the price's currency. The product's base currency,
+	// the first one it was priced in, is used when empty.
+	CurrencyCode string
+
+	// At is the time the price should be effective at, or now when zero
+	At time.Time
+}
+
+// ProductPriceRepository handles product prices. A product may be priced in
+// several currencies.
 type ProductPriceRepository interface {
-	Get(productID int) (*ProductPrice, error)
-	Put(ProductPrice) error
+	Get(ctx context.Context, productID int, query PriceQuery) (*ProductPrice, error)
+
+	// Put sets the product's price in price.CurrencyCode, leaving its prices
+	// in other currencies alone. A price with an effective window is
+	// scheduled alongside the unscheduled price, unless it overlaps another
+	// scheduled price, in which case ErrPriceConflict is returned. When
+	// price.Version is set, ErrVersionMismatch is returned unless the
+	// product's prices are still at that version. Every Put is recorded in
+	// the product's price history, along with the actor from the context.
+	Put(ctx context.Context, price ProductPrice) error
+
+	// Replace swaps the product's price in original's currency and window
+	// for price, which may be in another currency or window, in one update.
+	// ErrCurrencyNotFound is returned when original is not stored, and a
+	// *PriceConflictError when price clashes with any of the product's other
+	// prices. Version is checked, and the change recorded, as for Put.
+	Replace(ctx context.Context, original ProductPrice, price ProductPrice) error
+
+	// History lists the product's price changes, oldest first
+	History(ctx context.Context, productID int, query PriceHistoryQuery) (PriceHistoryPage, error)
+
+	// List pages through the stored prices of every product, or of the
+	// products asked for
+	List(ctx context.Context, query PriceListQuery) (PriceListPage, error)
+
+	// Delete soft deletes the product's prices in every currency, leaving a
+	// tombstone recording the actor from the context and when. Deleted
+	// prices are not found by Get or List until they are restored by
+	// Undelete, and a Put discards them for good. A *PriceDeletedError is
+	// returned if they are already deleted. Version is checked as for Put.
+	Delete(ctx context.Context, productID int, version int64) error
+
+	// Undelete restores the product's deleted prices, or returns
+	// ErrPriceNotDeleted
+	Undelete(ctx context.Context, productID int) error
 }
 
-// GCPProductPriceRepository gets product prices from Google Cloud
+// GCPProductPriceRepository gets product prices from Google Cloud. Price
+// changes are kept as child entities of the product's prices, of the kind
+// <datastoreID>_history.
 type GCPProductPriceRepository struct {
 	datastoreID string
 	client      DatastoreClient
-	ctx         context.Context
+	now         func() time.Time
 }
 
 type DatastoreClient interface {
 	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
 	Get(ctx context.Context, key *datastore.Key, dst interface{}) (err error)
+
+	// GetMulti is a batch version of Get. Entities which could not be
+	// loaded are reported in a datastore.MultiError.
+	GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) (err error)
+	GetAll(ctx context.Context, q *datastore.Query, dst interface{}) (keys []*datastore.Key, err error)
+
+	// RunInTransaction runs f in a transaction, retrying it on contention
+	RunInTransaction(ctx context.Context, f func(tx DatastoreTransaction) error) error
+}
+
+// DatastoreTransaction is the part of a datastore transaction used by the
+// repositories
+type DatastoreTransaction interface {
+	Get(key *datastore.Key, dst interface{}) error
+	GetMulti(keys []*datastore.Key, dst interface{}) error
+	Put(key *datastore.Key, src interface{}) (*datastore.PendingKey, error)
+	PutMulti(keys []*datastore.Key, src interface{}) ([]*datastore.PendingKey, error)
 }
 
 type NewDatastoreClient func(ctx context.Context) (DatastoreClient, error)
 
 func NewGCPDatastoreClientCreator(projectID string) NewDatastoreClient {
 	return func(ctx context.Context) (DatastoreClient, error) {
-		return datastore.NewClient(ctx, projectID)
+		client, err := datastore.NewClient(ctx, projectID)
+		if err != nil {
+			return nil, err
+		}
+
+		return gcpDatastoreClient{client}, nil
 	}
 }
 
-// NewGCPProductPriceRepository creates a new GCPProductPriceRepository
+// gcpDatastoreClient adapts a datastore.Client to DatastoreClient
+type gcpDatastoreClient struct {
+	*datastore.Client
+}
+
+func (c gcpDatastoreClient) RunInTransaction(ctx context.Context, f func(tx DatastoreTransaction) error) error {
+	_, err := c.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		return f(tx)
+	})
+	return err
+}
+
+// NewGCPProductPriceRepository creates a new GCPProductPriceRepository. ctx is
+// only used to create the datastore client; each call takes its own context.
 func NewGCPProductPriceRepository(ctx context.Context, newClient NewDatastoreClient, datastoreID string) (*GCPProductPriceRepository, error) {
 	client, err := newClient(ctx)
 	if err != nil {
@@ -43,7 +134,7 @@ func NewGCPProductPriceRepository(ctx context.Context, newClient NewDatastoreCli
 	return &GCPProductPriceRepository{
 		datastoreID: datastoreID,
 		client:      client,
-		ctx:         ctx,
+		now:         time.Now,
 	}, nil
 }
 
@@ -51,28 +142,776 @@ func (p GCPProductPriceRepository) keyFromProductID(productID int) string {
 	return "product_" + strconv.Itoa(productID)
 }
 
-// Get fetches a product price by id
-func (p GCPProductPriceRepository) Get(productID int) (*ProductPrice, error) {
+func (p GCPProductPriceRepository) historyKind() string {
+	return p.datastoreID + "_history"
+}
+
+// Get fetches a product price by id. Products still stored with a single
+// price are decoded as it, and written in the current encoding by their
+// next Put.
+func (p GCPProductPriceRepository) Get(ctx context.Context, productID int, query PriceQuery) (*ProductPrice, error) {
 	key := p.keyFromProductID(productID)
 	datastoreKey := datastore.NameKey(p.datastoreID, key, nil)
 
-	newdata := &ProductPrice{}
-	if err := p.client.Get(p.ctx, datastoreKey, newdata); err != nil {
+	entity := &priceEntity{}
+	if err := p.client.Get(ctx, datastoreKey, entity); err != nil {
+		if errors.Is(err, datastore.ErrNoSuchEntity) {
+			return &ProductPrice{}, ErrPriceNotFound
+		}
 		return &ProductPrice{}, err
 	}
 
-	return newdata, nil
+	at := query.At
+	if at.IsZero() {
+		at = p.now()
+	}
+
+	return entity.price(query.CurrencyCode, at)
+}
+
+// GetMulti fetches many products' prices in one datastore call. The
+// results are in the same order as productIDs, and each has its own error.
+func (p GCPProductPriceRepository) GetMulti(ctx context.Context, productIDs []int, query PriceQuery) ([]*ProductPrice, []error) {
+	keys := make([]*datastore.Key, len(productIDs))
+	entities := make([]*priceEntity, len(productIDs))
+	for i, productID := range productIDs {
+		keys[i] = datastore.NameKey(p.datastoreID, p.keyFromProductID(productID), nil)
+		entities[i] = &priceEntity{}
+	}
+
+	prices := make([]*ProductPrice, len(productIDs))
+	errs := make([]error, len(productIDs))
+
+	var multiErr datastore.MultiError
+	err := p.client.GetMulti(ctx, keys, entities)
+	if err != nil && !errors.As(err, &multiErr) {
+		for i := range errs {
+			errs[i] = err
+		}
+		return prices, errs
+	}
+
+	at := query.At
+	if at.IsZero() {
+		at = p.now()
+	}
+
+	for i, entity := range entities {
+		if multiErr != nil && multiErr[i] != nil {
+			errs[i] = multiErr[i]
+			if errors.Is(multiErr[i], datastore.ErrNoSuchEntity) {
+				errs[i] = ErrPriceNotFound
+			}
+			continue
+		}
+
+		prices[i], errs[i] = entity.price(query.CurrencyCode, at)
+	}
+
+	return prices, errs
 }
 
-// Put updates a product price
-func (p GCPProductPriceRepository) Put(product ProductPrice) error {
-	key := p.keyFromProductID(product.ProductID)
+// Put sets a product's price in one currency
+func (p GCPProductPriceRepository) Put(ctx context.Context, price ProductPrice) error {
+	key := p.keyFromProductID(price.ProductID)
 
 	// Make a key to map to datastore
 	datastoreKey := datastore.NameKey(p.datastoreID, key, nil)
 
-	if _, err := p.client.Put(p.ctx, datastoreKey, &product); err != nil {
+	// Read and write in one transaction, so that concurrent puts in other
+	// currencies are not lost, and the history is never out of step
+	return p.client.RunInTransaction(ctx, func(tx DatastoreTransaction) error {
+		entity := &priceEntity{}
+		if err := tx.Get(datastoreKey, entity); err != nil && !errors.Is(err, datastore.ErrNoSuchEntity) {
+			return err
+		}
+
+		now := p.now()
+		entity.ProductID = price.ProductID
+		old, expired, err := entity.setPrice(price, now)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Put(datastoreKey, entity); err != nil {
+			return err
+		}
+
+		changes := newExpiryChanges(ctx, price.ProductID, expired, now)
+		changes = append(changes, newPriceChange(ctx, old, price, now.Add(time.Duration(len(expired)))))
+
+		for i := range changes {
+			historyKey := datastore.NameKey(p.historyKind(), historyKeyName(changes[i].ChangedAt), datastoreKey)
+			if _, err := tx.Put(historyKey, &changes[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// PutMulti sets many prices, each in one currency. Prices are written in
+// chunks, each read and written in one transaction with its history, so a
+// failed chunk does not undo the others. Atomic updates must fit in one
+// chunk. The results are in the same order as prices, and each has its own
+// error.
+func (p GCPProductPriceRepository) PutMulti(ctx context.Context, prices []ProductPrice, options PutMultiOptions) []error {
+	errs := make([]error, len(prices))
+	if options.Atomic && len(prices) > putMultiChunkSize {
+		for i := range errs {
+			errs[i] = fmt.Errorf("atomic updates may set at most %d prices", putMultiChunkSize)
+		}
+		return errs
+	}
+
+	for start := 0; start < len(prices); start += putMultiChunkSize {
+		end := start + putMultiChunkSize
+		if end > len(prices) {
+			end = len(prices)
+		}
+
+		p.putChunk(ctx, prices[start:end], errs[start:end], options)
+	}
+
+	return errs
+}
+
+// errChunkFailed rolls back a transaction whose prices failed on their own
+var errChunkFailed = errors.New("prices in the chunk failed")
+
+// putChunk sets prices in one transaction, recording the result of each in
+// errs
+func (p GCPProductPriceRepository) putChunk(ctx context.Context, prices []ProductPrice, errs []error, options PutMultiOptions) {
+	var keys []*datastore.Key
+	keyIndex := make(map[int]int)
+	for _, price := range prices {
+		if _, ok := keyIndex[price.ProductID]; !ok {
+			keyIndex[price.ProductID] = len(keys)
+			keys = append(keys, datastore.NameKey(p.datastoreID, p.keyFromProductID(price.ProductID), nil))
+		}
+	}
+
+	err := p.client.RunInTransaction(ctx, func(tx DatastoreTransaction) error {
+		loaded := make([]*priceEntity, len(keys))
+		for i := range loaded {
+			loaded[i] = &priceEntity{}
+		}
+
+		var multiErr datastore.MultiError
+		if err := tx.GetMulti(keys, loaded); err != nil {
+			if !errors.As(err, &multiErr) {
+				return err
+			}
+
+			for _, err := range multiErr {
+				if err != nil && !errors.Is(err, datastore.ErrNoSuchEntity) {
+					return err
+				}
+			}
+		}
+
+		entities := make(map[int]*priceEntity, len(keys))
+		for productID, i := range keyIndex {
+			if multiErr == nil || multiErr[i] == nil {
+				entities[productID] = loaded[i]
+			}
+		}
+
+		// Retried transactions start over from what was read
+		changes, priceErrs := applyPrices(ctx, entities, prices, p.now())
+		copy(errs, priceErrs)
+
+		if options.Atomic && abortPrices(errs) {
+			return errChunkFailed
+		}
+
+		var putKeys []*datastore.Key
+		var putEntities []interface{}
+		touched := make(map[int]bool)
+		for i, price := range prices {
+			if errs[i] == nil && !touched[price.ProductID] {
+				touched[price.ProductID] = true
+				putKeys = append(putKeys, keys[keyIndex[price.ProductID]])
+				putEntities = append(putEntities, entities[price.ProductID])
+			}
+		}
+
+		for i := range changes {
+			parent := keys[keyIndex[changes[i].ProductID]]
+			putKeys = append(putKeys, datastore.NameKey(p.historyKind(), historyKeyName(changes[i].ChangedAt), parent))
+			putEntities = append(putEntities, &changes[i])
+		}
+
+		if len(putKeys) == 0 {
+			return nil
+		}
+
+		_, err := tx.PutMulti(putKeys, putEntities)
 		return err
+	})
+
+	if err != nil && !errors.Is(err, errChunkFailed) {
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+}
+
+// Replace swaps one of a product's prices for another
+func (p GCPProductPriceRepository) Replace(ctx context.Context, original ProductPrice, price ProductPrice) error {
+	return p.update(ctx, price.ProductID, func(entity *priceEntity, now time.Time) ([]PriceChange, error) {
+		replaced, expired, err := entity.replacePrice(original, price, now)
+		if err != nil {
+			return nil, err
+		}
+
+		changes := newExpiryChanges(ctx, price.ProductID, expired, now)
+		return append(changes, newReplacementChanges(ctx, replaced, price, now.Add(time.Duration(len(expired))))...), nil
+	})
+}
+
+// Delete soft deletes a product's prices
+func (p GCPProductPriceRepository) Delete(ctx context.Context, productID int, version int64) error {
+	return p.update(ctx, productID, func(entity *priceEntity, now time.Time) ([]PriceChange, error) {
+		if err := entity.delete(version, ActorFromContext(ctx), now); err != nil {
+			return nil, err
+		}
+
+		return newDeletionChanges(ctx, entity, PriceChangeDelete, now), nil
+	})
+}
+
+// Undelete restores a product's deleted prices
+func (p GCPProductPriceRepository) Undelete(ctx context.Context, productID int) error {
+	return p.update(ctx, productID, func(entity *priceEntity, now time.Time) ([]PriceChange, error) {
+		if err := entity.undelete(now); err != nil {
+			return nil, err
+		}
+
+		return newDeletionChanges(ctx, entity, PriceChangeUndelete, now), nil
+	})
+}
+
+// update changes a stored product's prices in one transaction, along with
+// the changes it returns for the price history
+func (p GCPProductPriceRepository) update(ctx context.Context, productID int, change func(entity *priceEntity, now time.Time) ([]PriceChange, error)) error {
+	datastoreKey := datastore.NameKey(p.datastoreID, p.keyFromProductID(productID), nil)
+
+	return p.client.RunInTransaction(ctx, func(tx DatastoreTransaction) error {
+		entity := &priceEntity{}
+		if err := tx.Get(datastoreKey, entity); err != nil {
+			if errors.Is(err, datastore.ErrNoSuchEntity) {
+				return ErrPriceNotFound
+			}
+			return err
+		}
+
+		entity.ProductID = productID
+		changes, err := change(entity, p.now())
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Put(datastoreKey, entity); err != nil {
+			return err
+		}
+
+		for i := range changes {
+			historyKey := datastore.NameKey(p.historyKind(), historyKeyName(changes[i].ChangedAt), datastoreKey)
+			if _, err := tx.Put(historyKey, &changes[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// History lists a product's price changes. Changes are keyed by time, so
+// they are paged through by key.
+func (p GCPProductPriceRepository) History(ctx context.Context, productID int, query PriceHistoryQuery) (PriceHistoryPage, error) {
+	parent := datastore.NameKey(p.datastoreID, p.keyFromProductID(productID), nil)
+	pageSize := query.pageSize()
+
+	q := datastore.NewQuery(p.historyKind()).
+		Ancestor(parent).
+		Order("__key__").
+		Limit(pageSize + 1)
+
+	start := ""
+	if !query.From.IsZero() {
+		start = historyKeyBound(query.From)
+	}
+
+	if query.PageToken != "" {
+		position, err := decodePageToken(query.PageToken)
+		if err != nil {
+			return PriceHistoryPage{}, err
+		}
+
+		if position > start {
+			start = position
+		}
+	}
+
+	if start != "" {
+		q = q.Filter("__key__ >=", datastore.NameKey(p.historyKind(), start, parent))
+	}
+
+	if !query.To.IsZero() {
+		q = q.Filter("__key__ <", datastore.NameKey(p.historyKind(), historyKeyBound(query.To), parent))
+	}
+
+	var changes []PriceChange
+	keys, err := p.client.GetAll(ctx, q, &changes)
+	if err != nil {
+		return PriceHistoryPage{}, err
+	}
+
+	page := PriceHistoryPage{Changes: changes}
+	if len(changes) > pageSize {
+		page.Changes = changes[:pageSize]
+		page.NextPageToken = encodePageToken(keys[pageSize].Name)
+	}
+
+	return page, nil
+}
+
+// List pages through the stored prices. Products are listed in key order,
+// and page tokens hold the key name of the next product.
+func (p GCPProductPriceRepository) List(ctx context.Context, query PriceListQuery) (PriceListPage, error) {
+	if err := query.validate(); err != nil {
+		return PriceListPage{}, err
+	}
+
+	if len(query.ProductIDs) > 0 {
+		return p.listProducts(ctx, query.ProductIDs)
+	}
+
+	pageSize := query.pageSize()
+
+	// Fetch one more product than the page holds, to find the next page
+	q := datastore.NewQuery(p.datastoreID).Order("__key__").Limit(pageSize + 1)
+	if query.PageToken != "" {
+		start, err := decodePageToken(query.PageToken)
+		if err != nil {
+			return PriceListPage{}, err
+		}
+
+		q = q.Filter("__key__ >=", datastore.NameKey(p.datastoreID, start, nil))
+	}
+
+	var entities []priceEntity
+	keys, err := p.client.GetAll(ctx, q, &entities)
+	if err != nil {
+		return PriceListPage{}, err
+	}
+
+	var page PriceListPage
+	for i := range entities {
+		if i == pageSize {
+			page.NextPageToken = encodePageToken(keys[i].Name)
+			break
+		}
+
+		productID, err := productIDFromKey(keys[i].Name)
+		if err != nil {
+			return PriceListPage{}, fmt.Errorf("unexpected product key %s", keys[i].Name)
+		}
+
+		entities[i].ProductID = productID
+		page.Prices = append(page.Prices, entities[i].storedPrices()...)
+	}
+
+	return page, nil
+}
+
+// listProducts lists the stored prices of the products, in the order given.
+// Products without prices are left out.
+func (p GCPProductPriceRepository) listProducts(ctx context.Context, productIDs []int) (PriceListPage, error) {
+	keys := make([]*datastore.Key, len(productIDs))
+	entities := make([]*priceEntity, len(productIDs))
+	for i, productID := range productIDs {
+		keys[i] = datastore.NameKey(p.datastoreID, p.keyFromProductID(productID), nil)
+		entities[i] = &priceEntity{}
+	}
+
+	var multiErr datastore.MultiError
+	err := p.client.GetMulti(ctx, keys, entities)
+	if err != nil && !errors.As(err, &multiErr) {
+		return PriceListPage{}, err
+	}
+
+	var page PriceListPage
+	for i, entity := range entities {
+		if multiErr != nil && multiErr[i] != nil {
+			if errors.Is(multiErr[i], datastore.ErrNoSuchEntity) {
+				continue
+			}
+			return PriceListPage{}, multiErr[i]
+		}
+
+		entity.ProductID = productIDs[i]
+		page.Prices = append(page.Prices, entity.storedPrices()...)
+	}
+
+	return page, nil
+}
+
+// priceEntity holds all of a product's prices, as they are stored
+type priceEntity struct {
+	ProductID int
+
+	// BaseCurrency is the currency the product was first priced in
+	BaseCurrency string
+
+	// Prices holds one price per currency
+	Prices []ProductPrice
+
+	// Version counts the entity's updates. Entities stored before it was
+	// kept are at version 1.
+	Version int64
+
+	// UpdatedAt is when the entity was last updated, or zero for entities
+	// stored before it was kept
+	UpdatedAt time.Time
+
+	// DeletedAt and DeletedBy are the tombstone of deleted prices, which
+	// are kept so that they can be restored. DeletedAt is zero unless the
+	// prices are deleted.
+	DeletedAt time.Time
+	DeletedBy string
+}
+
+// price returns the product's price in currencyCode at time at, or in its
+// base currency when currencyCode is empty. A scheduled price active at that
+// time wins over the unscheduled one.
+func (e *priceEntity) price(currencyCode string, at time.Time) (*ProductPrice, error) {
+	if e.deleted() {
+		return &ProductPrice{}, &PriceDeletedError{DeletedAt: e.DeletedAt, DeletedBy: e.DeletedBy}
+	}
+
+	if len(e.Prices) == 0 {
+		return &ProductPrice{}, ErrPriceNotFound
+	}
+
+	if currencyCode == "" {
+		currencyCode = e.BaseCurrency
+	}
+
+	var unscheduled *ProductPrice
+	for _, price := range e.Prices {
+		if price.CurrencyCode != currencyCode || !price.activeAt(at) {
+			continue
+		}
+
+		price.ProductID = e.ProductID
+		price.Version = e.Version
+		price.UpdatedAt = e.UpdatedAt
+		if price.scheduled() {
+			return &price, nil
+		}
+
+		found := price
+		unscheduled = &found
+	}
+
+	if unscheduled != nil {
+		return unscheduled, nil
+	}
+
+	return &ProductPrice{}, ErrCurrencyNotFound
+}
+
+// setPrice adds the price, or replaces the one in the same currency with the
+// same window, which it returns. Scheduled prices whose windows have ended
+// by now are dropped, and returned for the price history. Deleted prices are discarded in every currency, so
+// that the product starts over with only the new price. A scheduled price
+// may not overlap another in the same currency, and is rejected with a
+// *PriceConflictError. A price with a
+// Version is rejected with a *VersionMismatchError unless the entity is at
+// that version. The entity's version is incremented, and its update time
+// set to now.
+func (e *priceEntity) setPrice(price ProductPrice, now time.Time) (*ProductPrice, []ProductPrice, error) {
+	if err := e.checkVersion(price.Version); err != nil {
+		return nil, nil, err
+	}
+
+	price.Version = 0
+	price.UpdatedAt = time.Time{}
+
+	if e.deleted() {
+		e.BaseCurrency = ""
+		e.Prices = nil
+		e.DeletedAt = time.Time{}
+		e.DeletedBy = ""
+	}
+
+	var expired []ProductPrice
+	prices := make([]ProductPrice, 0, len(e.Prices)+1)
+	for _, existing := range e.Prices {
+		if existing.expiredAt(now) {
+			existing.ProductID = e.ProductID
+			expired = append(expired, existing)
+			continue
+		}
+
+		prices = append(prices, existing)
+	}
+
+	for i, existing := range prices {
+		if existing.CurrencyCode != price.CurrencyCode {
+			continue
+		}
+
+		if existing.sameWindow(price) {
+			prices[i] = price
+			e.Prices = prices
+			e.Version++
+			e.UpdatedAt = now.UTC()
+			existing.ProductID = e.ProductID
+			return &existing, expired, nil
+		}
+
+		if price.scheduled() && existing.scheduled() && price.overlaps(existing) {
+			existing.ProductID = e.ProductID
+			return nil, nil, &PriceConflictError{Existing: existing}
+		}
+	}
+
+	if e.BaseCurrency == "" {
+		e.BaseCurrency = price.CurrencyCode
+	}
+
+	e.Prices = append(prices, price)
+	e.Version++
+	e.UpdatedAt = now.UTC()
+	return nil, expired, nil
+}
+
+// Datastore property names for priceEntity and ProductPrice
+const (
+	propertyProductID     = "product_id"
+	propertyBaseCurrency  = "base_currency"
+	propertyPrices        = "prices"
+	propertyVersion       = "version"
+	propertyUpdatedAt     = "updated_at"
+	propertyDeletedAt     = "deleted_at"
+	propertyDeletedBy     = "deleted_by"
+	propertyCurrencyCode  = "currency_code"
+	propertyPriceUnits    = "price_units"
+	propertyPriceExponent = "price_exponent"
+	propertyEffectiveFrom = "effective_from"
+	propertyEffectiveTo   = "effective_to"
+
+	// propertyLegacyPrice held the price as a float64
+	propertyLegacyPrice = "price"
+)
+
+// Save encodes the entity for the datastore, with each price as a nested
+// entity
+func (e *priceEntity) Save() ([]datastore.Property, error) {
+	prices := make([]interface{}, 0, len(e.Prices))
+	for i := range e.Prices {
+		props, err := e.Prices[i].Save()
+		if err != nil {
+			return nil, err
+		}
+
+		prices = append(prices, &datastore.Entity{Properties: props})
+	}
+
+	props := []datastore.Property{
+		{Name: propertyProductID, Value: int64(e.ProductID)},
+		{Name: propertyBaseCurrency, Value: e.BaseCurrency},
+		{Name: propertyPrices, Value: prices, NoIndex: true},
+		{Name: propertyVersion, Value: e.Version, NoIndex: true},
+	}
+
+	if !e.UpdatedAt.IsZero() {
+		props = append(props, datastore.Property{Name: propertyUpdatedAt, Value: e.UpdatedAt, NoIndex: true})
+	}
+
+	if e.deleted() {
+		props = append(props,
+			datastore.Property{Name: propertyDeletedAt, Value: e.DeletedAt, NoIndex: true},
+			datastore.Property{Name: propertyDeletedBy, Value: e.DeletedBy, NoIndex: true},
+		)
+	}
+
+	return props, nil
+}
+
+// Load decodes an entity from the datastore. Entities stored by older
+// versions hold a single price at the top level, which becomes the base
+// currency's price.
+func (e *priceEntity) Load(props []datastore.Property) error {
+	var single []datastore.Property
+	hasPrices := false
+
+	for _, prop := range props {
+		var ok bool
+		switch prop.Name {
+		case propertyProductID:
+			var id int64
+			id, ok = prop.Value.(int64)
+			e.ProductID = int(id)
+
+		case propertyBaseCurrency:
+			e.BaseCurrency, ok = prop.Value.(string)
+
+		case propertyVersion:
+			e.Version, ok = prop.Value.(int64)
+
+		case propertyUpdatedAt:
+			e.UpdatedAt, ok = prop.Value.(time.Time)
+
+		case propertyDeletedAt:
+			e.DeletedAt, ok = prop.Value.(time.Time)
+
+		case propertyDeletedBy:
+			e.DeletedBy, ok = prop.Value.(string)
+
+		case propertyPrices:
+			var values []interface{}
+			values, ok = prop.Value.([]interface{})
+			hasPrices = true
+
+			for _, value := range values {
+				nested, isEntity := value.(*datastore.Entity)
+				if !isEntity {
+					return fmt.Errorf("datastore property %s has unexpected element type %T", prop.Name, value)
+				}
+
+				var price ProductPrice
+				if err := price.Load(nested.Properties); err != nil {
+					return err
+				}
+
+				e.Prices = append(e.Prices, price)
+			}
+
+		case propertyCurrencyCode, propertyPriceUnits, propertyPriceExponent, propertyLegacyPrice:
+			single = append(single, prop)
+			ok = true
+
+		default:
+			ok = true
+		}
+
+		if !ok {
+			return fmt.Errorf("datastore property %s has unexpected type %T", prop.Name, prop.Value)
+		}
+	}
+
+	if len(e.Prices) > 0 && e.Version == 0 {
+		e.Version = 1
+	}
+
+	if hasPrices || len(single) == 0 {
+		return nil
+	}
+
+	var price ProductPrice
+	if err := price.Load(single); err != nil {
+		return err
+	}
+
+	price.ProductID = e.ProductID
+	e.BaseCurrency = price.CurrencyCode
+	e.Prices = []ProductPrice{price}
+	e.Version = 1
+
+	return nil
+}
+
+// Save encodes the price for the datastore as an exact integer number of
+// units and an exponent
+func (p *ProductPrice) Save() ([]datastore.Property, error) {
+	props := []datastore.Property{
+		{Name: propertyProductID, Value: int64(p.ProductID)},
+		{Name: propertyCurrencyCode, Value: p.CurrencyCode},
+		{Name: propertyPriceUnits, Value: p.Price.Units(), NoIndex: true},
+		{Name: propertyPriceExponent, Value: int64(p.Price.Exponent()), NoIndex: true},
+	}
+
+	if p.EffectiveFrom != nil {
+		props = append(props, datastore.Property{Name: propertyEffectiveFrom, Value: *p.EffectiveFrom, NoIndex: true})
+	}
+
+	if p.EffectiveTo != nil {
+		props = append(props, datastore.Property{Name: propertyEffectiveTo, Value: *p.EffectiveTo, NoIndex: true})
+	}
+
+	return props, nil
+}
+
+// Load decodes a price from the datastore. Prices stored by older versions
+// as a float are converted to the shortest decimal which reads back as the
+// same float, then rounded to the currency's minor unit.
+func (p *ProductPrice) Load(props []datastore.Property) error {
+	var units, exponent int64
+	var legacyPrice float64
+	hasUnits, hasLegacy := false, false
+
+	for _, prop := range props {
+		var ok bool
+		switch prop.Name {
+		case propertyProductID:
+			var id int64
+			id, ok = prop.Value.(int64)
+			p.ProductID = int(id)
+
+		case propertyCurrencyCode:
+			p.CurrencyCode, ok = prop.Value.(string)
+
+		case propertyPriceUnits:
+			units, ok = prop.Value.(int64)
+			hasUnits = true
+
+		case propertyPriceExponent:
+			exponent, ok = prop.Value.(int64)
+
+		case propertyLegacyPrice:
+			legacyPrice, ok = prop.Value.(float64)
+			hasLegacy = true
+
+		case propertyEffectiveFrom, propertyEffectiveTo:
+			var t time.Time
+			t, ok = prop.Value.(time.Time)
+			if prop.Name == propertyEffectiveFrom {
+				p.EffectiveFrom = &t
+			} else {
+				p.EffectiveTo = &t
+			}
+
+		default:
+			ok = true
+		}
+
+		if !ok {
+			return fmt.Errorf("datastore property %s has unexpected type %T", prop.Name, prop.Value)
+		}
+	}
+
+	switch {
+	case hasUnits:
+		if exponent < 0 || exponent > maxMoneyExponent {
+			return fmt.Errorf("datastore price exponent %d out of range", exponent)
+		}
+		p.Price = NewMoney(units, int(exponent))
+
+	case hasLegacy:
+		price, err := moneyFromFloat(legacyPrice)
+		if err != nil {
+			return err
+		}
+
+		p.Price, err = price.RoundTo(p.CurrencyCode)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -1,11 +1,13 @@
 package productaggregate
 
 import (
+	"context"
 	"errors"
+	"reflect"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/datastore"
-	"golang.org/x/net/context"
 )
 
 type testDatastoreClient struct {
@@ -24,10 +26,66 @@ func (t testDatastoreClient) Put(ctx context.Context, key *datastore.Key, src in
 	return nil, t.putErr
 }
 
+// Get loads a product priced at 1 USD, unless getErr is set
 func (t testDatastoreClient) Get(ctx context.Context, key *datastore.Key, dst interface{}) (err error) {
+	if t.getErr != nil {
+		return t.getErr
+	}
+
+	entity := &priceEntity{ProductID: 10}
+	entity.setPrice(ProductPrice{ProductID: 10, Price: NewMoney(1, 0), CurrencyCode: "USD"}, time.Now())
+
+	props, err := entity.Save()
+	if err != nil {
+		return err
+	}
+
+	return dst.(datastore.PropertyLoadSaver).Load(props)
+}
+
+func (t testDatastoreClient) GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error {
 	return t.getErr
 }
 
+func (t testDatastoreClient) GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	return nil, t.getErr
+}
+
+func (t testDatastoreClient) RunInTransaction(ctx context.Context, f func(tx DatastoreTransaction) error) error {
+	return f(clientTransaction{ctx: ctx, client: t})
+}
+
+// clientTransaction runs a transaction's operations directly against a test
+// client
+type clientTransaction struct {
+	ctx    context.Context
+	client DatastoreClient
+}
+
+func (c clientTransaction) Get(key *datastore.Key, dst interface{}) error {
+	return c.client.Get(c.ctx, key, dst)
+}
+
+func (c clientTransaction) GetMulti(keys []*datastore.Key, dst interface{}) error {
+	return c.client.GetMulti(c.ctx, keys, dst)
+}
+
+func (c clientTransaction) Put(key *datastore.Key, src interface{}) (*datastore.PendingKey, error) {
+	_, err := c.client.Put(c.ctx, key, src)
+	return nil, err
+}
+
+func (c clientTransaction) PutMulti(keys []*datastore.Key, src interface{}) ([]*datastore.PendingKey, error) {
+	entities := reflect.ValueOf(src)
+	for i, key := range keys {
+		if _, err := c.client.Put(c.ctx, key, entities.Index(i).Interface()); err != nil {
+			return nil, err
+		}
+	}
+
+	return make([]*datastore.PendingKey, len(keys)), nil
+}
+
 func newTestDatastoreClientCreator(createErr error, getErr error, putErr error) NewDatastoreClient {
 	return func(ctx context.Context) (DatastoreClient, error) {
 		if createErr != nil {
@@ -98,6 +156,7 @@ type priceRepositoryGetIn struct {
 
 type priceRepositoryGetWant struct {
 	hasError bool
+	notFound bool
 }
 
 var priceRepositoryGetTests = []struct {
@@ -114,6 +173,17 @@ var priceRepositoryGetTests = []struct {
 			hasError: false,
 		},
 	},
+	{
+		name: "Get missing entity returns ErrPriceNotFound",
+		in: priceRepositoryGetIn{
+			productID: 10,
+			err:       datastore.ErrNoSuchEntity,
+		},
+		want: priceRepositoryGetWant{
+			hasError: true,
+			notFound: true,
+		},
+	},
 	{
 		name: "Get throws error",
 		in: priceRepositoryGetIn{
@@ -136,7 +206,7 @@ func TestProductPriceRepositoryGet(t *testing.T) {
 				t.Errorf("unexpected error: %+v", createErr)
 			}
 
-			_, err := repository.Get(tt.in.productID)
+			_, err := repository.Get(ctx, tt.in.productID, PriceQuery{})
 
 			if err != nil && !tt.want.hasError {
 				t.Errorf("expected no error. error thrown: %+v", err)
@@ -145,6 +215,10 @@ func TestProductPriceRepositoryGet(t *testing.T) {
 			if err == nil && tt.want.hasError {
 				t.Error("expected error. none found")
 			}
+
+			if errors.Is(err, ErrPriceNotFound) != tt.want.notFound {
+				t.Errorf("got error %+v, want not found: %t", err, tt.want.notFound)
+			}
 		})
 	}
 }
@@ -198,7 +272,7 @@ func TestProductPriceRepositoryPut(t *testing.T) {
 				t.Errorf("unexpected error: %+v", createErr)
 			}
 
-			err := repository.Put(tt.in.price)
+			err := repository.Put(ctx, tt.in.price)
 
 			if err != nil && !tt.want.hasError {
 				t.Errorf("expected no error. error thrown: %+v", err)
@@ -210,3 +284,239 @@ func TestProductPriceRepositoryPut(t *testing.T) {
 		})
 	}
 }
+
+// propertyDatastoreClient keeps entities as datastore properties, so that
+// tests exercise the same encoding as the real datastore
+type propertyDatastoreClient struct {
+	entities map[string][]datastore.Property
+	puts     int
+}
+
+func newPropertyDatastoreClient() *propertyDatastoreClient {
+	return &propertyDatastoreClient{
+		entities: make(map[string][]datastore.Property),
+	}
+}
+
+func (p *propertyDatastoreClient) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	props, err := src.(datastore.PropertyLoadSaver).Save()
+	if err != nil {
+		return nil, err
+	}
+
+	p.puts++
+	p.entities[key.String()] = props
+	return key, nil
+}
+
+func (p *propertyDatastoreClient) Get(ctx context.Context, key *datastore.Key, dst interface{}) (err error) {
+	props, ok := p.entities[key.String()]
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+
+	return dst.(datastore.PropertyLoadSaver).Load(props)
+}
+
+func (p *propertyDatastoreClient) GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error {
+	entities := reflect.ValueOf(dst)
+	errs := make(datastore.MultiError, len(keys))
+	failed := false
+
+	for i, key := range keys {
+		errs[i] = p.Get(ctx, key, entities.Index(i).Interface())
+		failed = failed || errs[i] != nil
+	}
+
+	if failed {
+		return errs
+	}
+
+	return nil
+}
+
+// GetAll is not supported, as queries cannot be inspected
+func (p *propertyDatastoreClient) GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	return nil, errors.New("queries are not supported")
+}
+
+func (p *propertyDatastoreClient) RunInTransaction(ctx context.Context, f func(tx DatastoreTransaction) error) error {
+	return f(clientTransaction{ctx: ctx, client: p})
+}
+
+func helperPropertyRepository(t *testing.T, client *propertyDatastoreClient) *GCPProductPriceRepository {
+	creator := func(ctx context.Context) (DatastoreClient, error) {
+		return client, nil
+	}
+
+	repository, err := NewGCPProductPriceRepository(context.Background(), creator, "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	return repository
+}
+
+func TestProductPriceRepositoryRoundTrip(t *testing.T) {
+	client := newPropertyDatastoreClient()
+	repository := helperPropertyRepository(t, client)
+	repository.now = func() time.Time { return scheduleStart }
+
+	in := ProductPrice{ProductID: 10, Price: MustParseMoney("13.490000001"), CurrencyCode: "USD"}
+	if err := repository.Put(context.Background(), in); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	got, err := repository.Get(context.Background(), 10, PriceQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	want := in
+	want.Version = 1
+	want.UpdatedAt = scheduleStart
+	if *got != want {
+		t.Errorf("got %+v, want %+v", *got, want)
+	}
+}
+
+func TestProductPriceRepositoryMultipleCurrencies(t *testing.T) {
+	client := newPropertyDatastoreClient()
+	repository := helperPropertyRepository(t, client)
+	ctx := context.Background()
+
+	for _, price := range []ProductPrice{
+		{ProductID: 10, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+		{ProductID: 10, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"},
+		{ProductID: 10, Price: MustParseMoney("13.99"), CurrencyCode: "USD"},
+	} {
+		if err := repository.Put(ctx, price); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	}
+
+	var tests = []struct {
+		query PriceQuery
+		want  string
+	}{
+		{PriceQuery{}, "13.99 USD"},
+		{PriceQuery{CurrencyCode: "USD"}, "13.99 USD"},
+		{PriceQuery{CurrencyCode: "EUR"}, "12.50 EUR"},
+	}
+
+	for _, tt := range tests {
+		got, err := repository.Get(ctx, 10, tt.query)
+		if err != nil {
+			t.Fatalf("%+v: unexpected error: %+v", tt.query, err)
+		}
+
+		if s := got.Price.String() + " " + got.CurrencyCode; s != tt.want || got.ProductID != 10 {
+			t.Errorf("%+v: got %d %s, want 10 %s", tt.query, got.ProductID, s, tt.want)
+		}
+	}
+
+	if _, err := repository.Get(ctx, 10, PriceQuery{CurrencyCode: "GBP"}); !errors.Is(err, ErrCurrencyNotFound) {
+		t.Errorf("got error %+v, want %+v", err, ErrCurrencyNotFound)
+	}
+}
+
+func TestProductPriceRepositoryPutKeepsSinglePrice(t *testing.T) {
+	client := newPropertyDatastoreClient()
+	repository := helperPropertyRepository(t, client)
+	ctx := context.Background()
+
+	// A product stored before prices were kept per currency
+	key := datastore.NameKey("test", repository.keyFromProductID(10), nil)
+	client.entities[key.String()] = []datastore.Property{
+		{Name: "product_id", Value: int64(10)},
+		{Name: "currency_code", Value: "USD"},
+		{Name: "price_units", Value: int64(1349)},
+		{Name: "price_exponent", Value: int64(2)},
+	}
+
+	if err := repository.Put(ctx, ProductPrice{ProductID: 10, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	got, err := repository.Get(ctx, 10, PriceQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if got.Price.String() != "13.49" || got.CurrencyCode != "USD" {
+		t.Errorf("got base price %s %s, want 13.49 USD", got.Price, got.CurrencyCode)
+	}
+}
+
+var legacyPriceTests = []struct {
+	name     string
+	price    float64
+	currency string
+	want     string
+}{
+	{"Shortest decimal of the float", 13.49, "USD", "13.49"},
+	{"Whole amount", 100, "USD", "100.00"},
+	{"Float noise is rounded away", 0.1 + 0.2, "USD", "0.30"},
+	{"Currency without minor units", 1500, "JPY", "1500"},
+}
+
+func TestProductPriceRepositoryReadsLegacyPrices(t *testing.T) {
+	for _, tt := range legacyPriceTests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newPropertyDatastoreClient()
+			repository := helperPropertyRepository(t, client)
+
+			key := datastore.NameKey("test", repository.keyFromProductID(10), nil)
+			client.entities[key.String()] = []datastore.Property{
+				{Name: "product_id", Value: int64(10)},
+				{Name: "price", Value: tt.price},
+				{Name: "currency_code", Value: tt.currency},
+			}
+
+			got, err := repository.Get(context.Background(), 10, PriceQuery{})
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			if got.Price.String() != tt.want || got.CurrencyCode != tt.currency || got.Version != 1 {
+				t.Errorf("got %s %s version %d, want %s %s version 1", got.Price, got.CurrencyCode, got.Version, tt.want, tt.currency)
+			}
+
+			// Reads leave the entity as it is, and the next put writes it
+			// in the decimal encoding
+			if client.puts != 0 {
+				t.Fatalf("got %d puts, want none from a read", client.puts)
+			}
+
+			err = repository.Put(context.Background(), ProductPrice{ProductID: 10, Price: MustParseMoney("5"), CurrencyCode: "EUR"})
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			for _, prop := range client.entities[key.String()] {
+				if prop.Name == "price" {
+					t.Errorf("legacy price property was not migrated")
+				}
+			}
+
+			again, err := repository.Get(context.Background(), 10, PriceQuery{})
+			if err != nil || again.Price != got.Price || again.CurrencyCode != got.CurrencyCode {
+				t.Errorf("got %+v %+v after migration, want %+v", again, err, got)
+			}
+		})
+	}
+}
+
+func TestProductPriceLoadRejectsBadProperties(t *testing.T) {
+	var tests = [][]datastore.Property{
+		{{Name: "price_units", Value: "1349"}},
+		{{Name: "price_units", Value: int64(1349)}, {Name: "price_exponent", Value: int64(-1)}},
+	}
+
+	for _, props := range tests {
+		var price ProductPrice
+		if err := price.Load(props); err == nil {
+			t.Errorf("%+v: expected error. none found", props)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package productaggregate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPriceConflict is returned when a scheduled price's window overlaps
+// another price's in the same currency
+var ErrPriceConflict = errors.New("price window conflicts with another price")
+
+// PriceConflictError is returned when a scheduled price's window overlaps
+// Existing. It matches ErrPriceConflict.
+type PriceConflictError struct {
+	Existing ProductPrice
+}
+
+func (e *PriceConflictError) Error() string {
+	return fmt.Sprintf("price window conflicts with the %s price %s", e.Existing.CurrencyCode, DescribeWindow(e.Existing))
+}
+
+// Is reports whether target is ErrPriceConflict
+func (e *PriceConflictError) Is(target error) bool {
+	return target == ErrPriceConflict
+}
+
+// DescribeWindow formats a price's effective window for messages, as
+// "effective from <start> until <end>"
+func DescribeWindow(price ProductPrice) string {
+	from, to := "the beginning", "indefinitely"
+	if price.EffectiveFrom != nil {
+		from = price.EffectiveFrom.Format(time.RFC3339)
+	}
+
+	if price.EffectiveTo != nil {
+		to = price.EffectiveTo.Format(time.RFC3339)
+	}
+
+	return fmt.Sprintf("effective from %s until %s", from, to)
+}
+
+// scheduled reports whether the price only applies within a window. A
+// product's unscheduled price in a currency applies whenever none of its
+// scheduled prices do.
+func (p ProductPrice) scheduled() bool {
+	return p.EffectiveFrom != nil || p.EffectiveTo != nil
+}
+
+// activeAt reports whether t is within the price's window. Windows include
+// their start and exclude their end.
+func (p ProductPrice) activeAt(t time.Time) bool {
+	if p.EffectiveFrom != nil && t.Before(*p.EffectiveFrom) {
+		return false
+	}
+
+	return p.EffectiveTo == nil || t.Before(*p.EffectiveTo)
+}
+
+// expiredAt reports whether the price's window ended at or before t
+func (p ProductPrice) expiredAt(t time.Time) bool {
+	return p.EffectiveTo != nil && !t.Before(*p.EffectiveTo)
+}
+
+// sameWindow reports whether two prices apply over exactly the same times
+func (p ProductPrice) sameWindow(other ProductPrice) bool {
+	return timesEqual(p.EffectiveFrom, other.EffectiveFrom) && timesEqual(p.EffectiveTo, other.EffectiveTo)
+}
+
+// overlaps reports whether two prices' windows share any time
+func (p ProductPrice) overlaps(other ProductPrice) bool {
+	return startsBefore(p.EffectiveFrom, other.EffectiveTo) && startsBefore(other.EffectiveFrom, p.EffectiveTo)
+}
+
+// startsBefore reports whether a window starting at from begins before one
+// ending at to. A nil from is the beginning of time, and a nil to the end.
+func startsBefore(from *time.Time, to *time.Time) bool {
+	return from == nil || to == nil || from.Before(*to)
+}
+
+func timesEqual(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Equal(*b)
+}
@@ -0,0 +1,237 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var scheduleStart = time.Date(2020, 11, 27, 0, 0, 0, 0, time.UTC)
+
+func scheduleTime(days int) *time.Time {
+	t := scheduleStart.AddDate(0, 0, days)
+	return &t
+}
+
+// helperScheduledPrices prices product 10 at 20.00 USD, with a sale at 15.00
+// from day 0 to day 3 and another at 18.00 from day 10 onwards
+func helperScheduledPrices(t *testing.T) *InMemoryProductPriceRepository {
+	dayBefore := func() time.Time { return scheduleStart.AddDate(0, 0, -1) }
+
+	return helperPriceRepository(t, dayBefore,
+		ProductPrice{ProductID: 10, Price: MustParseMoney("20.00"), CurrencyCode: "USD"},
+		ProductPrice{ProductID: 10, Price: MustParseMoney("15.00"), CurrencyCode: "USD", EffectiveFrom: scheduleTime(0), EffectiveTo: scheduleTime(3)},
+		ProductPrice{ProductID: 10, Price: MustParseMoney("18.00"), CurrencyCode: "USD", EffectiveFrom: scheduleTime(10)},
+	)
+}
+
+var scheduledPriceTests = []struct {
+	name string
+	at   *time.Time
+	want string
+}{
+	{"Before the sale", scheduleTime(-1), "20.00"},
+	{"Sale starts inclusively", scheduleTime(0), "15.00"},
+	{"During the sale", scheduleTime(2), "15.00"},
+	{"Sale ends exclusively", scheduleTime(3), "20.00"},
+	{"Open ended sale", scheduleTime(400), "18.00"},
+	{"Now", nil, "20.00"},
+}
+
+func TestScheduledPrices(t *testing.T) {
+	repository := helperScheduledPrices(t)
+
+	for _, tt := range scheduledPriceTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query PriceQuery
+			if tt.at != nil {
+				query.At = *tt.at
+			}
+
+			price, err := repository.Get(context.Background(), 10, query)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if price.Price.String() != tt.want {
+				t.Errorf("got %s, want %s", price.Price, tt.want)
+			}
+		})
+	}
+}
+
+var priceConflictTests = []struct {
+	name     string
+	price    ProductPrice
+	conflict bool
+}{
+	{"Overlaps the start", ProductPrice{EffectiveFrom: scheduleTime(-2), EffectiveTo: scheduleTime(1)}, true},
+	{"Inside", ProductPrice{EffectiveFrom: scheduleTime(1), EffectiveTo: scheduleTime(2)}, true},
+	{"No start", ProductPrice{EffectiveTo: scheduleTime(11)}, true},
+	{"Touching windows", ProductPrice{EffectiveFrom: scheduleTime(3), EffectiveTo: scheduleTime(10)}, false},
+	{"Same window replaces", ProductPrice{EffectiveFrom: scheduleTime(0), EffectiveTo: scheduleTime(3)}, false},
+	{"Unscheduled replaces", ProductPrice{}, false},
+	{"Other currency", ProductPrice{CurrencyCode: "EUR", EffectiveFrom: scheduleTime(1)}, false},
+}
+
+func TestScheduledPriceConflicts(t *testing.T) {
+	for _, tt := range priceConflictTests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := helperScheduledPrices(t)
+
+			price := tt.price
+			price.ProductID = 10
+			price.Price = MustParseMoney("1.00")
+			if price.CurrencyCode == "" {
+				price.CurrencyCode = "USD"
+			}
+
+			err := repository.Put(context.Background(), price)
+			if errors.Is(err, ErrPriceConflict) != tt.conflict {
+				t.Errorf("got error %+v, want conflict: %t", err, tt.conflict)
+			}
+		})
+	}
+}
+
+func TestScheduledPriceConflictReplacesNothing(t *testing.T) {
+	repository := helperScheduledPrices(t)
+
+	price := ProductPrice{ProductID: 10, Price: MustParseMoney("1.00"), CurrencyCode: "USD", EffectiveFrom: scheduleTime(1), EffectiveTo: scheduleTime(2)}
+	repository.Put(context.Background(), price)
+
+	got, err := repository.Get(context.Background(), 10, PriceQuery{At: *scheduleTime(1)})
+	if err != nil || got.Price.String() != "15.00" {
+		t.Errorf("got %+v %+v, want 15.00", got, err)
+	}
+}
+
+func TestScheduledPricesExpire(t *testing.T) {
+	repository := helperScheduledPrices(t)
+
+	// Once the sale is over, it is dropped on the next write
+	repository.now = func() time.Time { return *scheduleTime(5) }
+	repository.Put(context.Background(), ProductPrice{ProductID: 10, Price: MustParseMoney("9.00"), CurrencyCode: "EUR"})
+
+	if prices := repository.entities[10].Prices; len(prices) != 3 {
+		t.Errorf("got %d prices, want 3: %+v", len(prices), prices)
+	}
+
+	// The dropped sale is recorded, before the write which dropped it
+	page, err := repository.History(context.Background(), 10, PriceHistoryQuery{From: *scheduleTime(5)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := "[expire 15.00->- 00:00 anonymous][-->9.00 00:00 anonymous]"
+	if got := describeChanges(page.Changes); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if from := page.Changes[0].EffectiveFrom; from == nil || !from.Equal(*scheduleTime(0)) {
+		t.Errorf("got expired price from %v, want %s", from, scheduleTime(0))
+	}
+}
+
+func TestScheduledPriceRoundTrip(t *testing.T) {
+	client := newPropertyDatastoreClient()
+	repository := helperPropertyRepository(t, client)
+	repository.now = func() time.Time { return scheduleStart }
+
+	in := ProductPrice{ProductID: 10, Price: MustParseMoney("15.00"), CurrencyCode: "USD", EffectiveFrom: scheduleTime(1), EffectiveTo: scheduleTime(3)}
+	if err := repository.Put(context.Background(), in); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := repository.Get(context.Background(), 10, PriceQuery{}); !errors.Is(err, ErrPriceNotFound) {
+		t.Errorf("got error %+v before the window, want %+v", err, ErrPriceNotFound)
+	}
+
+	got, err := repository.Get(context.Background(), 10, PriceQuery{At: *scheduleTime(2)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !got.sameWindow(in) || got.Price != in.Price {
+		t.Errorf("got %+v, want %+v", got, in)
+	}
+}
+
+var scheduledPriceRequestTests = []struct {
+	name   string
+	method string
+	target string
+	body   string
+	want   httpWant
+}{
+	{
+		name:   "Scheduled price is not yet active",
+		method: "GET",
+		target: "http://example.com/123",
+		want:   httpWant{code: http.StatusOK, body: `{"product_id":123,"name":"Picard","current_price":{"value":20.00,"currency_code":"USD"}}`},
+	},
+	{
+		name:   "Preview",
+		method: "GET",
+		target: "http://example.com/123?at=2030-01-02T00:00:00-05:00",
+		want:   httpWant{code: http.StatusOK, body: `{"product_id":123,"name":"Picard","current_price":{"value":15.00,"currency_code":"USD","effective_from":"2030-01-01T00:00:00Z","effective_to":"2030-02-01T00:00:00Z"}}`},
+	},
+	{
+		name:   "Invalid preview time",
+		method: "GET",
+		target: "http://example.com/123?at=tomorrow",
+		want:   httpWant{code: http.StatusBadRequest, body: "Invalid at timestamp \"tomorrow\", want RFC 3339\n"},
+	},
+	{
+		name:   "Overlapping window",
+		method: "PUT",
+		target: "http://example.com/123",
+		body:   `{"value":12,"currency_code":"USD","effective_from":"2030-01-31T00:00:00Z"}`,
+		want:   httpWant{code: http.StatusConflict, body: "Price conflicts with the USD price effective from 2030-01-01T00:00:00Z until 2030-02-01T00:00:00Z\n"},
+	},
+	{
+		name:   "Invalid window",
+		method: "PUT",
+		target: "http://example.com/123",
+		body:   `{"value":12,"currency_code":"USD","effective_from":"2030-03-01T00:00:00Z","effective_to":"2030-02-01T00:00:00Z"}`,
+		want:   httpWant{code: http.StatusBadRequest, body: "Request body contains an invalid value for the \"effective_to\" field: must be after effective_from\n"},
+	},
+	{
+		name:   "Invalid timestamp",
+		method: "PUT",
+		target: "http://example.com/123",
+		body:   `{"value":12,"currency_code":"USD","effective_from":"tomorrow"}`,
+		want:   httpWant{code: http.StatusBadRequest, body: "Request body contains an invalid timestamp tomorrow, want RFC 3339\n"},
+	},
+}
+
+func TestRequestHandlerScheduledPrices(t *testing.T) {
+	for _, tt := range scheduledPriceRequestTests {
+		t.Run(tt.name, func(t *testing.T) {
+			rh := newRequestHandler(NewInMemoryProductPriceRepository(), StubNameRepository{nr: nameResult{name: "Picard"}}, SourceOptions{}, SourceOptions{})
+
+			for _, body := range []string{
+				`{"value":20,"currency_code":"USD"}`,
+				`{"value":15,"currency_code":"USD","effective_from":"2030-01-01T00:00:00Z","effective_to":"2030-02-01T00:00:00Z"}`,
+			} {
+				w := httptest.NewRecorder()
+				rh.HandleRequest(w, dummyRequest("PUT", body))
+				if w.Code != http.StatusOK {
+					t.Fatalf("PUT %s: got %d %s", body, w.Code, w.Body.String())
+				}
+			}
+
+			// Requests are built here, since their bodies can be read only once
+			w := httptest.NewRecorder()
+			rh.HandleRequest(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+
+			if w.Code != tt.want.code || w.Body.String() != tt.want.body {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body.String(), tt.want.code, tt.want.body)
+			}
+		})
+	}
+}
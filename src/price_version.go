@@ -0,0 +1,92 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrVersionMismatch is returned when a Put expects a product's prices to be
+// at a version they are no longer at
+var ErrVersionMismatch = errors.New("product prices have changed")
+
+// AnyVersion is a ProductPrice.Version which only requires that the product
+// has prices, whatever their version
+const AnyVersion int64 = -1
+
+// VersionMismatchError is returned when a Put expects a product's prices to
+// be at a version other than Current. It matches ErrVersionMismatch.
+type VersionMismatchError struct {
+	Expected int64
+	Current  int64
+}
+
+func (e *VersionMismatchError) Error() string {
+	if e.Current == 0 {
+		return "product has no prices"
+	}
+
+	return fmt.Sprintf("product prices are at version %d, not %d", e.Current, e.Expected)
+}
+
+// Is reports whether target is ErrVersionMismatch
+func (e *VersionMismatchError) Is(target error) bool {
+	return target == ErrVersionMismatch
+}
+
+// checkVersion returns a *VersionMismatchError unless the entity is at the
+// version expected. A zero version is always met, and no other is by
+// deleted prices.
+func (e *priceEntity) checkVersion(expected int64) error {
+	switch {
+	case expected == 0:
+		return nil
+
+	case e.deleted():
+		return &VersionMismatchError{Expected: expected}
+
+	case e.Version > 0 && (expected == AnyVersion || expected == e.Version):
+		return nil
+	}
+
+	return &VersionMismatchError{Expected: expected, Current: e.Version}
+}
+
+// errMultipleETags is returned for If-Match headers listing several entity
+// tags, since a change can only expect one version
+var errMultipleETags = errors.New("If-Match must hold a single entity tag or *")
+
+// parseIfMatch reads the entity tag an If-Match header expects, or "*".
+// Entity tags are opaque, and only ever compared whole with the product's
+// current ETag; weak ones never match, as RFC 7232 requires.
+func parseIfMatch(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, ",") {
+		return "", errMultipleETags
+	}
+
+	return value, nil
+}
+
+// currentETag computes the ETag a GET of the product would return now,
+// along with the version of its prices. Both are empty when the product has
+// no prices. The errors to blame are returned when the product could not be
+// assembled.
+func (rh RequestHandler) currentETag(ctx context.Context, productID int) (string, int64, []error) {
+	product, _, failures := aggregateProduct(ctx, ProductQuery{ProductID: productID}, rh.sources)
+	if failures != nil {
+		return "", 0, failures
+	}
+
+	if product.CurrentPrice == nil || product.CurrentPrice.Version == 0 {
+		return "", 0, nil
+	}
+
+	etag, err := productETag(product)
+	if err != nil {
+		return "", 0, []error{err}
+	}
+
+	return etag, product.CurrentPrice.Version, nil
+}
@@ -0,0 +1,172 @@
+package productaggregate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var parseIfMatchTests = []struct {
+	value string
+	want  string
+	err   error
+}{
+	{`"3-231fc1c1ce9ab070"`, `"3-231fc1c1ce9ab070"`, nil},
+	{` "3-231fc1c1ce9ab070" `, `"3-231fc1c1ce9ab070"`, nil},
+	{`*`, `*`, nil},
+	{`W/"3-231fc1c1ce9ab070"`, `W/"3-231fc1c1ce9ab070"`, nil},
+	{`"3", "4"`, "", errMultipleETags},
+}
+
+func TestParseIfMatch(t *testing.T) {
+	for _, tt := range parseIfMatchTests {
+		got, err := parseIfMatch(tt.value)
+		if got != tt.want || err != tt.err {
+			t.Errorf("parseIfMatch(%q) = %q, %+v, want %q, %+v", tt.value, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+var priceVersionTests = []struct {
+	name    string
+	puts    int
+	version int64
+	current int64
+}{
+	{"Unconditional", 2, 0, 0},
+	{"Current version", 2, 2, 0},
+	{"Stale version", 2, 1, 2},
+	{"Any version", 1, AnyVersion, 0},
+	{"Any version without prices", 0, AnyVersion, 0},
+	{"Version without prices", 0, 1, 0},
+}
+
+func TestPriceVersions(t *testing.T) {
+	for _, tt := range priceVersionTests {
+		t.Run(tt.name, func(t *testing.T) {
+			repositories := map[string]ProductPriceRepository{
+				"memory":    NewInMemoryProductPriceRepository(),
+				"datastore": helperPropertyRepository(t, newPropertyDatastoreClient()),
+			}
+
+			for backend, repository := range repositories {
+				ctx := context.Background()
+				for i := 0; i < tt.puts; i++ {
+					if err := repository.Put(ctx, ProductPrice{ProductID: 10, Price: NewMoney(int64(i+1), 0), CurrencyCode: "USD"}); err != nil {
+						t.Fatalf("%s: unexpected error: %+v", backend, err)
+					}
+				}
+
+				err := repository.Put(ctx, ProductPrice{ProductID: 10, Price: NewMoney(9, 0), CurrencyCode: "USD", Version: tt.version})
+
+				wantMismatch := tt.current > 0 || (tt.puts == 0 && tt.version != 0)
+				var mismatch *VersionMismatchError
+				if errors.As(err, &mismatch) != wantMismatch || (err != nil && !wantMismatch) {
+					t.Fatalf("%s: got error %+v, want mismatch %t", backend, err, wantMismatch)
+				}
+
+				if wantMismatch {
+					if mismatch.Current != tt.current || !errors.Is(err, ErrVersionMismatch) {
+						t.Errorf("%s: got %+v, want current version %d", backend, mismatch, tt.current)
+					}
+					continue
+				}
+
+				got, err := repository.Get(ctx, 10, PriceQuery{})
+				if err != nil || got.Price.String() != "9" || got.Version != int64(tt.puts+1) {
+					t.Errorf("%s: got %+v %+v, want 9 at version %d", backend, got, err, tt.puts+1)
+				}
+			}
+		})
+	}
+}
+
+// An ifMatch or wantETag of "GET" is the ETag the product was read with
+var priceVersionRequestTests = []struct {
+	name       string
+	ifMatch    string
+	require    bool
+	wantCode   int
+	wantETag   string
+	wantPrefix string
+}{
+	{"Unconditional", "", false, http.StatusOK, "", "Product updated"},
+	{"Required", "", true, http.StatusPreconditionRequired, "", "If-Match header is required"},
+	{"Matches the GET ETag", "GET", true, http.StatusOK, "", "Product updated"},
+	{"Any version", `*`, true, http.StatusOK, "", "Product updated"},
+	{"Version alone", `"1"`, false, http.StatusPreconditionFailed, "GET", "Product price has changed since it was read"},
+	{"Right version, wrong hash", `"1-0000000000000000"`, false, http.StatusPreconditionFailed, "GET", "Product price has changed since it was read"},
+	{"Stale", `"7-0000000000000000"`, false, http.StatusPreconditionFailed, "GET", "Product price has changed since it was read"},
+	{"Weak", "W/GET", false, http.StatusPreconditionFailed, "GET", "Product price has changed since it was read"},
+	{"Several", `"1", "2"`, false, http.StatusBadRequest, "", "If-Match must hold a single entity tag or *"},
+}
+
+func TestRequestHandlerPriceVersions(t *testing.T) {
+	for _, tt := range priceVersionRequestTests {
+		t.Run(tt.name, func(t *testing.T) {
+			prices := helperPriceRepository(t, nil, ProductPrice{ProductID: 123, Price: MustParseMoney("13.49"), CurrencyCode: "USD"})
+
+			rh := newRequestHandler(prices, StubNameRepository{nr: nameResult{name: "Picard"}}, SourceOptions{}, SourceOptions{})
+			rh.requireIfMatch = tt.require
+
+			etag := helperGetETag(t, rh, 123)
+			if !strings.HasPrefix(etag, `"1-`) {
+				t.Fatalf("got ETag %q on GET, want one for version 1", etag)
+			}
+
+			r := httptest.NewRequest("PUT", "http://example.com/123", strings.NewReader(`{"value": 12.99, "currency_code": "USD"}`))
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", strings.Replace(tt.ifMatch, "GET", etag, 1))
+			}
+
+			w := httptest.NewRecorder()
+			rh.HandleRequest(w, r)
+
+			if w.Code != tt.wantCode || !strings.HasPrefix(w.Body.String(), tt.wantPrefix) {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body.String(), tt.wantCode, tt.wantPrefix)
+			}
+
+			wantETag := strings.Replace(tt.wantETag, "GET", etag, 1)
+			if got := w.Header().Get("ETag"); got != wantETag {
+				t.Errorf("got ETag %q, want %q", got, wantETag)
+			}
+		})
+	}
+}
+
+func TestRequestHandlerIfMatchNameChanged(t *testing.T) {
+	prices := helperPriceRepository(t, nil, ProductPrice{ProductID: 123, Price: MustParseMoney("13.49"), CurrencyCode: "USD"})
+
+	rh := newRequestHandler(prices, StubNameRepository{nr: nameResult{name: "Picard"}}, SourceOptions{}, SourceOptions{})
+	etag := helperGetETag(t, rh, 123)
+
+	// The ETag covers the whole product, so a new name fails the match even
+	// though the prices are at the same version
+	rh = newRequestHandler(prices, StubNameRepository{nr: nameResult{name: "Jean-Luc Picard"}}, SourceOptions{}, SourceOptions{})
+	r := httptest.NewRequest("PUT", "http://example.com/123", strings.NewReader(`{"value": 12.99, "currency_code": "USD"}`))
+	r.Header.Set("If-Match", etag)
+
+	w := httptest.NewRecorder()
+	rh.HandleRequest(w, r)
+
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") == etag || !strings.HasPrefix(w.Header().Get("ETag"), `"1-`) {
+		t.Errorf("got %d with ETag %q, want 412 with a new ETag at version 1", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestRequestHandlerIfMatchWithoutPrice(t *testing.T) {
+	rh := newRequestHandler(NewInMemoryProductPriceRepository(), StubNameRepository{}, SourceOptions{}, SourceOptions{})
+
+	r := httptest.NewRequest("PUT", "http://example.com/123", strings.NewReader(`{"value": 12.99, "currency_code": "USD"}`))
+	r.Header.Set("If-Match", "*")
+
+	w := httptest.NewRecorder()
+	rh.HandleRequest(w, r)
+
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != "" {
+		t.Errorf("got %d with ETag %q, want 412 without one", w.Code, w.Header().Get("ETag"))
+	}
+}
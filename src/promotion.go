@@ -0,0 +1,270 @@
+package productaggregate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of Promotion
+const (
+	// PromotionPercentOff takes PercentOff percent off the regular price
+	PromotionPercentOff = "percent_off"
+
+	// PromotionFixedOff takes AmountOff off regular prices in the same
+	// currency
+	PromotionFixedOff = "fixed_off"
+
+	// PromotionBOGO flags a buy one, get one offer. It leaves the price as
+	// it is.
+	PromotionBOGO = "bogo"
+)
+
+// Promotion is an offer on a product, such as "Circle offer 10% off"
+type Promotion struct {
+	ID        string `json:"id"`
+	ProductID int    `json:"-"`
+	Label     string `json:"label"`
+	Kind      string `json:"kind"`
+
+	PercentOff   *Money `json:"percent_off,omitempty"`
+	AmountOff    *Money `json:"amount_off,omitempty"`
+	CurrencyCode string `json:"currency_code,omitempty"`
+
+	// StartsAt and EndsAt limit when the promotion applies. Either may be
+	// nil. StartsAt is inclusive and EndsAt exclusive.
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+}
+
+// activeAt reports whether the promotion applies at t
+func (p Promotion) activeAt(t time.Time) bool {
+	if p.StartsAt != nil && t.Before(*p.StartsAt) {
+		return false
+	}
+
+	return p.EndsAt == nil || t.Before(*p.EndsAt)
+}
+
+// validate reports the first problem with the promotion, if any
+func (p Promotion) validate() error {
+	if p.ID == "" {
+		return fmt.Errorf("promotion for product %d has no id", p.ProductID)
+	}
+
+	if p.Label == "" {
+		return fmt.Errorf("promotion %s has no label", p.ID)
+	}
+
+	switch p.Kind {
+	case PromotionPercentOff:
+		if p.PercentOff == nil || p.PercentOff.Sign() <= 0 || p.PercentOff.Cmp(NewMoney(100, 0)) > 0 {
+			return fmt.Errorf("promotion %s percent_off must be more than 0 and at most 100", p.ID)
+		}
+
+	case PromotionFixedOff:
+		if p.AmountOff == nil || p.AmountOff.Sign() <= 0 {
+			return fmt.Errorf("promotion %s amount_off must be positive", p.ID)
+		}
+
+		if _, ok := LookupCurrency(p.CurrencyCode); !ok {
+			return fmt.Errorf("promotion %s currency_code %q is not an ISO 4217 currency code", p.ID, p.CurrencyCode)
+		}
+
+	case PromotionBOGO:
+
+	default:
+		return fmt.Errorf("promotion %s has unknown kind %q, want %s, %s or %s", p.ID, p.Kind, PromotionPercentOff, PromotionFixedOff, PromotionBOGO)
+	}
+
+	if p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt) {
+		return fmt.Errorf("promotion %s ends_at must be after starts_at", p.ID)
+	}
+
+	return nil
+}
+
+// apply returns the price with the promotion applied, rounded half away
+// from zero to the currency's minor unit and never below zero. It reports
+// false when the promotion does not apply to prices in that currency.
+func (p Promotion) apply(price ProductPrice) (ProductPrice, bool) {
+	var value Money
+	var err error
+
+	switch p.Kind {
+	case PromotionPercentOff:
+		factor := new(big.Rat).Sub(big.NewRat(1, 1), new(big.Rat).Quo(p.PercentOff.Rat(), big.NewRat(100, 1)))
+		value, err = price.Price.Mul(factor, CurrencyExponent(price.CurrencyCode))
+
+	case PromotionFixedOff:
+		if p.CurrencyCode != price.CurrencyCode {
+			return price, false
+		}
+
+		value, err = price.Price.Sub(*p.AmountOff)
+		if err == nil {
+			value, err = value.RoundTo(price.CurrencyCode)
+		}
+
+	case PromotionBOGO:
+		return price, true
+
+	default:
+		return price, false
+	}
+
+	if err != nil {
+		return price, false
+	}
+
+	if value.Sign() < 0 {
+		value = NewMoney(0, CurrencyExponent(price.CurrencyCode))
+	}
+
+	// The conversion, if any, describes the regular price
+	discounted := price
+	discounted.Price = value
+	discounted.Conversion = nil
+	return discounted, true
+}
+
+// bestPromotion picks the promotion giving the lowest price. Promotions
+// which lower the price win over those which only flag an offer, and ties
+// go to the earliest. It reports false when none apply.
+func bestPromotion(price ProductPrice, promotions []Promotion) (Promotion, ProductPrice, bool) {
+	var best Promotion
+	var bestPrice ProductPrice
+	found := false
+
+	for _, promotion := range promotions {
+		discounted, ok := promotion.apply(price)
+		if !ok {
+			continue
+		}
+
+		cmp := discounted.Price.Cmp(bestPrice.Price)
+		better := cmp < 0 || (cmp == 0 && best.Kind == PromotionBOGO && promotion.Kind != PromotionBOGO)
+		if !found || better {
+			best, bestPrice, found = promotion, discounted, true
+		}
+	}
+
+	return best, bestPrice, found
+}
+
+// PromotionRepository finds the promotions on a product
+type PromotionRepository interface {
+	// Active lists the product's promotions which apply at time at
+	Active(ctx context.Context, productID int, at time.Time) ([]Promotion, error)
+}
+
+// InMemoryPromotionRepository keeps promotions in process memory. It is safe
+// for concurrent use.
+type InMemoryPromotionRepository struct {
+	mu         sync.RWMutex
+	promotions map[int][]Promotion
+}
+
+// NewInMemoryPromotionRepository creates a new, empty InMemoryPromotionRepository
+func NewInMemoryPromotionRepository() *InMemoryPromotionRepository {
+	return &InMemoryPromotionRepository{
+		promotions: make(map[int][]Promotion),
+	}
+}
+
+// LoadPromotionsFile reads a JSON array of promotions, each with the
+// product_id it applies to, into a new InMemoryPromotionRepository
+func LoadPromotionsFile(path string) (*InMemoryPromotionRepository, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading promotions file: %s", err)
+	}
+
+	var entries []struct {
+		ProductID int `json:"product_id"`
+		Promotion
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parsing promotions file %s: %s", path, err)
+	}
+
+	repository := NewInMemoryPromotionRepository()
+	for _, entry := range entries {
+		promotion := entry.Promotion
+		promotion.ProductID = entry.ProductID
+		if err := repository.Put(promotion); err != nil {
+			return nil, fmt.Errorf("promotions file %s: %s", path, err)
+		}
+	}
+
+	return repository, nil
+}
+
+// Put adds a promotion, or replaces the product's promotion with the same ID
+func (m *InMemoryPromotionRepository) Put(promotion Promotion) error {
+	if err := promotion.validate(); err != nil {
+		return err
+	}
+
+	if promotion.Kind == PromotionFixedOff {
+		currency, _ := LookupCurrency(promotion.CurrencyCode)
+		promotion.CurrencyCode = currency.Code
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	promotions := m.promotions[promotion.ProductID]
+	for i := range promotions {
+		if promotions[i].ID == promotion.ID {
+			promotions[i] = promotion
+			return nil
+		}
+	}
+
+	promotions = append(promotions, promotion)
+	sort.Slice(promotions, func(i, j int) bool {
+		return promotions[i].ID < promotions[j].ID
+	})
+
+	m.promotions[promotion.ProductID] = promotions
+	return nil
+}
+
+// Active lists the product's promotions which apply at time at
+func (m *InMemoryPromotionRepository) Active(ctx context.Context, productID int, at time.Time) ([]Promotion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var active []Promotion
+	for _, promotion := range m.promotions[productID] {
+		if promotion.activeAt(at) {
+			active = append(active, promotion)
+		}
+	}
+
+	return active, nil
+}
+
+// applyPromotion applies the best of the promotions contributed by the
+// promotion source to the product's price, keeping the price before it as
+// the regular price
+func (p *Product) applyPromotion() {
+	if p.CurrentPrice == nil || len(p.promotions) == 0 {
+		return
+	}
+
+	promotion, price, ok := bestPromotion(*p.CurrentPrice, p.promotions)
+	if !ok {
+		return
+	}
+
+	p.RegularPrice = p.CurrentPrice
+	p.CurrentPrice = &price
+	p.Promotion = &promotion
+}
@@ -0,0 +1,203 @@
+package productaggregate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var promotionApplyTests = []struct {
+	name      string
+	promotion Promotion
+	price     string
+	currency  string
+	want      string
+	applies   bool
+}{
+	{"Percent off", Promotion{Kind: PromotionPercentOff, PercentOff: moneyPointer("10")}, "13.49", "USD", "12.14", true},
+	{"Percent off rounds half away from zero", Promotion{Kind: PromotionPercentOff, PercentOff: moneyPointer("50")}, "0.05", "USD", "0.03", true},
+	{"Percent off keeps the currency's minor unit", Promotion{Kind: PromotionPercentOff, PercentOff: moneyPointer("12.5")}, "1500", "JPY", "1313", true},
+	{"Whole price off", Promotion{Kind: PromotionPercentOff, PercentOff: moneyPointer("100")}, "13.49", "USD", "0.00", true},
+	{"Fixed off", Promotion{Kind: PromotionFixedOff, AmountOff: moneyPointer("5"), CurrencyCode: "USD"}, "13.49", "USD", "8.49", true},
+	{"Fixed off never goes below zero", Promotion{Kind: PromotionFixedOff, AmountOff: moneyPointer("20"), CurrencyCode: "USD"}, "13.49", "USD", "0.00", true},
+	{"Fixed off in another currency", Promotion{Kind: PromotionFixedOff, AmountOff: moneyPointer("5"), CurrencyCode: "EUR"}, "13.49", "USD", "13.49", false},
+	{"BOGO leaves the price", Promotion{Kind: PromotionBOGO}, "13.49", "USD", "13.49", true},
+}
+
+func TestPromotionApply(t *testing.T) {
+	for _, tt := range promotionApplyTests {
+		t.Run(tt.name, func(t *testing.T) {
+			price := ProductPrice{ProductID: 1, Price: MustParseMoney(tt.price), CurrencyCode: tt.currency}
+
+			got, applies := tt.promotion.apply(price)
+			if got.Price.String() != tt.want || applies != tt.applies {
+				t.Errorf("got %s %t, want %s %t", got.Price, applies, tt.want, tt.applies)
+			}
+		})
+	}
+}
+
+var bestPromotionTests = []struct {
+	name       string
+	promotions []Promotion
+	want       string
+}{
+	{
+		name:       "None",
+		promotions: nil,
+		want:       "",
+	},
+	{
+		name: "Lowest price wins",
+		promotions: []Promotion{
+			{ID: "a", Kind: PromotionPercentOff, PercentOff: moneyPointer("10")},
+			{ID: "b", Kind: PromotionFixedOff, AmountOff: moneyPointer("5"), CurrencyCode: "USD"},
+		},
+		want: "b",
+	},
+	{
+		name: "Discount wins over BOGO",
+		promotions: []Promotion{
+			{ID: "a", Kind: PromotionBOGO},
+			{ID: "b", Kind: PromotionPercentOff, PercentOff: moneyPointer("1")},
+		},
+		want: "b",
+	},
+	{
+		name: "Ties go to the first",
+		promotions: []Promotion{
+			{ID: "a", Kind: PromotionFixedOff, AmountOff: moneyPointer("1.349"), CurrencyCode: "USD"},
+			{ID: "b", Kind: PromotionPercentOff, PercentOff: moneyPointer("10")},
+		},
+		want: "a",
+	},
+	{
+		name: "Inapplicable promotions are skipped",
+		promotions: []Promotion{
+			{ID: "a", Kind: PromotionFixedOff, AmountOff: moneyPointer("5"), CurrencyCode: "EUR"},
+			{ID: "b", Kind: PromotionBOGO},
+		},
+		want: "b",
+	},
+}
+
+func TestBestPromotion(t *testing.T) {
+	price := ProductPrice{ProductID: 1, Price: MustParseMoney("13.49"), CurrencyCode: "USD"}
+
+	for _, tt := range bestPromotionTests {
+		t.Run(tt.name, func(t *testing.T) {
+			best, _, ok := bestPromotion(price, tt.promotions)
+			if best.ID != tt.want || ok != (tt.want != "") {
+				t.Errorf("got %q %t, want %q", best.ID, ok, tt.want)
+			}
+		})
+	}
+}
+
+var promotionValidateTests = []struct {
+	promotion Promotion
+	want      string
+}{
+	{Promotion{Label: "x", Kind: PromotionBOGO}, "has no id"},
+	{Promotion{ID: "p", Kind: PromotionBOGO}, "has no label"},
+	{Promotion{ID: "p", Label: "x", Kind: "free"}, `unknown kind "free"`},
+	{Promotion{ID: "p", Label: "x", Kind: PromotionPercentOff, PercentOff: moneyPointer("101")}, "percent_off must be more than 0 and at most 100"},
+	{Promotion{ID: "p", Label: "x", Kind: PromotionFixedOff, AmountOff: moneyPointer("0"), CurrencyCode: "USD"}, "amount_off must be positive"},
+	{Promotion{ID: "p", Label: "x", Kind: PromotionFixedOff, AmountOff: moneyPointer("1"), CurrencyCode: "XYZ"}, "not an ISO 4217 currency code"},
+	{Promotion{ID: "p", Label: "x", Kind: PromotionBOGO, StartsAt: scheduleTime(1), EndsAt: scheduleTime(1)}, "ends_at must be after starts_at"},
+}
+
+func TestPromotionValidate(t *testing.T) {
+	for _, tt := range promotionValidateTests {
+		err := tt.promotion.validate()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: got %+v, want an error containing %q", tt.promotion, err, tt.want)
+		}
+	}
+}
+
+func TestLoadPromotionsFile(t *testing.T) {
+	repository, err := LoadPromotionsFile("testdata/promotions.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var tests = []struct {
+		productID int
+		at        time.Time
+		want      []string
+	}{
+		{13860428, time.Date(2020, 11, 26, 0, 0, 0, 0, time.UTC), []string{"five-off"}},
+		{13860428, time.Date(2020, 11, 27, 0, 0, 0, 0, time.UTC), []string{"circle-10", "five-off"}},
+		{54456119, time.Date(2020, 11, 27, 0, 0, 0, 0, time.UTC), []string{"bogo"}},
+		{1, time.Date(2020, 11, 27, 0, 0, 0, 0, time.UTC), nil},
+	}
+
+	for _, tt := range tests {
+		promotions, err := repository.Active(context.Background(), tt.productID, tt.at)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		var got []string
+		for _, promotion := range promotions {
+			got = append(got, promotion.ID)
+
+			if promotion.ProductID != tt.productID {
+				t.Errorf("promotion %s is for product %d, want %d", promotion.ID, promotion.ProductID, tt.productID)
+			}
+		}
+
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("product %d at %s: got %v, want %v", tt.productID, tt.at, got, tt.want)
+		}
+	}
+}
+
+var promotionRequestTests = []struct {
+	url  string
+	want string
+}{
+	{
+		url:  "http://example.com/13860428?at=2020-11-26T00:00:00Z",
+		want: `{"product_id":13860428,"name":"Picard","current_price":{"value":8.49,"currency_code":"USD"},"regular_price":{"value":13.49,"currency_code":"USD"},"promotion":{"id":"five-off","label":"$5 off","kind":"fixed_off","amount_off":5.00,"currency_code":"USD"}}`,
+	},
+	{
+		url:  "http://example.com/13860428?at=2020-11-26T00:00:00Z&currency=EUR",
+		want: `{"product_id":13860428,"name":"Picard","current_price":{"value":12.50,"currency_code":"EUR"}}`,
+	},
+	{
+		url:  "http://example.com/54456119",
+		want: `{"product_id":54456119,"name":"Picard","current_price":{"value":13.49,"currency_code":"USD"},"regular_price":{"value":13.49,"currency_code":"USD"},"promotion":{"id":"bogo","label":"Buy one, get one free","kind":"bogo"}}`,
+	},
+}
+
+func TestRequestHandlerPromotions(t *testing.T) {
+	prices := helperPriceRepository(t, nil,
+		ProductPrice{ProductID: 13860428, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+		ProductPrice{ProductID: 54456119, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+		ProductPrice{ProductID: 13860428, Price: MustParseMoney("12.50"), CurrencyCode: "EUR"},
+	)
+
+	promotions, err := LoadPromotionsFile("testdata/promotions.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	rh := newRequestHandler(prices, StubNameRepository{nr: nameResult{name: "Picard"}}, SourceOptions{}, SourceOptions{})
+	rh.RegisterSource(SourcePromotion, NewPromotionSource(promotions), SourceOptions{})
+
+	for _, tt := range promotionRequestTests {
+		t.Run(tt.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rh.HandleRequest(w, httptest.NewRequest("GET", tt.url, nil))
+
+			if w.Code != http.StatusOK || w.Body.String() != tt.want {
+				t.Errorf("got %d %s, want 200 %s", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
@@ -8,10 +8,10 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/golang/gddo/httputil/header"
 )
@@ -19,47 +19,165 @@ import (
 // RequestHandler handles incoming product requests
 type RequestHandler struct {
 	priceRepository ProductPriceRepository
-	nameRepository  ProductNameRepository
+
+	// priceTimeout is the deadline for storing a price, which is not
+	// enforced when zero
+	priceTimeout time.Duration
+
+	// requireIfMatch rejects PUTs without an If-Match header
+	requireIfMatch bool
+
+	// cacheMaxAge is how long clients may cache a product without
+	// revalidating it
+	cacheMaxAge time.Duration
+
+	// batchConcurrency is the most products of a batch assembled at once
+	batchConcurrency int
+
+	// productIDs are the product IDs accepted in paths and bodies
+	productIDs productIDRules
+
+	now func() time.Time
+
+	sources []registeredSource
 }
 
 // NewRequestHandler creates a new RequestHandler
-func NewRequestHandler() (RequestHandler, error) {
-	projectID := os.Getenv("PROJECT_ID")
-	datastoreID := os.Getenv("DATASTORE_ID")
+func NewRequestHandler(config Config) (RequestHandler, error) {
+	if err := config.Validate(); err != nil {
+		return RequestHandler{}, err
+	}
 
-	log.Printf("Created request handler { PROJECT_ID: %s DATASTORE_ID: %s }", projectID, datastoreID)
+	log.Printf("Created request handler { PRICE_BACKEND: %s PROJECT_ID: %s DATASTORE_ID: %s NAME_BACKEND: %s REDSKY_BASE_URL: %s }", config.PriceBackend, config.ProjectID, config.DatastoreID, config.NameBackend, config.RedSkyBaseURL)
 
-	ctx := context.Background()
+	priceRepository, err := NewPriceRepository(config)
+	if err != nil {
+		return RequestHandler{}, err
+	}
 
-	gcpDatastoreClientCreator := NewGCPDatastoreClientCreator(projectID)
-	priceRepository, err := NewGCPProductPriceRepository(ctx, gcpDatastoreClientCreator, datastoreID)
+	nameRepository, err := newNameRepository(config)
 	if err != nil {
 		return RequestHandler{}, err
 	}
 
-	nameRepository := NewTargetProductNameRepository()
+	rh := newRequestHandler(
+		priceRepository,
+		nameRepository,
+		SourceOptions{Timeout: config.PriceTimeout},
+		SourceOptions{Timeout: config.NameTimeout},
+	)
+	rh.requireIfMatch = config.RequireIfMatch
+	rh.cacheMaxAge = config.CacheMaxAge
+	rh.batchConcurrency = config.BatchConcurrency
+	rh.productIDs, err = newProductIDRules(config.ProductIDMin, config.ProductIDMax, config.ProductIDPattern)
+	if err != nil {
+		return RequestHandler{}, err
+	}
+
+	if config.PromotionsFile != "" {
+		promotions, err := LoadPromotionsFile(config.PromotionsFile)
+		if err != nil {
+			return RequestHandler{}, err
+		}
 
-	return RequestHandler{
-		priceRepository: priceRepository,
-		nameRepository:  nameRepository,
-	}, nil
+		rh.RegisterSource(SourcePromotion, NewPromotionSource(promotions), SourceOptions{
+			Timeout:   config.PriceTimeout,
+			Auxiliary: true,
+		})
+	}
+
+	return rh, nil
 }
 
-// HandleRequest is the main entrypoint for http requests
-func (rh RequestHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Request { PATH: %s METHOD: %s }", r.URL.Path, r.Method)
+// newRequestHandler creates a RequestHandler with the built in price and
+// name sources registered
+func newRequestHandler(priceRepository ProductPriceRepository, nameRepository ProductNameRepository, priceOptions SourceOptions, nameOptions SourceOptions) RequestHandler {
+	defaults := DefaultConfig()
+	productIDs := mustProductIDRules(defaults.ProductIDMin, defaults.ProductIDMax, defaults.ProductIDPattern)
+
+	rh := RequestHandler{
+		priceRepository:  priceRepository,
+		priceTimeout:     priceOptions.Timeout,
+		batchConcurrency: defaults.BatchConcurrency,
+		productIDs:       productIDs,
+		now:              time.Now,
+	}
 
-	switch r.Method {
-	case "GET":
-		rh.HandleGet(w, r)
+	rh.RegisterSource(SourcePrice, NewPriceSource(priceRepository), priceOptions)
+	rh.RegisterSource(SourceName, NewNameSource(nameRepository), nameOptions)
 
-	case "PUT":
-		rh.HandlePut(w, r)
+	return rh
+}
+
+// NewPriceRepository creates the price repository for the configured backend,
+// converting prices when an exchange rates file is configured
+func NewPriceRepository(config Config) (ProductPriceRepository, error) {
+	var repository ProductPriceRepository
+	switch config.PriceBackend {
+	case PriceBackendDatastore:
+		ctx := context.Background()
+		gcpDatastoreClientCreator := NewGCPDatastoreClientCreator(config.ProjectID)
+		gcpRepository, err := NewGCPProductPriceRepository(ctx, gcpDatastoreClientCreator, config.DatastoreID)
+		if err != nil {
+			return nil, err
+		}
+		repository = gcpRepository
+
+	case PriceBackendMemory:
+		repository = NewInMemoryProductPriceRepository()
 
 	default:
-		msg := "Unsupported method"
-		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return nil, fmt.Errorf("unknown price backend %q", config.PriceBackend)
+	}
+
+	if config.ExchangeRatesFile == "" {
+		return repository, nil
 	}
+
+	rates, err := LoadStaticExchangeRates(config.ExchangeRatesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConvertingProductPriceRepository(repository, rates), nil
+}
+
+func newNameRepository(config Config) (ProductNameRepository, error) {
+	var repository ProductNameRepository
+	switch config.NameBackend {
+	case NameBackendRedSky:
+		repository = NewTargetProductNameRepository(config.RedSkyBaseURL, config.RedSkyTimeout)
+
+	case NameBackendMemory:
+		repository = NewInMemoryProductNameRepository()
+
+	default:
+		return nil, fmt.Errorf("unknown name backend %q", config.NameBackend)
+	}
+
+	if config.NameCacheSize == 0 {
+		return repository, nil
+	}
+
+	return NewCachingProductNameRepository(repository, NameCacheOptions{
+		Size:                 config.NameCacheSize,
+		TTL:                  config.NameCacheTTL,
+		NegativeTTL:          config.NameCacheNegativeTTL,
+		StaleWhileRevalidate: config.NameCacheStaleWhileRevalidate,
+		FetchTimeout:         config.NameTimeout,
+	}), nil
+}
+
+// HandleRequest is the main entrypoint for http requests
+func (rh RequestHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request { PATH: %s METHOD: %s }", r.URL.Path, r.Method)
+
+	rh.route(w, r)
+}
+
+// ServeHTTP lets a RequestHandler be used directly as an http.Handler
+func (rh RequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rh.HandleRequest(w, r)
 }
 
 // Product represents the core product data returned by this service
@@ -67,31 +185,116 @@ type Product struct {
 	ProductID    int           `json:"product_id"`
 	Name         string        `json:"name,omitempty"`
 	CurrentPrice *ProductPrice `json:"current_price,omitempty"`
+
+	// RegularPrice and Promotion are set when a promotion lowered, or
+	// flagged an offer on, the current price
+	RegularPrice *ProductPrice `json:"regular_price,omitempty"`
+	Promotion    *Promotion    `json:"promotion,omitempty"`
+
+	Meta *ProductMeta `json:"_meta,omitempty"`
+
+	// Fields holds what registered sources contributed beyond the fields
+	// above. They are encoded alongside them.
+	Fields map[string]interface{} `json:"-"`
+
+	// promotions are those contributed by the promotion source, before the
+	// best is applied
+	promotions []Promotion
 }
 
-// ProductPrice represents the product price information in the datastore
-type ProductPrice struct {
-	ProductID int `datastore:"product_id" json:"-"`
+// MarshalJSON encodes the product, including any additional source fields
+func (p Product) MarshalJSON() ([]byte, error) {
+	// product has the same fields as Product but not its methods, so this
+	// does not recurse
+	type product Product
+
+	data, err := json.Marshal(product(p))
+	if err != nil || len(p.Fields) == 0 {
+		return data, err
+	}
+
+	fields, err := json.Marshal(p.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// Splice the two objects together, dropping the closing brace of the
+	// first and the opening brace of the second
+	data = append(data[:len(data)-1], ',')
+	return append(data, fields[1:]...), nil
+}
+
+// setField sets one field contributed by a source
+func (p *Product) setField(key string, value interface{}) {
+	switch key {
+	case FieldName:
+		if name, ok := value.(string); ok {
+			p.Name = name
+			return
+		}
+
+	case FieldCurrentPrice:
+		if price, ok := value.(*ProductPrice); ok {
+			p.CurrentPrice = price
+			return
+		}
+
+	case FieldPromotions:
+		if promotions, ok := value.([]Promotion); ok {
+			p.promotions = promotions
+			return
+		}
+
+	case "product_id", "regular_price", "promotion", "_meta":
+		log.Printf("Ignoring reserved product field %s", key)
+		return
+
+	default:
+		if p.Fields == nil {
+			p.Fields = make(map[string]interface{})
+		}
 
-	// I'd switch to big.Rat for currency if performing price operations
-	Price float64 `datastore:"price" json:"value"`
+		p.Fields[key] = value
+		return
+	}
 
-	CurrencyCode string `datastore:"currency_code" json:"currency_code"`
+	log.Printf("Ignoring product field %s of unexpected type %T", key, value)
 }
 
-func parseProductID(path string) (int, error) {
-	runes := []rune(path)             // Convert to rune
-	withoutSlash := string(runes[1:]) // Eliminate the slash at the beginning
-	return strconv.Atoi(withoutSlash)
+// ProductPrice represents the product price information in the datastore
+type ProductPrice struct {
+	ProductID    int    `json:"-"`
+	Price        Money  `json:"value"`
+	CurrencyCode string `json:"currency_code"`
+
+	// EffectiveFrom and EffectiveTo limit when a scheduled price applies.
+	// Either may be nil, and the price is unscheduled when both are.
+	EffectiveFrom *time.Time `json:"effective_from,omitempty"`
+	EffectiveTo   *time.Time `json:"effective_to,omitempty"`
+
+	// Conversion is set when the price was converted from another currency
+	// rather than stored
+	Conversion *PriceConversion `json:"conversion,omitempty"`
+
+	// Version is the version of the product's prices this price was read
+	// at. Every Put increments it. A Put with a Version set only succeeds
+	// while the product's prices are still at that version, or have any
+	// version when it is AnyVersion.
+	Version int64 `json:"-"`
+
+	// UpdatedAt is when the product's prices were last updated, when known
+	UpdatedAt time.Time `json:"-"`
 }
 
 // HandlePut handles product PUT requests
 func (rh RequestHandler) HandlePut(w http.ResponseWriter, r *http.Request) {
-	productID, err := parseProductID(r.URL.Path)
-	if err != nil {
-		msg := "Invalid product ID"
-		http.Error(w, msg, http.StatusBadRequest)
-		log.Printf("Could not parse product ID '%s'", r.URL.Path)
+	productID, ok := rh.productID(w, r)
+	if !ok {
+		return
+	}
+
+	version, ok := rh.expectedVersion(w, r, productID)
+	if !ok {
 		return
 	}
 
@@ -122,65 +325,11 @@ func (rh RequestHandler) HandlePut(w http.ResponseWriter, r *http.Request) {
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 
-	var price ProductPrice
-	err = dec.Decode(&price)
+	var update priceUpdate
+	err := dec.Decode(&update)
 	if err != nil {
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-
-		switch {
-		// Catch any syntax errors in the JSON and send an error message
-		// which interpolates the location of the problem to make it
-		// easier for the client to fix.
-		case errors.As(err, &syntaxError):
-			msg := fmt.Sprintf("Request body contains badly-formed JSON (at position %d)", syntaxError.Offset)
-			http.Error(w, msg, http.StatusBadRequest)
-
-		// In some circumstances Decode() may also return an
-		// io.ErrUnexpectedEOF error for syntax errors in the JSON. There
-		// is an open issue regarding this at
-		// https://github.com/golang/go/issues/25956.
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			msg := fmt.Sprintf("Request body contains badly-formed JSON")
-			http.Error(w, msg, http.StatusBadRequest)
-
-		// Catch any type errors, like trying to assign a string in the
-		// JSON request body to a int field in our struct. We can
-		// interpolate the relevant field name and position into the error
-		// message to make it easier for the client to fix.
-		case errors.As(err, &unmarshalTypeError):
-			msg := fmt.Sprintf("Request body contains an invalid value for the %q field (at position %d)", unmarshalTypeError.Field, unmarshalTypeError.Offset)
-			http.Error(w, msg, http.StatusBadRequest)
-
-		// Catch the error caused by extra unexpected fields in the request
-		// body. We extract the field name from the error message and
-		// interpolate it in our custom error message. There is an open
-		// issue at https://github.com/golang/go/issues/29035 regarding
-		// turning this into a sentinel error.
-		case strings.HasPrefix(err.Error(), "json: unknown field "):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			msg := fmt.Sprintf("Request body contains unknown field %s", fieldName)
-			http.Error(w, msg, http.StatusBadRequest)
-
-		// An io.EOF error is returned by Decode() if the request body is
-		// empty.
-		case errors.Is(err, io.EOF):
-			msg := "Request body must not be empty"
-			http.Error(w, msg, http.StatusBadRequest)
-
-		// Catch the error caused by the request body being too large. Again
-		// there is an open issue regarding turning this into a sentinel
-		// error at https://github.com/golang/go/issues/30715.
-		case err.Error() == "http: request body too large":
-			msg := "Request body must not be larger than 1MB"
-			http.Error(w, msg, http.StatusRequestEntityTooLarge)
-
-		// Otherwise default to logging the error and sending a 500 Internal
-		// Server Error response.
-		default:
-			log.Println(err.Error())
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		}
+		status, msg := decodeErrorResponse(err)
+		http.Error(w, msg, status)
 		return
 	}
 
@@ -191,9 +340,31 @@ func (rh RequestHandler) HandlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	price.ProductID = productID
+	price, fieldErrs := update.toProductPrice(productID)
+	if len(fieldErrs) > 0 {
+		http.Error(w, joinFieldErrors(fieldErrs), http.StatusBadRequest)
+		return
+	}
+
+	price.Version = version
+
+	ctx, cancel := sourceContext(r.Context(), rh.priceTimeout)
+	defer cancel()
+
+	ctx = WithActor(ctx, r.Header.Get(actorHeader))
 
-	err = rh.priceRepository.Put(price)
+	err = rh.priceRepository.Put(ctx, price)
+	var conflict *PriceConflictError
+	if errors.As(err, &conflict) {
+		msg := fmt.Sprintf("Price conflicts with the %s price %s", conflict.Existing.CurrencyCode, DescribeWindow(conflict.Existing))
+		http.Error(w, msg, http.StatusConflict)
+		return
+	}
+	var mismatch *VersionMismatchError
+	if errors.As(err, &mismatch) {
+		rh.writeVersionMismatch(w, r, productID, mismatch)
+		return
+	}
 	if err != nil {
 		msg := "Error updating product"
 		http.Error(w, msg, http.StatusInternalServerError)
@@ -204,46 +375,335 @@ func (rh RequestHandler) HandlePut(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "Product updated")
 }
 
-// HandleGet handles product GET requests
-func (rh RequestHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
-	productID, err := parseProductID(r.URL.Path)
+// expectedVersion reads the If-Match header, which makes a change
+// conditional on the product being unchanged since the client read it. A
+// matching entity tag is turned into the version of the prices it was
+// computed from, so that the change is only made while they are still at
+// it. Zero is returned when there is no header.
+func (rh RequestHandler) expectedVersion(w http.ResponseWriter, r *http.Request, productID int) (int64, bool) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		if rh.requireIfMatch {
+			msg := "If-Match header is required"
+			http.Error(w, msg, http.StatusPreconditionRequired)
+			return 0, false
+		}
+
+		return 0, true
+	}
+
+	tag, err := parseIfMatch(ifMatch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+
+	if tag == "*" {
+		return AnyVersion, true
+	}
+
+	// A product which is not found has no ETag to match, but one which
+	// could not be read might
+	etag, version, failures := rh.currentETag(r.Context(), productID)
+	for _, err := range failures {
+		if !isNotFound(err) {
+			writeSourceErrors(w, productID, failures...)
+			return 0, false
+		}
+	}
+
+	if etag == "" || tag != etag {
+		writePreconditionFailed(w, etag)
+		return 0, false
+	}
+
+	return version, true
+}
+
+// writeVersionMismatch answers a change whose prices moved on from the
+// version expected, with the product's current ETag when it has prices
+func (rh RequestHandler) writeVersionMismatch(w http.ResponseWriter, r *http.Request, productID int, mismatch *VersionMismatchError) {
+	var etag string
+	if mismatch.Current > 0 {
+		etag, _, _ = rh.currentETag(r.Context(), productID)
+	}
+
+	writePreconditionFailed(w, etag)
+}
+
+// writePreconditionFailed answers a change whose If-Match does not match,
+// with the product's current ETag, if any
+func writePreconditionFailed(w http.ResponseWriter, etag string) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	msg := "Product price has changed since it was read"
+	http.Error(w, msg, http.StatusPreconditionFailed)
+}
+
+// decodeErrorResponse decides the status and message for a request body
+// which could not be decoded. The messages are suitable for clients.
+func decodeErrorResponse(err error) (int, string) {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var moneyError *MoneyError
+	var timeParseError *time.ParseError
+
+	switch {
+	// Catch any syntax errors in the JSON and send an error message
+	// which interpolates the location of the problem to make it
+	// easier for the client to fix.
+	case errors.As(err, &syntaxError):
+		return http.StatusBadRequest, fmt.Sprintf("Request body contains badly-formed JSON (at position %d)", syntaxError.Offset)
+
+	// In some circumstances Decode() may also return an
+	// io.ErrUnexpectedEOF error for syntax errors in the JSON. There
+	// is an open issue regarding this at
+	// https://github.com/golang/go/issues/25956.
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return http.StatusBadRequest, "Request body contains badly-formed JSON"
+
+	// Catch any type errors, like trying to assign a string in the
+	// JSON request body to a int field in our struct. We can
+	// interpolate the relevant field name and position into the error
+	// message to make it easier for the client to fix.
+	case errors.As(err, &unmarshalTypeError):
+		return http.StatusBadRequest, fmt.Sprintf("Request body contains an invalid value for the %q field (at position %d)", unmarshalTypeError.Field, unmarshalTypeError.Offset)
+
+	// Catch amounts which are not decimal numbers, or which cannot be
+	// held exactly. Money is only used for the "value" field.
+	case errors.As(err, &moneyError):
+		return http.StatusBadRequest, fmt.Sprintf("Request body contains an invalid value for the %q field: %s", "value", moneyError.Reason)
+
+	// Catch timestamps which are not RFC 3339. The decoder does not
+	// say which field they were in.
+	case errors.As(err, &timeParseError):
+		return http.StatusBadRequest, fmt.Sprintf("Request body contains an invalid timestamp %s, want RFC 3339", timeParseError.Value)
+
+	// Catch the error caused by extra unexpected fields in the request
+	// body. We extract the field name from the error message and
+	// interpolate it in our custom error message. There is an open
+	// issue at https://github.com/golang/go/issues/29035 regarding
+	// turning this into a sentinel error.
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return http.StatusBadRequest, fmt.Sprintf("Request body contains unknown field %s", fieldName)
+
+	// An io.EOF error is returned by Decode() if the request body is
+	// empty.
+	case errors.Is(err, io.EOF):
+		return http.StatusBadRequest, "Request body must not be empty"
+
+	// Catch the error caused by the request body being too large. Again
+	// there is an open issue regarding turning this into a sentinel
+	// error at https://github.com/golang/go/issues/30715.
+	case err.Error() == "http: request body too large":
+		return http.StatusRequestEntityTooLarge, "Request body must not be larger than 1MB"
+
+	// Otherwise default to logging the error and sending a 500 Internal
+	// Server Error response.
+	default:
+		log.Println(err.Error())
+		return http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)
+	}
+}
+
+// actorHeader names who is changing a price, for the price history. It is
+// taken on trust.
+const actorHeader = "X-Actor"
+
+// priceHistoryResponse is the body of a price history response
+type priceHistoryResponse struct {
+	ProductID     int           `json:"product_id"`
+	Changes       []PriceChange `json:"changes"`
+	NextPageToken string        `json:"next_page_token,omitempty"`
+}
+
+// HandleGetPriceHistory handles GET requests for a product's price history
+func (rh RequestHandler) HandleGetPriceHistory(w http.ResponseWriter, r *http.Request) {
+	productID, ok := rh.productID(w, r)
+	if !ok {
+		return
+	}
+
+	query, err := parsePriceHistoryQuery(r.URL.Query())
 	if err != nil {
-		msg := "Invalid product ID"
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := sourceContext(r.Context(), rh.priceTimeout)
+	defer cancel()
+
+	page, err := rh.priceRepository.History(ctx, productID, query)
+	if errors.Is(err, ErrInvalidPageToken) {
+		msg := "Invalid page token"
 		http.Error(w, msg, http.StatusBadRequest)
-		log.Printf("Could not parse product ID '%s'", r.URL.Path)
 		return
 	}
+	if err != nil {
+		msg := "Could not fetch price history"
+		http.Error(w, msg, http.StatusInternalServerError)
+		log.Printf("Failed fetching product %d price history: %s", productID, err)
+		return
+	}
+
+	response := priceHistoryResponse{
+		ProductID:     productID,
+		Changes:       page.Changes,
+		NextPageToken: page.NextPageToken,
+	}
+
+	if response.Changes == nil {
+		response.Changes = []PriceChange{}
+	}
+
+	json, err := json.Marshal(response)
+	if err != nil {
+		msg := "Could not process request"
+		http.Error(w, msg, http.StatusInternalServerError)
+		log.Printf("Error marshalling product %d price history", productID)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(json)
+}
+
+// parsePriceHistoryQuery reads the from, to, page_size and page_token
+// parameters of a price history request. Errors are suitable for clients.
+func parsePriceHistoryQuery(values url.Values) (PriceHistoryQuery, error) {
+	var query PriceHistoryQuery
+
+	times := []struct {
+		name  string
+		value *time.Time
+	}{
+		{"from", &query.From},
+		{"to", &query.To},
+	}
+	for _, t := range times {
+		raw := values.Get(t.name)
+		if raw == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return query, fmt.Errorf("Invalid %s timestamp %q, want RFC 3339", t.name, raw)
+		}
+
+		*t.value = parsed
+	}
+
+	if raw := values.Get("page_size"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size <= 0 || size > MaxPriceHistoryPageSize {
+			return query, fmt.Errorf("Invalid page size %q, want 1 to %d", raw, MaxPriceHistoryPageSize)
+		}
+
+		query.PageSize = size
+	}
+
+	query.PageToken = values.Get("page_token")
+	return query, nil
+}
+
+// parseProductQuery reads the currency and at parameters of a product
+// request. Errors are suitable for clients.
+func parseProductQuery(values url.Values) (ProductQuery, error) {
+	var query ProductQuery
 
+	if code := values.Get("currency"); code != "" {
+		currency, ok := LookupCurrency(code)
+		if !ok {
+			return query, fmt.Errorf("%q is not an ISO 4217 currency code", code)
+		}
+
+		query.CurrencyCode = currency.Code
+	}
+
+	if raw := values.Get("at"); raw != "" {
+		at, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return query, fmt.Errorf("Invalid at timestamp %q, want RFC 3339", raw)
+		}
+
+		query.At = at
+	}
+
+	return query, nil
+}
+
+// aggregateProduct assembles a product from every source. A partial
+// product is still useful, so it only fails, returning the errors to blame,
+// when a required source did, or when every source but the auxiliary ones
+// did.
+func aggregateProduct(ctx context.Context, query ProductQuery, sources []registeredSource) (Product, []sourceOutcome, []error) {
 	product := Product{
-		ProductID:    productID,
+		ProductID:    query.ProductID,
 		Name:         "",
 		CurrentPrice: nil,
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		price, err := rh.priceRepository.Get(productID)
-		if err != nil {
-			log.Printf("Failed fetching from price repository: %s", err)
-			return
+	outcomes := fetchSources(ctx, query, sources)
+
+	var errs, requiredErrs []error
+	primary := 0
+	for _, outcome := range outcomes {
+		if !outcome.auxiliary {
+			primary++
 		}
 
-		product.CurrentPrice = price
-	}()
+		if outcome.err == nil {
+			continue
+		}
 
-	go func() {
-		defer wg.Done()
-		name, err := rh.nameRepository.Get(productID)
-		if err != nil {
-			log.Printf("Failed fetching from name repository: %s", err)
-			return
+		if !outcome.auxiliary {
+			errs = append(errs, outcome.err)
 		}
 
-		product.Name = name
-	}()
-	wg.Wait()
+		if outcome.required {
+			requiredErrs = append(requiredErrs, outcome.err)
+		}
+	}
+
+	if len(requiredErrs) > 0 {
+		return product, outcomes, requiredErrs
+	}
+
+	if len(errs) == primary && primary > 0 {
+		return product, outcomes, errs
+	}
+
+	for _, outcome := range outcomes {
+		product.addOutcome(outcome)
+	}
+
+	product.applyPromotion()
+	return product, outcomes, nil
+}
+
+// HandleGet handles product GET requests
+func (rh RequestHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	productID, ok := rh.productID(w, r)
+	if !ok {
+		return
+	}
+
+	query, err := parseProductQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.ProductID = productID
+
+	product, outcomes, failures := aggregateProduct(r.Context(), query, rh.sources)
+	if failures != nil {
+		writeSourceErrors(w, productID, failures...)
+		return
+	}
 
 	json, err := json.Marshal(product)
 	if err != nil {
@@ -253,6 +713,148 @@ func (rh RequestHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	etag, err := productETag(product)
+	if err != nil {
+		msg := "Could not process request"
+		http.Error(w, msg, http.StatusInternalServerError)
+		log.Printf("Error computing product %d ETag", productID)
+		return
+	}
+
+	// Clients polling the product can revalidate it with If-None-Match,
+	// and send the ETag back as If-Match on PUT
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl(product, outcomes, rh.cacheMaxAge))
+
+	modified := lastModified(product, outcomes, rh.now())
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	w.Header().Add("Content-Type", "application/json")
 	w.Write(json)
 }
+
+// HandleGetPrice handles GET requests for a product's price, with any
+// promotion on it, leaving out its name
+func (rh RequestHandler) HandleGetPrice(w http.ResponseWriter, r *http.Request) {
+	rh.handleSubresource(w, r, SourcePrice, SourcePromotion)
+}
+
+// HandleGetName handles GET requests for a product's name, leaving out its
+// price
+func (rh RequestHandler) HandleGetName(w http.ResponseWriter, r *http.Request) {
+	rh.handleSubresource(w, r, SourceName)
+}
+
+// handleSubresource answers with the part of a product from the named
+// sources. The first source is required, so the product is not found
+// without it.
+func (rh RequestHandler) handleSubresource(w http.ResponseWriter, r *http.Request, required string, optional ...string) {
+	productID, ok := rh.productID(w, r)
+	if !ok {
+		return
+	}
+
+	query, err := parseProductQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.ProductID = productID
+
+	var sources []registeredSource
+	for _, source := range rh.sources {
+		switch {
+		case source.name == required:
+			source.options.Required = true
+			sources = append(sources, source)
+
+		case containsString(optional, source.name):
+			sources = append(sources, source)
+		}
+	}
+
+	product, _, failures := aggregateProduct(r.Context(), query, sources)
+	if failures != nil {
+		writeSourceErrors(w, productID, failures...)
+		return
+	}
+
+	json, err := json.Marshal(product)
+	if err != nil {
+		msg := "Could not process request"
+		http.Error(w, msg, http.StatusInternalServerError)
+		log.Printf("Error marshalling product %d %s", productID, required)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(json)
+}
+
+// containsString reports whether values holds value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeSourceErrors answers a GET for which the sources could not provide
+// enough data. The product is only reported missing when every failed source
+// says so; otherwise the failure is blamed on the upstreams.
+func writeSourceErrors(w http.ResponseWriter, productID int, errs ...error) {
+	status, msg, retryAfter := sourceErrorResponse(productID, errs...)
+	if retryAfter > 0 {
+		seconds := int((retryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+
+	http.Error(w, msg, status)
+}
+
+// sourceErrorResponse decides the status and message for a product the
+// sources could not provide enough data for, and how long the client should
+// wait before retrying, if known
+func sourceErrorResponse(productID int, errs ...error) (int, string, time.Duration) {
+	notFound := true
+	unavailable := false
+	var retryAfter time.Duration
+
+	for _, err := range errs {
+		if !isNotFound(err) {
+			notFound = false
+		}
+
+		if errors.Is(err, ErrUpstreamUnavailable) || errors.Is(err, ErrUpstreamRateLimited) {
+			unavailable = true
+		}
+
+		var rateLimited *RateLimitedError
+		if errors.As(err, &rateLimited) && rateLimited.RetryAfter > retryAfter {
+			retryAfter = rateLimited.RetryAfter
+		}
+	}
+
+	switch {
+	case notFound:
+		return http.StatusNotFound, "Product not found", 0
+
+	case unavailable:
+		log.Printf("Product %d sources unavailable", productID)
+		return http.StatusServiceUnavailable, "Product data is temporarily unavailable", retryAfter
+
+	default:
+		log.Printf("Product %d sources failed", productID)
+		return http.StatusBadGateway, "Could not fetch product data", 0
+	}
+}
@@ -1,12 +1,16 @@
 package productaggregate
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 var parseTests = []struct {
@@ -16,12 +20,20 @@ var parseTests = []struct {
 }{
 	{"/123", 123, false},
 	{"/123.00", 0, true},
+	{"/123/", 123, false},
+	{"/products/123", 123, false},
+	{"/products/123/", 123, false},
+	{"/v1/products/123/price/history", 123, false},
+	{"/+123", 0, true},
+	{"/-123", 0, true},
+	{"/0", 0, true},
+	{"/", 0, true},
 }
 
 func TestParseProductID(t *testing.T) {
 	for _, tt := range parseTests {
 		t.Run(tt.in, func(t *testing.T) {
-			result, err := parseProductID(tt.in)
+			result, err := parseProductID(tt.in, newRequestHandler(nil, nil, SourceOptions{}, SourceOptions{}).productIDs)
 			if result != tt.outInt {
 				t.Errorf("got %d, want %d", result, tt.outInt)
 			}
@@ -39,18 +51,9 @@ func TestParseProductID(t *testing.T) {
 }
 
 type httpWant struct {
-	code int
-	body string
-}
-
-type priceGetResult struct {
-	price *ProductPrice
-	err   error
-}
-
-type nameResult struct {
-	name string
-	err  error
+	code       int
+	body       string
+	retryAfter string
 }
 
 type handlerIn struct {
@@ -60,8 +63,26 @@ type handlerIn struct {
 	nr      nameResult
 }
 
+// dummyRequest makes a request whose body can be read again through
+// replayRequest, so that tables of them survive repeated test runs
 func dummyRequest(method string, data string) *http.Request {
-	return httptest.NewRequest(method, "http://example.com/123", strings.NewReader(data))
+	r := httptest.NewRequest(method, "http://example.com/123", strings.NewReader(data))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(data)), nil
+	}
+
+	return r
+}
+
+// replayRequest copies r with a fresh body, when it has one to replay
+func replayRequest(r *http.Request) *http.Request {
+	if r.GetBody == nil {
+		return r
+	}
+
+	replay := r.Clone(r.Context())
+	replay.Body, _ = r.GetBody()
+	return replay
 }
 
 var handlerTests = []struct {
@@ -89,6 +110,16 @@ var handlerTests = []struct {
 			body: "Invalid product ID\n",
 		},
 	},
+	{
+		name: "GET Invalid currency",
+		in: handlerIn{
+			request: httptest.NewRequest("GET", "http://example.com/123?currency=XYZ", nil),
+		},
+		want: httpWant{
+			code: http.StatusBadRequest,
+			body: "\"XYZ\" is not an ISO 4217 currency code\n",
+		},
+	},
 	{
 		name: "PUT No product number",
 		in: handlerIn{
@@ -126,7 +157,7 @@ var handlerTests = []struct {
 			pgr: priceGetResult{
 				price: &ProductPrice{
 					ProductID:    123,
-					Price:        100,
+					Price:        NewMoney(100, 0),
 					CurrencyCode: "USD",
 				},
 			},
@@ -136,7 +167,7 @@ var handlerTests = []struct {
 		},
 		want: httpWant{
 			code: http.StatusOK,
-			body: `{"product_id":123,"current_price":{"value":100,"currency_code":"USD"}}`,
+			body: `{"product_id":123,"current_price":{"value":100,"currency_code":"USD"},"_meta":{"errors":[{"source":"name","kind":"upstream_error"}]}}`,
 		},
 	},
 	{
@@ -153,7 +184,124 @@ var handlerTests = []struct {
 		},
 		want: httpWant{
 			code: http.StatusOK,
-			body: `{"product_id":123,"name":"Picard"}`,
+			body: `{"product_id":123,"name":"Picard","_meta":{"errors":[{"source":"price","kind":"upstream_error"}]}}`,
+		},
+	},
+	{
+		name: "GET Expect 404 when neither source knows the product",
+		in: handlerIn{
+			request: httptest.NewRequest("GET", "http://example.com/123", nil),
+			pgr: priceGetResult{
+				err: ErrPriceNotFound,
+			},
+			nr: nameResult{
+				err: ErrProductNotFound,
+			},
+		},
+		want: httpWant{
+			code: http.StatusNotFound,
+			body: "Product not found\n",
+		},
+	},
+	{
+		name: "GET Expect 502 when both sources fail",
+		in: handlerIn{
+			request: httptest.NewRequest("GET", "http://example.com/123", nil),
+			pgr: priceGetResult{
+				err: errors.New("Could not fetch price"),
+			},
+			nr: nameResult{
+				err: errors.New("Could not fetch name"),
+			},
+		},
+		want: httpWant{
+			code: http.StatusBadGateway,
+			body: "Could not fetch product data\n",
+		},
+	},
+	{
+		name: "GET Expect 502 when one source fails and the other does not know the product",
+		in: handlerIn{
+			request: httptest.NewRequest("GET", "http://example.com/123", nil),
+			pgr: priceGetResult{
+				err: errors.New("Could not fetch price"),
+			},
+			nr: nameResult{
+				err: ErrProductNotFound,
+			},
+		},
+		want: httpWant{
+			code: http.StatusBadGateway,
+			body: "Could not fetch product data\n",
+		},
+	},
+	{
+		name: "GET Expect 503 when the name upstream is unavailable",
+		in: handlerIn{
+			request: httptest.NewRequest("GET", "http://example.com/123", nil),
+			pgr: priceGetResult{
+				err: ErrPriceNotFound,
+			},
+			nr: nameResult{
+				err: &UpstreamStatusError{StatusCode: http.StatusInternalServerError},
+			},
+		},
+		want: httpWant{
+			code: http.StatusServiceUnavailable,
+			body: "Product data is temporarily unavailable\n",
+		},
+	},
+	{
+		name: "GET Expect 503 with Retry-After when rate limited",
+		in: handlerIn{
+			request: httptest.NewRequest("GET", "http://example.com/123", nil),
+			pgr: priceGetResult{
+				err: errors.New("Could not fetch price"),
+			},
+			nr: nameResult{
+				err: &RateLimitedError{RetryAfter: 1500 * time.Millisecond},
+			},
+		},
+		want: httpWant{
+			code:       http.StatusServiceUnavailable,
+			body:       "Product data is temporarily unavailable\n",
+			retryAfter: "2",
+		},
+	},
+	{
+		name: "GET Expect only price if the name is not found",
+		in: handlerIn{
+			request: httptest.NewRequest("GET", "http://example.com/123", nil),
+			pgr: priceGetResult{
+				price: &ProductPrice{
+					ProductID:    123,
+					Price:        NewMoney(100, 0),
+					CurrencyCode: "USD",
+				},
+			},
+			nr: nameResult{
+				err: ErrProductNotFound,
+			},
+		},
+		want: httpWant{
+			code: http.StatusOK,
+			body: `{"product_id":123,"current_price":{"value":100,"currency_code":"USD"},"_meta":{"errors":[{"source":"name","kind":"not_found"}]}}`,
+		},
+	},
+	{
+		name: "GET Expect timeout in metadata when the price times out",
+		in: handlerIn{
+			request: httptest.NewRequest("GET", "http://example.com/123", nil),
+			pgr: priceGetResult{
+				err: fmt.Errorf("datastore: %w", context.DeadlineExceeded),
+			},
+			nr: nameResult{
+				name: "Picard",
+			},
+		},
+		want: httpWant{
+			code: http.StatusOK,
+			body: `{"product_id":123,"name":"Picard","_meta":{"errors":[{"source":"price","kind":"timeout"}]}}`,
 		},
 	},
 	{
@@ -176,6 +324,57 @@ var handlerTests = []struct {
 			body: "Product updated",
 		},
 	},
+	{
+		name: "PUT With a value which is not a number",
+		in: handlerIn{
+			request: dummyRequest("PUT", `{"value":"abc","currency_code":"USD"}`),
+		},
+		want: httpWant{
+			code: http.StatusBadRequest,
+			body: "Request body contains an invalid value for the \"value\" field: not a decimal number\n",
+		},
+	},
+	{
+		name: "PUT With an unknown currency",
+		in: handlerIn{
+			request: dummyRequest("PUT", `{"value":100,"currency_code":"ABC"}`),
+		},
+		want: httpWant{
+			code: http.StatusBadRequest,
+			body: "Request body contains an invalid value for the \"currency_code\" field: \"ABC\" is not an ISO 4217 currency code\n",
+		},
+	},
+	{
+		name: "PUT With an empty currency",
+		in: handlerIn{
+			request: dummyRequest("PUT", `{"value":100,"currency_code":""}`),
+		},
+		want: httpWant{
+			code: http.StatusBadRequest,
+			body: "Request body contains an invalid value for the \"currency_code\" field: \"\" is not an ISO 4217 currency code\n",
+		},
+	},
+	{
+		name: "PUT With too many decimal places",
+		in: handlerIn{
+			request: dummyRequest("PUT", `{"value":13.499,"currency_code":"USD"}`),
+		},
+		want: httpWant{
+			code: http.StatusBadRequest,
+			body: "Request body contains an invalid value for the \"value\" field: USD allows at most 2 decimal places\n",
+		},
+	},
+	{
+		name: "PUT With every field wrong",
+		in: handlerIn{
+			request: dummyRequest("PUT", `{"value":-1}`),
+		},
+		want: httpWant{
+			code: http.StatusBadRequest,
+			body: "Request body contains an invalid value for the \"currency_code\" field: missing\n" +
+				"Request body contains an invalid value for the \"value\" field: must not be negative\n",
+		},
+	},
 	{
 		name: "PUT With unknown field",
 		in: handlerIn{
@@ -209,42 +408,23 @@ var handlerTests = []struct {
 	},
 }
 
-type StubPriceRepository struct {
-	pgr priceGetResult
-	ppr error
-}
-
-func (s StubPriceRepository) Get(productID int) (*ProductPrice, error) {
-	return s.pgr.price, s.pgr.err
-}
-
-func (s StubPriceRepository) Put(price ProductPrice) error {
-	return s.ppr
-}
-
-type StubNameRepository struct {
-	nr nameResult
-}
-
-func (s StubNameRepository) Get(productID int) (string, error) {
-	return s.nr.name, s.nr.err
-}
-
 func TestRequestHandler(t *testing.T) {
 	for _, tt := range handlerTests {
 		t.Run(tt.name, func(t *testing.T) {
-			rh := RequestHandler{
-				priceRepository: StubPriceRepository{
+			rh := newRequestHandler(
+				StubPriceRepository{
 					ppr: tt.in.ppr,
 					pgr: tt.in.pgr,
 				},
-				nameRepository: StubNameRepository{
+				StubNameRepository{
 					nr: tt.in.nr,
 				},
-			}
+				SourceOptions{},
+				SourceOptions{},
+			)
 
 			w := httptest.NewRecorder()
-			rh.HandleRequest(w, tt.in.request)
+			rh.HandleRequest(w, replayRequest(tt.in.request))
 
 			resp := w.Result()
 			body, _ := ioutil.ReadAll(resp.Body)
@@ -256,6 +436,276 @@ func TestRequestHandler(t *testing.T) {
 			if resp.StatusCode != tt.want.code {
 				t.Errorf("got %d, want %d", resp.StatusCode, tt.want.code)
 			}
+
+			if retryAfter := resp.Header.Get("Retry-After"); retryAfter != tt.want.retryAfter {
+				t.Errorf("got Retry-After %q, want %q", retryAfter, tt.want.retryAfter)
+			}
+		})
+	}
+}
+
+func TestRequestHandlerServeHTTP(t *testing.T) {
+	rh := newRequestHandler(
+		StubPriceRepository{},
+		StubNameRepository{
+			nr: nameResult{name: "Picard"},
+		},
+		SourceOptions{},
+		SourceOptions{},
+	)
+
+	ts := httptest.NewServer(rh)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/123")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	want := `{"product_id":123,"name":"Picard"}`
+	if string(body) != want {
+		t.Errorf("got %s, want %s", string(body), want)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestRequestHandlerInMemoryRoundTrip(t *testing.T) {
+	rh := newRequestHandler(
+		NewInMemoryProductPriceRepository(),
+		StubNameRepository{
+			nr: nameResult{name: "Picard"},
+		},
+		SourceOptions{},
+		SourceOptions{},
+	)
+
+	w := httptest.NewRecorder()
+	rh.HandleRequest(w, dummyRequest("PUT", `{"value":13.49,"currency_code":"USD"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	w = httptest.NewRecorder()
+	rh.HandleRequest(w, httptest.NewRequest("GET", "http://example.com/123", nil))
+
+	want := `{"product_id":123,"name":"Picard","current_price":{"value":13.49,"currency_code":"USD"}}`
+	if w.Body.String() != want {
+		t.Errorf("got %s, want %s", w.Body.String(), want)
+	}
+}
+
+func TestRequestHandlerUnknownProductWithPromotions(t *testing.T) {
+	config := DefaultConfig()
+	config.PriceBackend = PriceBackendMemory
+	config.NameBackend = NameBackendMemory
+	config.PromotionsFile = "testdata/promotions.json"
+
+	rh, err := NewRequestHandler(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The promotion source finds no promotions rather than failing, which
+	// must not make the product exist
+	w := httptest.NewRecorder()
+	rh.HandleRequest(w, httptest.NewRequest("GET", "http://example.com/products/999", nil))
+	if want := "Product not found\n"; w.Code != http.StatusNotFound || w.Body.String() != want {
+		t.Errorf("got %d %s, want 404 %s", w.Code, w.Body.String(), want)
+	}
+}
+
+var currencySelectorTests = []struct {
+	url  string
+	want httpWant
+}{
+	{
+		url: "http://example.com/123",
+		want: httpWant{
+			code: http.StatusOK,
+			body: `{"product_id":123,"name":"Picard","current_price":{"value":13.99,"currency_code":"USD"}}`,
+		},
+	},
+	{
+		url: "http://example.com/123?currency=eur",
+		want: httpWant{
+			code: http.StatusOK,
+			body: `{"product_id":123,"name":"Picard","current_price":{"value":12.50,"currency_code":"EUR"}}`,
+		},
+	},
+	{
+		url: "http://example.com/123?currency=GBP",
+		want: httpWant{
+			code: http.StatusOK,
+			body: `{"product_id":123,"name":"Picard","_meta":{"errors":[{"source":"price","kind":"not_found"}]}}`,
+		},
+	},
+}
+
+func TestRequestHandlerCurrencySelector(t *testing.T) {
+	rh := newRequestHandler(
+		NewInMemoryProductPriceRepository(),
+		StubNameRepository{
+			nr: nameResult{name: "Picard"},
+		},
+		SourceOptions{},
+		SourceOptions{},
+	)
+
+	// Setting one currency leaves the others alone
+	for _, body := range []string{
+		`{"value":13.49,"currency_code":"USD"}`,
+		`{"value":12.5,"currency_code":"EUR"}`,
+		`{"value":13.99,"currency_code":"USD"}`,
+	} {
+		w := httptest.NewRecorder()
+		rh.HandleRequest(w, dummyRequest("PUT", body))
+		if w.Code != http.StatusOK {
+			t.Fatalf("PUT %s: got %d, want %d", body, w.Code, http.StatusOK)
+		}
+	}
+
+	for _, tt := range currencySelectorTests {
+		t.Run(tt.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rh.HandleRequest(w, httptest.NewRequest("GET", tt.url, nil))
+
+			if w.Code != tt.want.code || w.Body.String() != tt.want.body {
+				t.Errorf("got %d %s, want %d %s", w.Code, w.Body.String(), tt.want.code, tt.want.body)
+			}
 		})
 	}
 }
+
+func TestRequestHandlerCacheAge(t *testing.T) {
+	cache := NewCachingProductNameRepository(helperNameRepository(map[int]string{123: "Picard"}), testNameCacheOptions)
+	clock := &fakeClock{now: time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)}
+	cache.now = clock.Now
+
+	rh := newRequestHandler(StubPriceRepository{}, cache, SourceOptions{}, SourceOptions{})
+
+	var tests = []struct {
+		advance time.Duration
+		want    string
+	}{
+		{0, `{"product_id":123,"name":"Picard"}`},
+		{42 * time.Second, `{"product_id":123,"name":"Picard","_meta":{"cache_age_seconds":{"name":42}}}`},
+	}
+
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+
+		w := httptest.NewRecorder()
+		rh.HandleRequest(w, httptest.NewRequest("GET", "http://example.com/123", nil))
+
+		if w.Body.String() != tt.want {
+			t.Errorf("got %s, want %s", w.Body.String(), tt.want)
+		}
+	}
+}
+
+// slowNameRepository answers after a delay, unless its context ends first
+type slowNameRepository struct {
+	delay time.Duration
+	name  string
+}
+
+func (s slowNameRepository) Get(ctx context.Context, productID int) (string, error) {
+	select {
+	case <-time.After(s.delay):
+		return s.name, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func TestRequestHandlerSourceDeadline(t *testing.T) {
+	rh := newRequestHandler(
+		StubPriceRepository{
+			pgr: priceGetResult{
+				price: &ProductPrice{ProductID: 123, Price: NewMoney(100, 0), CurrencyCode: "USD"},
+			},
+		},
+		slowNameRepository{delay: time.Minute, name: "Picard"},
+		SourceOptions{},
+		SourceOptions{Timeout: 10 * time.Millisecond},
+	)
+
+	w := httptest.NewRecorder()
+	rh.HandleRequest(w, httptest.NewRequest("GET", "http://example.com/123", nil))
+
+	want := `{"product_id":123,"current_price":{"value":100,"currency_code":"USD"},"_meta":{"errors":[{"source":"name","kind":"timeout"}]}}`
+	if w.Body.String() != want {
+		t.Errorf("got %s, want %s", w.Body.String(), want)
+	}
+}
+
+// contextRecordingPriceRepository remembers the error of the context it was
+// called with
+type contextRecordingPriceRepository struct {
+	StubPriceRepository
+	ctxErr chan error
+}
+
+func (c contextRecordingPriceRepository) Get(ctx context.Context, productID int, query PriceQuery) (*ProductPrice, error) {
+	c.ctxErr <- ctx.Err()
+	return nil, ErrPriceNotFound
+}
+
+func (c contextRecordingPriceRepository) Put(ctx context.Context, price ProductPrice) error {
+	c.ctxErr <- ctx.Err()
+	return nil
+}
+
+func (c contextRecordingPriceRepository) Delete(ctx context.Context, productID int, version int64) error {
+	c.ctxErr <- ctx.Err()
+	return nil
+}
+
+func TestRequestHandlerPropagatesCancellation(t *testing.T) {
+	var tests = []*http.Request{
+		httptest.NewRequest("GET", "http://example.com/123", nil),
+		dummyRequest("PUT", `{"value":100,"currency_code":"USD"}`),
+		httptest.NewRequest("DELETE", "http://example.com/123", nil),
+	}
+
+	for _, request := range tests {
+		t.Run(request.Method, func(t *testing.T) {
+			repository := contextRecordingPriceRepository{ctxErr: make(chan error, 1)}
+			rh := newRequestHandler(repository, StubNameRepository{}, SourceOptions{}, SourceOptions{})
+
+			ctx, cancel := context.WithCancel(request.Context())
+			cancel()
+
+			rh.HandleRequest(httptest.NewRecorder(), request.WithContext(ctx))
+
+			if err := <-repository.ctxErr; !errors.Is(err, context.Canceled) {
+				t.Errorf("got context error %+v, want %+v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestRequestHandlerPutNormalizesPrice(t *testing.T) {
+	repository := NewInMemoryProductPriceRepository()
+	rh := newRequestHandler(repository, StubNameRepository{}, SourceOptions{}, SourceOptions{})
+
+	w := httptest.NewRecorder()
+	rh.HandleRequest(w, dummyRequest("PUT", `{"value":5,"currency_code":"usd"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want %d", w.Code, w.Body.String(), http.StatusOK)
+	}
+
+	price, err := repository.Get(context.Background(), 123, PriceQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if price.Price.String() != "5.00" || price.CurrencyCode != "USD" {
+		t.Errorf("got %s %s, want 5.00 USD", price.Price, price.CurrencyCode)
+	}
+}
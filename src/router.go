@@ -0,0 +1,279 @@
+package productaggregate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// routeHandler handles one method of a route
+type routeHandler func(rh RequestHandler, w http.ResponseWriter, r *http.Request)
+
+// route is a resource, and the handlers for the methods it allows. Its
+// pattern is matched segment by segment, with {id} matching any segment but
+// those of route prefixes.
+type route struct {
+	pattern  string
+	handlers map[string]routeHandler
+}
+
+// routes are matched in order, so fixed segments go before {id}. The
+// collection's empty pattern only matches under a route prefix, as a bare
+// root is the product route with no ID.
+var routes = []route{
+	{
+		pattern: "",
+		handlers: map[string]routeHandler{
+			"GET":  RequestHandler.HandleBatchGet,
+			"POST": RequestHandler.HandleBatchGet,
+		},
+	},
+	{
+		pattern: "prices",
+		handlers: map[string]routeHandler{
+			"PUT":  RequestHandler.HandleBulkPut,
+			"POST": RequestHandler.HandleBulkPut,
+		},
+	},
+	{
+		pattern: "{id}",
+		handlers: map[string]routeHandler{
+			"GET":    RequestHandler.HandleGet,
+			"PUT":    RequestHandler.HandlePut,
+			"PATCH":  RequestHandler.HandlePatch,
+			"DELETE": RequestHandler.HandleDelete,
+		},
+	},
+	{
+		pattern: "{id}/price",
+		handlers: map[string]routeHandler{
+			"GET":    RequestHandler.HandleGetPrice,
+			"PUT":    RequestHandler.HandlePut,
+			"PATCH":  RequestHandler.HandlePatch,
+			"DELETE": RequestHandler.HandleDelete,
+		},
+	},
+	{
+		pattern: "{id}/price/undelete",
+		handlers: map[string]routeHandler{
+			"POST": RequestHandler.HandleUndelete,
+		},
+	},
+	{
+		pattern: "{id}/price/history",
+		handlers: map[string]routeHandler{
+			"GET": RequestHandler.HandleGetPriceHistory,
+		},
+	},
+	{
+		pattern: "{id}/name",
+		handlers: map[string]routeHandler{
+			"GET": RequestHandler.HandleGetName,
+		},
+	},
+}
+
+// routePrefixes may come before a route. Paths without a prefix are served
+// too, as Cloud Functions strips the function's name from them.
+var routePrefixes = [][]string{
+	{"v1", "products"},
+	{"products"},
+}
+
+// routeSegments splits a path into the segments routes are matched
+// against, ignoring any trailing slash and route prefix. A path of only a
+// prefix has no segments, while the bare root has a single empty one.
+func routeSegments(path string) []string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, prefix := range routePrefixes {
+		if hasPrefix(segments, prefix) {
+			return segments[len(prefix):]
+		}
+	}
+
+	return segments
+}
+
+// hasPrefix reports whether segments start with prefix
+func hasPrefix(segments []string, prefix []string) bool {
+	if len(segments) < len(prefix) {
+		return false
+	}
+
+	for i := range prefix {
+		if segments[i] != prefix[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matches reports whether the route's pattern matches the segments
+func (rt route) matches(segments []string) bool {
+	var pattern []string
+	if rt.pattern != "" {
+		pattern = strings.Split(rt.pattern, "/")
+	}
+
+	if len(pattern) != len(segments) {
+		return false
+	}
+
+	for i, segment := range pattern {
+		if segment == "{id}" {
+			if prefixSegment(segments[i]) {
+				return false
+			}
+			continue
+		}
+
+		if segment != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// prefixSegment reports whether a segment belongs to a route prefix. {id}
+// does not match these, so that a partial prefix such as /v1 is not found,
+// rather than an invalid product ID.
+func prefixSegment(segment string) bool {
+	for _, prefix := range routePrefixes {
+		for _, part := range prefix {
+			if segment == part {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// allow lists the route's methods, for the Allow header
+func (rt route) allow() string {
+	methods := make([]string, 0, len(rt.handlers))
+	for method := range rt.handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	return strings.Join(methods, ", ")
+}
+
+// route finds the handler for the request, answering 404 for unknown paths
+// and 405 for methods the path does not allow
+func (rh RequestHandler) route(w http.ResponseWriter, r *http.Request) {
+	segments := routeSegments(r.URL.Path)
+	for _, rt := range routes {
+		if !rt.matches(segments) {
+			continue
+		}
+
+		handler, ok := rt.handlers[r.Method]
+		if !ok {
+			w.Header().Set("Allow", rt.allow())
+			msg := "Unsupported method"
+			http.Error(w, msg, http.StatusMethodNotAllowed)
+			return
+		}
+
+		handler(rh, w, r)
+		return
+	}
+
+	msg := "Not found"
+	http.Error(w, msg, http.StatusNotFound)
+}
+
+// productIDRules are the product IDs the service accepts
+type productIDRules struct {
+	min     int
+	max     int
+	pattern *regexp.Regexp
+}
+
+// newProductIDRules creates the rules for the configured range and pattern.
+// There is no maximum when max is zero. Every problem with the range and
+// pattern is reported.
+func newProductIDRules(min int, max int, pattern string) (productIDRules, error) {
+	var problems []string
+	if max < 0 || (max > 0 && max < min) {
+		problems = append(problems, fmt.Sprintf("product ID max must be 0 or at least the min of %d, got %d", min, max))
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid product ID pattern %q: %s", pattern, err))
+	}
+
+	if len(problems) > 0 {
+		return productIDRules{}, errors.New(strings.Join(problems, "; "))
+	}
+
+	return productIDRules{min: min, max: max, pattern: compiled}, nil
+}
+
+// mustProductIDRules is like newProductIDRules but panics if the rules are
+// invalid. It is intended for the defaults.
+func mustProductIDRules(min int, max int, pattern string) productIDRules {
+	rules, err := newProductIDRules(min, max, pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	return rules
+}
+
+// check parses a product ID, returning an error suitable for clients when
+// it does not follow the rules
+func (p productIDRules) check(raw string) (int, error) {
+	if !p.pattern.MatchString(raw) {
+		return 0, fmt.Errorf("Invalid product ID")
+	}
+
+	productID, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid product ID")
+	}
+
+	if productID < p.min || (p.max > 0 && productID > p.max) {
+		if p.max > 0 {
+			return 0, fmt.Errorf("Product ID must be between %d and %d", p.min, p.max)
+		}
+
+		return 0, fmt.Errorf("Product ID must be at least %d", p.min)
+	}
+
+	return productID, nil
+}
+
+// parseProductID reads the product ID from a product's path, or one of its
+// sub-resources' paths
+func parseProductID(path string, rules productIDRules) (int, error) {
+	segments := routeSegments(path)
+	if len(segments) == 0 {
+		return 0, fmt.Errorf("Invalid product ID")
+	}
+
+	return rules.check(segments[0])
+}
+
+// productID reads the request's product ID, answering 400 when it is
+// invalid
+func (rh RequestHandler) productID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	productID, err := parseProductID(r.URL.Path, rh.productIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Printf("Could not parse product ID '%s'", r.URL.Path)
+		return 0, false
+	}
+
+	return productID, true
+}
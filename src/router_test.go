@@ -0,0 +1,264 @@
+package productaggregate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func helperRouterHandler(t *testing.T) RequestHandler {
+	prices := helperPriceRepository(t, nil, ProductPrice{ProductID: 123, Price: MustParseMoney("13.49"), CurrencyCode: "USD"})
+	names := helperNameRepository(map[int]string{123: "Picard", 456: "Riker"})
+
+	return newRequestHandler(prices, names, SourceOptions{}, SourceOptions{})
+}
+
+var routerTests = []struct {
+	name      string
+	method    string
+	path      string
+	body      string
+	wantCode  int
+	wantBody  string
+	wantAllow string
+}{
+	{
+		name:     "Unprefixed product",
+		method:   "GET",
+		path:     "/123",
+		wantCode: http.StatusOK,
+		wantBody: `{"product_id":123,"name":"Picard","current_price":{"value":13.49,"currency_code":"USD"}}`,
+	},
+	{
+		name:     "Documented product path",
+		method:   "GET",
+		path:     "/products/123",
+		wantCode: http.StatusOK,
+		wantBody: `{"product_id":123,"name":"Picard","current_price":{"value":13.49,"currency_code":"USD"}}`,
+	},
+	{
+		name:     "Versioned product path with a trailing slash",
+		method:   "GET",
+		path:     "/v1/products/123/",
+		wantCode: http.StatusOK,
+		wantBody: `{"product_id":123,"name":"Picard","current_price":{"value":13.49,"currency_code":"USD"}}`,
+	},
+	{
+		name:     "Price",
+		method:   "GET",
+		path:     "/v1/products/123/price",
+		wantCode: http.StatusOK,
+		wantBody: `{"product_id":123,"current_price":{"value":13.49,"currency_code":"USD"}}`,
+	},
+	{
+		name:     "Price of a product without one",
+		method:   "GET",
+		path:     "/products/456/price",
+		wantCode: http.StatusNotFound,
+		wantBody: "Product not found\n",
+	},
+	{
+		name:     "Name",
+		method:   "GET",
+		path:     "/products/456/name/",
+		wantCode: http.StatusOK,
+		wantBody: `{"product_id":456,"name":"Riker"}`,
+	},
+	{
+		name:     "PUT price",
+		method:   "PUT",
+		path:     "/v1/products/456/price",
+		body:     `{"value":5,"currency_code":"USD"}`,
+		wantCode: http.StatusOK,
+		wantBody: "Product updated",
+	},
+	{
+		name:     "Batch",
+		method:   "GET",
+		path:     "/v1/products/?ids=456",
+		wantCode: http.StatusOK,
+		wantBody: `{"products":[{"product_id":456,"status":200,"product":{"product_id":456,"name":"Riker","_meta":{"errors":[{"source":"price","kind":"not_found"}]}}}]}`,
+	},
+	{
+		name:     "Batch without a trailing slash",
+		method:   "GET",
+		path:     "/products?ids=456",
+		wantCode: http.StatusOK,
+		wantBody: `{"products":[{"product_id":456,"status":200,"product":{"product_id":456,"name":"Riker","_meta":{"errors":[{"source":"price","kind":"not_found"}]}}}]}`,
+	},
+	{
+		name:     "No batch at the bare root",
+		method:   "GET",
+		path:     "/?ids=456",
+		wantCode: http.StatusBadRequest,
+		wantBody: "Invalid product ID\n",
+	},
+	{
+		name:      "Method not allowed on a product",
+		method:    "POST",
+		path:      "/products/123",
+		wantCode:  http.StatusMethodNotAllowed,
+		wantBody:  "Unsupported method\n",
+		wantAllow: "DELETE, GET, PATCH, PUT",
+	},
+	{
+		name:      "Method not allowed on the price history",
+		method:    "PUT",
+		path:      "/products/123/price/history",
+		wantCode:  http.StatusMethodNotAllowed,
+		wantBody:  "Unsupported method\n",
+		wantAllow: "GET",
+	},
+	{
+		name:      "Method not allowed on bulk prices",
+		method:    "GET",
+		path:      "/products/prices",
+		wantCode:  http.StatusMethodNotAllowed,
+		wantBody:  "Unsupported method\n",
+		wantAllow: "POST, PUT",
+	},
+	{
+		name:     "Unknown sub-resource",
+		method:   "GET",
+		path:     "/products/123/reviews",
+		wantCode: http.StatusNotFound,
+		wantBody: "Not found\n",
+	},
+	{
+		name:     "Partial prefix",
+		method:   "GET",
+		path:     "/v1",
+		wantCode: http.StatusNotFound,
+		wantBody: "Not found\n",
+	},
+	{
+		name:     "Prefix in place of a product ID",
+		method:   "PUT",
+		path:     "/v1/products/products/price",
+		wantCode: http.StatusNotFound,
+		wantBody: "Not found\n",
+	},
+	{
+		name:     "Too deep",
+		method:   "GET",
+		path:     "/products/123/price/history/1",
+		wantCode: http.StatusNotFound,
+		wantBody: "Not found\n",
+	},
+	{
+		name:     "Product ID out of range",
+		method:   "GET",
+		path:     "/products/0",
+		wantCode: http.StatusBadRequest,
+		wantBody: "Product ID must be at least 1\n",
+	},
+	{
+		name:     "Product ID with a sign",
+		method:   "GET",
+		path:     "/products/+123",
+		wantCode: http.StatusBadRequest,
+		wantBody: "Invalid product ID\n",
+	},
+}
+
+func TestRequestHandlerRoutes(t *testing.T) {
+	for _, tt := range routerTests {
+		t.Run(tt.name, func(t *testing.T) {
+			rh := helperRouterHandler(t)
+
+			w := httptest.NewRecorder()
+			rh.HandleRequest(w, httptest.NewRequest(tt.method, "http://example.com"+tt.path, strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+				t.Errorf("got %d %s, want %d %s", w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+			}
+
+			if allow := w.Header().Get("Allow"); allow != tt.wantAllow {
+				t.Errorf("got Allow %q, want %q", allow, tt.wantAllow)
+			}
+		})
+	}
+}
+
+var productIDRulesTests = []struct {
+	name    string
+	min     int
+	max     int
+	pattern string
+	in      string
+	want    int
+	err     string
+}{
+	{"Default", 1, 0, "^[0-9]+$", "13860428", 13860428, ""},
+	{"Below minimum", 1, 0, "^[0-9]+$", "0", 0, "Product ID must be at least 1"},
+	{"Above maximum", 1, 99999999, "^[0-9]+$", "100000000", 0, "Product ID must be between 1 and 99999999"},
+	{"Fixed length", 1, 0, "^[0-9]{8}$", "1234567", 0, "Invalid product ID"},
+	{"Fixed length matched", 1, 0, "^[0-9]{8}$", "12345678", 12345678, ""},
+	{"Too large for an int", 1, 0, "^[0-9]+$", "99999999999999999999", 0, "Invalid product ID"},
+}
+
+func TestProductIDRules(t *testing.T) {
+	for _, tt := range productIDRulesTests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := newProductIDRules(tt.min, tt.max, tt.pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got, err := rules.check(tt.in)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+
+			if got != tt.want || gotErr != tt.err {
+				t.Errorf("got %d %q, want %d %q", got, gotErr, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewProductIDRulesInvalid(t *testing.T) {
+	tests := []struct {
+		min     int
+		max     int
+		pattern string
+		want    string
+	}{
+		{10, 5, "^[0-9]+$", "product ID max must be 0 or at least the min of 10, got 5"},
+		{1, -1, "^[0-9]+$", "product ID max must be 0 or at least the min of 1, got -1"},
+		{1, 0, "[0-9", `invalid product ID pattern "[0-9"`},
+	}
+
+	for _, tt := range tests {
+		_, err := newProductIDRules(tt.min, tt.max, tt.pattern)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("newProductIDRules(%d, %d, %q) = %v, want %s", tt.min, tt.max, tt.pattern, err, tt.want)
+		}
+	}
+}
+
+func TestRequestHandlerProductIDRange(t *testing.T) {
+	config := DefaultConfig()
+	config.PriceBackend = PriceBackendMemory
+	config.NameBackend = NameBackendMemory
+	config.ProductIDMax = 999
+
+	rh, err := NewRequestHandler(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	rh.HandleRequest(w, httptest.NewRequest("GET", "http://example.com/products?ids=1,1000", nil))
+	if want := "Product ID must be between 1 and 999, got 1000\n"; w.Code != http.StatusBadRequest || w.Body.String() != want {
+		t.Errorf("got %d %s, want 400 %s", w.Code, w.Body.String(), want)
+	}
+
+	w = httptest.NewRecorder()
+	rh.HandleRequest(w, httptest.NewRequest("PUT", "http://example.com/products/prices", strings.NewReader(`[{"product_id":1000,"value":5,"currency_code":"USD"}]`)))
+	if want := `"error":"Product ID must be between 1 and 999, got 1000"`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("got %d %s, want %s", w.Code, w.Body.String(), want)
+	}
+}
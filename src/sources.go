@@ -0,0 +1,121 @@
+package productaggregate
+
+import (
+	"context"
+	"time"
+)
+
+// Product fields contributed by the built in sources
+const (
+	FieldName         = "name"
+	FieldCurrentPrice = "current_price"
+
+	// FieldPromotions holds a []Promotion, of which the best is applied to
+	// the current price. It is not encoded itself.
+	FieldPromotions = "promotions"
+)
+
+// PriceSource contributes a product's current price
+type PriceSource struct {
+	repository ProductPriceRepository
+}
+
+// NewPriceSource creates a new PriceSource reading from repository
+func NewPriceSource(repository ProductPriceRepository) PriceSource {
+	return PriceSource{
+		repository: repository,
+	}
+}
+
+// Fetch fetches the product's price in the queried currency, effective at
+// the queried time
+func (s PriceSource) Fetch(ctx context.Context, query ProductQuery) (SourceResult, error) {
+	price, err := s.repository.Get(ctx, query.ProductID, PriceQuery{
+		CurrencyCode: query.CurrencyCode,
+		At:           query.At,
+	})
+	if err != nil {
+		return SourceResult{}, err
+	}
+
+	return SourceResult{
+		Fields: map[string]interface{}{
+			FieldCurrentPrice: price,
+		},
+	}, nil
+}
+
+// agedNameRepository is implemented by name repositories that can say how
+// old the names they serve are
+type agedNameRepository interface {
+	GetWithAge(ctx context.Context, productID int) (string, time.Duration, error)
+}
+
+// NameSource contributes a product's name
+type NameSource struct {
+	repository ProductNameRepository
+}
+
+// NewNameSource creates a new NameSource reading from repository
+func NewNameSource(repository ProductNameRepository) NameSource {
+	return NameSource{
+		repository: repository,
+	}
+}
+
+// Fetch fetches the product's name
+func (s NameSource) Fetch(ctx context.Context, query ProductQuery) (SourceResult, error) {
+	var name string
+	var age time.Duration
+	var err error
+
+	if aged, ok := s.repository.(agedNameRepository); ok {
+		name, age, err = aged.GetWithAge(ctx, query.ProductID)
+	} else {
+		name, err = s.repository.Get(ctx, query.ProductID)
+	}
+
+	if err != nil {
+		return SourceResult{CacheAge: age}, err
+	}
+
+	return SourceResult{
+		Fields: map[string]interface{}{
+			FieldName: name,
+		},
+		CacheAge: age,
+	}, nil
+}
+
+// PromotionSource contributes the promotions active on a product
+type PromotionSource struct {
+	repository PromotionRepository
+	now        func() time.Time
+}
+
+// NewPromotionSource creates a new PromotionSource reading from repository
+func NewPromotionSource(repository PromotionRepository) PromotionSource {
+	return PromotionSource{
+		repository: repository,
+		now:        time.Now,
+	}
+}
+
+// Fetch fetches the promotions active at the queried time
+func (s PromotionSource) Fetch(ctx context.Context, query ProductQuery) (SourceResult, error) {
+	at := query.At
+	if at.IsZero() {
+		at = s.now()
+	}
+
+	promotions, err := s.repository.Active(ctx, query.ProductID, at)
+	if err != nil {
+		return SourceResult{}, err
+	}
+
+	return SourceResult{
+		Fields: map[string]interface{}{
+			FieldPromotions: promotions,
+		},
+	}, nil
+}
@@ -0,0 +1,97 @@
+package productaggregate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FieldError describes a problem with one field of a request body
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("Request body contains an invalid value for the %q field: %s", e.Field, e.Reason)
+}
+
+// joinFieldErrors formats field errors one per line
+func joinFieldErrors(errs []FieldError) string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// priceUpdate is the body of a price PUT. Its fields are pointers so that
+// missing fields can be told apart from zero values.
+type priceUpdate struct {
+	Value         *Money     `json:"value"`
+	CurrencyCode  *string    `json:"currency_code"`
+	EffectiveFrom *time.Time `json:"effective_from"`
+	EffectiveTo   *time.Time `json:"effective_to"`
+}
+
+// toProductPrice validates the update and turns it into a ProductPrice. The
+// currency code is upper cased and the value is padded to the currency's
+// minor unit, so "usd" and 5 are stored as "USD" and 5.00.
+func (u priceUpdate) toProductPrice(productID int) (ProductPrice, []FieldError) {
+	var errs []FieldError
+
+	var currency Currency
+	switch {
+	case u.CurrencyCode == nil:
+		errs = append(errs, FieldError{Field: "currency_code", Reason: "missing"})
+
+	default:
+		var ok bool
+		currency, ok = LookupCurrency(*u.CurrencyCode)
+		if !ok {
+			errs = append(errs, FieldError{Field: "currency_code", Reason: fmt.Sprintf("%q is not an ISO 4217 currency code", *u.CurrencyCode)})
+		}
+	}
+
+	var value Money
+	switch {
+	case u.Value == nil:
+		errs = append(errs, FieldError{Field: "value", Reason: "missing"})
+
+	case u.Value.Sign() < 0:
+		errs = append(errs, FieldError{Field: "value", Reason: "must not be negative"})
+
+	case currency.Code != "" && !u.Value.IsExactAt(currency.MinorUnits):
+		errs = append(errs, FieldError{Field: "value", Reason: fmt.Sprintf("%s allows at most %d decimal places", currency.Code, currency.MinorUnits)})
+
+	case currency.Code != "":
+		value, _ = u.Value.Round(currency.MinorUnits)
+	}
+
+	if u.EffectiveFrom != nil && u.EffectiveTo != nil && !u.EffectiveTo.After(*u.EffectiveFrom) {
+		errs = append(errs, FieldError{Field: "effective_to", Reason: "must be after effective_from"})
+	}
+
+	if len(errs) > 0 {
+		return ProductPrice{}, errs
+	}
+
+	return ProductPrice{
+		ProductID:     productID,
+		Price:         value,
+		CurrencyCode:  currency.Code,
+		EffectiveFrom: utcTime(u.EffectiveFrom),
+		EffectiveTo:   utcTime(u.EffectiveTo),
+	}, nil
+}
+
+// utcTime returns t in UTC, or nil when t is nil
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	utc := t.UTC()
+	return &utc
+}
@@ -0,0 +1,77 @@
+package productaggregate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func stringPointer(s string) *string {
+	return &s
+}
+
+func moneyPointer(s string) *Money {
+	m := MustParseMoney(s)
+	return &m
+}
+
+var priceUpdateTests = []struct {
+	name     string
+	in       priceUpdate
+	want     ProductPrice
+	wantErrs []FieldError
+}{
+	{
+		name: "Valid",
+		in:   priceUpdate{Value: moneyPointer("13.49"), CurrencyCode: stringPointer("USD")},
+		want: ProductPrice{ProductID: 1, Price: MustParseMoney("13.49"), CurrencyCode: "USD"},
+	},
+	{
+		name: "Normalized",
+		in:   priceUpdate{Value: moneyPointer("13.5"), CurrencyCode: stringPointer("eur")},
+		want: ProductPrice{ProductID: 1, Price: MustParseMoney("13.50"), CurrencyCode: "EUR"},
+	},
+	{
+		name: "Trailing zeros beyond the minor unit are fine",
+		in:   priceUpdate{Value: moneyPointer("1500.000"), CurrencyCode: stringPointer("JPY")},
+		want: ProductPrice{ProductID: 1, Price: MustParseMoney("1500"), CurrencyCode: "JPY"},
+	},
+	{
+		name: "Zero is allowed",
+		in:   priceUpdate{Value: moneyPointer("0"), CurrencyCode: stringPointer("USD")},
+		want: ProductPrice{ProductID: 1, Price: MustParseMoney("0.00"), CurrencyCode: "USD"},
+	},
+	{
+		name:     "Too precise for the currency",
+		in:       priceUpdate{Value: moneyPointer("1.5"), CurrencyCode: stringPointer("JPY")},
+		wantErrs: []FieldError{{Field: "value", Reason: "JPY allows at most 0 decimal places"}},
+	},
+	{
+		name:     "Negative",
+		in:       priceUpdate{Value: moneyPointer("-0.01"), CurrencyCode: stringPointer("USD")},
+		wantErrs: []FieldError{{Field: "value", Reason: "must not be negative"}},
+	},
+	{
+		name: "Missing everything",
+		in:   priceUpdate{},
+		wantErrs: []FieldError{
+			{Field: "currency_code", Reason: "missing"},
+			{Field: "value", Reason: "missing"},
+		},
+	},
+}
+
+func TestPriceUpdateToProductPrice(t *testing.T) {
+	for _, tt := range priceUpdateTests {
+		t.Run(tt.name, func(t *testing.T) {
+			price, errs := tt.in.toProductPrice(1)
+
+			if !reflect.DeepEqual(errs, tt.wantErrs) {
+				t.Errorf("got errors %+v, want %+v", errs, tt.wantErrs)
+			}
+
+			if len(tt.wantErrs) == 0 && (price.Price.String() != tt.want.Price.String() || price.CurrencyCode != tt.want.CurrencyCode || price.ProductID != tt.want.ProductID) {
+				t.Errorf("got %+v, want %+v", price, tt.want)
+			}
+		})
+	}
+}